@@ -1,231 +1,1033 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"net/http"
 	"sort"
+	"strconv"
+	"time"
 
 	"github.com/20170819lgg/sts/core"
 	"github.com/20170819lgg/sts/db"
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/pkg/errors"
 )
 
 type apiResponse struct {
 	status int
 	msg    string
+	// err is the cause of failed responses
+	err      error
+	replayed bool
 }
 
 type application struct {
-	db *sql.DB
+	store db.Store
 }
 
-func respOK() *apiResponse                 { return &apiResponse{status: http.StatusNoContent} }
-func respConflict(msg string) *apiResponse { return &apiResponse{status: http.StatusConflict, msg: msg} }
+func respOK() *apiResponse { return &apiResponse{status: http.StatusNoContent} }
+func respError(err error) *apiResponse {
+	return &apiResponse{status: errorStatus(err), msg: err.Error(), err: err}
+}
+
+// respJSON returns successful response with data encoded as JSON response
+// body.
+func respJSON(status int, data interface{}) (*apiResponse, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.WithMessage(err, "marshaling response")
+	}
+	return &apiResponse{status: status, msg: string(body)}, nil
+}
 
-func newApplication(db *sql.DB) *application {
+func newApplication(store db.Store) *application {
 	return &application{
-		db: db,
+		store: store,
 	}
 }
 
-func (a *application) addPoints(playerID string, points int64) (*apiResponse, error) {
-	tx, err := a.db.Begin()
+// view runs body in a read only transaction.
+func (a *application) view(ctx context.Context, body func(db.Tx) error) error {
+	return db.Transaction(ctx, a.store, func(tx db.Tx) error {
+		if err := body(tx); err != nil {
+			return err
+		}
+		return db.ErrRollback
+	})
+}
+
+// presenter builds response of a successful change from the state of the
+// transaction which made the change.
+type presenter func(tx db.Tx) (*apiResponse, error)
+
+// transaction runs body in a single database transaction. Transaction is
+// committed only if body returns successful response. Responses without
+// content are replaced by the response of present, if given. If idempotency
+// key is given, it is stored together with the successful response in the
+// same transaction and repeated requests with the same key get the stored
+// response without running body again. Body is run again if the transaction
+// is retried.
+func (a *application) transaction(ctx context.Context, key *core.IdempotencyKey, present presenter, body func(db.Tx) (*apiResponse, error)) (*apiResponse, error) {
+	var resp *apiResponse
+	replay := false
+	err := db.Transaction(ctx, a.store, func(tx db.Tx) error {
+		resp, replay = nil, false
+		if key != nil {
+			// concurrent requests with the same key are blocked here
+			// until the first one is finished
+			k := *key
+			switch err := tx.IdempotencyKeyInsert(&k); err {
+			case nil:
+				// OK
+			case db.ErrAlreadyExists:
+				replay = true
+				return db.ErrRollback
+			default:
+				return errors.WithMessage(err, "inserting idempotency key")
+			}
+		}
+
+		var err error
+		if resp, err = body(tx); err != nil {
+			return err
+		}
+		if resp.status >= http.StatusMultipleChoices {
+			return db.ErrRollback
+		}
+		if present != nil && resp.status == http.StatusNoContent {
+			if resp, err = present(tx); err != nil {
+				return err
+			}
+		}
+
+		if key != nil {
+			k := *key
+			k.Status = resp.status
+			k.Body = resp.msg
+			if err := tx.IdempotencyKeyUpdate(&k); err != nil {
+				return errors.WithMessage(err, "updating idempotency key")
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, errors.WithMessage(err, "starting transaction")
+		return nil, err
 	}
-	defer tx.Rollback()
+	if replay {
+		return a.replay(ctx, key)
+	}
+	return resp, nil
+}
 
-	player, err := db.PlayerGetForUpdate(tx, playerID)
-	switch err {
+// replay returns response stored for already processed idempotency key.
+func (a *application) replay(ctx context.Context, key *core.IdempotencyKey) (*apiResponse, error) {
+	var stored *core.IdempotencyKey
+	err := a.view(ctx, func(tx db.Tx) (err error) {
+		stored, err = tx.IdempotencyKeyGet(key.Client, key.Key)
+		return err
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "getting idempotency key")
+	}
+	if err := stored.Check(key.Fingerprint); err != nil {
+		return respError(err), nil
+	}
+	return &apiResponse{
+		status:   stored.Status,
+		msg:      stored.Body,
+		replayed: true,
+	}, nil
+}
+
+func (a *application) addPoints(ctx context.Context, key *core.IdempotencyKey, present presenter, playerID string, points int64) (*apiResponse, error) {
+	return a.transaction(ctx, key, present, func(tx db.Tx) (*apiResponse, error) {
+		player, err := tx.PlayerGetForUpdate(playerID)
+		switch err {
+		case nil:
+			// OK
+		case db.ErrNotFound:
+			player = &core.Player{
+				PlayerID: playerID,
+				Balance:  0,
+			}
+			if err := tx.PlayerInsert(player); err != nil {
+				return nil, errors.WithMessage(err, "inserting player")
+			}
+		default:
+			return nil, errors.WithMessage(err, "getting player for update")
+		}
+
+		if err := player.AddBalance(points); err != nil {
+			return respError(err), nil
+		}
+
+		if err := tx.PlayerUpdate(player); err != nil {
+			return nil, errors.WithMessage(err, "updating player")
+		}
+		entry, err := core.NewBalanceEntry(playerID, points)
+		if err != nil {
+			return nil, errors.WithMessage(err, "creating ledger entry")
+		}
+		if err := tx.LedgerEntryInsert(entry); err != nil {
+			return nil, errors.WithMessage(err, "inserting ledger entry")
+		}
+		return respOK(), nil
+	})
+}
+
+func (a *application) balance(ctx context.Context, playerID string) (*core.Player, error) {
+	var player *core.Player
+	err := a.view(ctx, func(tx db.Tx) (err error) {
+		player, err = tx.PlayerGet(playerID)
+		return err
+	})
+	switch errors.Cause(err) {
 	case nil:
-		// OK
+		return player, nil
 	case db.ErrNotFound:
-		player = &core.Player{
-			PlayerID: playerID,
-			Balance:  0,
+		return nil, nil
+	default:
+		return nil, errors.WithMessage(err, "getting player")
+	}
+}
+
+// transferPage is a single page of player transaction history.
+type transferPage struct {
+	PlayerID     string          `json:"playerId"`
+	Transactions []core.Transfer `json:"transactions"`
+	NextCursor   string          `json:"nextCursor,omitempty"`
+}
+
+// encodeCursor converts transfer log position into opaque pagination cursor.
+func encodeCursor(transferID int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(transferID, 10)))
+}
+
+// decodeCursor converts opaque pagination cursor back to transfer log
+// position.
+func decodeCursor(cursor string) (int64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errors.New("invalid cursor")
+	}
+	return id, nil
+}
+
+// playerTransactions returns a page of player transfer log records matching
+// the filter. Nil page is returned if player is not found.
+func (a *application) playerTransactions(ctx context.Context, f db.TransferFilter) (*transferPage, error) {
+	// query single extra record to find out if there is a next page
+	limit := f.Limit
+	f.Limit++
+	var transfers []core.Transfer
+	err := a.view(ctx, func(tx db.Tx) error {
+		if _, err := tx.PlayerGet(f.PlayerID); err != nil {
+			return err
 		}
-		if err := db.PlayerInsert(tx, player); err != nil {
-			return nil, errors.WithMessage(err, "inserting player")
+		var err error
+		if transfers, err = tx.TransferLogSelect(f); err != nil {
+			return errors.WithMessage(err, "selecting transfer log")
 		}
+		return nil
+	})
+	switch errors.Cause(err) {
+	case nil:
+		// OK
+	case db.ErrNotFound:
+		return nil, nil
 	default:
-		return nil, errors.WithMessage(err, "getting player for update")
+		return nil, err
 	}
 
-	if err := player.AddBalance(points); err != nil {
-		return respConflict(err.Error()), nil
+	page := &transferPage{
+		PlayerID:     f.PlayerID,
+		Transactions: transfers,
 	}
-
-	if err := db.PlayerUpdate(tx, player); err != nil {
-		return nil, errors.WithMessage(err, "updating player")
+	if uint64(len(transfers)) > limit {
+		page.Transactions = transfers[:limit]
+		page.NextCursor = encodeCursor(transfers[limit-1].ID)
 	}
-	if err := tx.Commit(); err != nil {
-		return nil, errors.WithMessage(err, "committing transaction")
+	if page.Transactions == nil {
+		page.Transactions = []core.Transfer{}
 	}
-	return respOK(), nil
+	return page, nil
 }
 
-func (a *application) balance(playerID string) (*core.Player, error) {
-	player, err := db.PlayerGet(a.db, playerID)
-	switch err {
+// playerBackings returns portfolio of tournament players backed by the
+// player within the optional time range. Nil portfolio is returned if player
+// is not found.
+func (a *application) playerBackings(ctx context.Context, playerID string, from, to time.Time) (*core.BackingPortfolio, error) {
+	var backings []core.Backing
+	err := a.view(ctx, func(tx db.Tx) error {
+		if _, err := tx.PlayerGet(playerID); err != nil {
+			return err
+		}
+		var err error
+		if backings, err = tx.BackingList(playerID, from, to); err != nil {
+			return errors.WithMessage(err, "listing backings")
+		}
+		return nil
+	})
+	switch errors.Cause(err) {
 	case nil:
-		return player, nil
+		return core.NewBackingPortfolio(playerID, backings), nil
 	case db.ErrNotFound:
 		return nil, nil
 	default:
-		return nil, errors.WithMessage(err, "getting player")
+		return nil, err
 	}
 }
 
-func (a *application) announceTournament(tournamentID int, deposit int64) (*apiResponse, error) {
+// announceOptions holds optional tournament settings given at announce time.
+type announceOptions struct {
+	RakePercent      int
+	HouseFee         int64
+	Payout           *core.PayoutStructure
+	OpenRegistration bool
+}
+
+func (a *application) announceTournament(ctx context.Context, key *core.IdempotencyKey, present presenter, tournamentID int, deposit int64, opts announceOptions) (*apiResponse, error) {
 	tournament, err := core.NewTournament(tournamentID, deposit)
 	if err != nil {
-		return respConflict(err.Error()), nil
+		return respError(err), nil
+	}
+	if err := tournament.SetRake(opts.RakePercent, opts.HouseFee); err != nil {
+		return respError(err), nil
+	}
+	if err := tournament.SetPayout(opts.Payout); err != nil {
+		return respError(err), nil
 	}
-	switch db.TournamentInsert(a.db, tournament) {
+	if opts.OpenRegistration {
+		if err := tournament.Transition(core.TournamentRegistrationOpen); err != nil {
+			return respError(err), nil
+		}
+	}
+	return a.transaction(ctx, key, present, func(tx db.Tx) (*apiResponse, error) {
+		switch err := tx.TournamentInsert(tournament); err {
+		case nil:
+			return respOK(), nil
+		case db.ErrAlreadyExists:
+			return respError(core.ErrDuplicateTournament), nil
+		default:
+			return nil, errors.WithMessage(err, "inserting tournament")
+		}
+	})
+}
+
+// tournament returns tournament by its ID, nil is returned if tournament is
+// not found.
+func (a *application) tournament(ctx context.Context, tournamentID int) (*core.Tournament, error) {
+	var tournament *core.Tournament
+	err := a.view(ctx, func(tx db.Tx) (err error) {
+		tournament, err = tx.TournamentGet(tournamentID)
+		return err
+	})
+	switch errors.Cause(err) {
 	case nil:
-		return respOK(), nil
-	case db.ErrAlreadyExists:
-		return respConflict(core.ErrDuplicateTournament.Error()), nil
+		return tournament, nil
+	case db.ErrNotFound:
+		return nil, nil
 	default:
-		return nil, errors.WithMessage(err, "inserting tournament")
+		return nil, errors.WithMessage(err, "getting tournament")
 	}
 }
 
-func (a *application) joinTournament(tournamentID int, playerID string, backerIDs []string) (*apiResponse, error) {
-	tx, err := a.db.Begin()
-	if err != nil {
-		return nil, errors.WithMessage(err, "starting transaction")
+// transitionTournament moves tournament to a given lifecycle state.
+func (a *application) transitionTournament(ctx context.Context, key *core.IdempotencyKey, present presenter, tournamentID int, state core.TournamentState) (*apiResponse, error) {
+	return a.transaction(ctx, key, present, func(tx db.Tx) (*apiResponse, error) {
+		tournament, err := tx.TournamentGetForUpdate(tournamentID)
+		switch err {
+		case nil:
+			// OK
+		case db.ErrNotFound:
+			return respError(core.ErrTournamentNotFound), nil
+		default:
+			return nil, errors.WithMessage(err, "getting tournament for update")
+		}
+
+		if err := tournament.Transition(state); err != nil {
+			return respError(err), nil
+		}
+		if err := tx.TournamentUpdate(tournament); err != nil {
+			return nil, errors.WithMessage(err, "updating tournament")
+		}
+		if state != core.TournamentRegistrationOpen {
+			if err := tx.BackingRequestExpire(tournamentID); err != nil {
+				return nil, errors.WithMessage(err, "expiring backing requests")
+			}
+		}
+		return respOK(), nil
+	})
+}
+
+// stakeUnit tells how custom stakes given when joining a tournament are
+// expressed.
+type stakeUnit string
+
+const (
+	stakePoints      stakeUnit = "points"
+	stakeBasisPoints stakeUnit = "bps"
+)
+
+// newTournPlayer creates tournament player with stakes of the player and its
+// backers given in unit, the deposit is split equally if no stakes are given.
+func newTournPlayer(t *core.Tournament, playerID string, backerIDs []string, stakes []int64, unit stakeUnit) (*core.TournPlayer, error) {
+	if len(stakes) == 0 {
+		return t.NewTournPlayer(playerID, backerIDs)
+	}
+	playerIDs := append([]string{playerID}, backerIDs...)
+	if unit == stakeBasisPoints {
+		backers, err := t.StakesFromBasisPoints(playerIDs, stakes)
+		if err != nil {
+			return nil, err
+		}
+		return t.NewStakedTournPlayer(playerID, backers)
 	}
-	defer tx.Rollback()
+	if len(playerIDs) != len(stakes) {
+		return nil, core.ErrInvalidStakes
+	}
+	backers := make([]core.Backer, len(stakes))
+	for i, pts := range stakes {
+		backers[i] = core.Backer{PlayerID: playerIDs[i], Points: pts}
+	}
+	return t.NewStakedTournPlayer(playerID, backers)
+}
 
-	tournament, err := db.TournamentGet(tx, tournamentID)
-	switch err {
+// joinTournament joins player to the tournament. Players joining with
+// backers only propose a backing request, they join once all backers accept
+// it.
+func (a *application) joinTournament(ctx context.Context, key *core.IdempotencyKey, present presenter, tournamentID int, playerID string, backerIDs []string, stakes []int64, unit stakeUnit) (*apiResponse, error) {
+	return a.transaction(ctx, key, present, func(tx db.Tx) (*apiResponse, error) {
+		tournament, err := tx.TournamentGetForUpdate(tournamentID)
+		switch err {
+		case nil:
+			// OK
+		case db.ErrNotFound:
+			return respError(core.ErrTournamentNotFound), nil
+		default:
+			return nil, errors.WithMessage(err, "getting tournament for update")
+		}
+
+		tp, err := newTournPlayer(tournament, playerID, backerIDs, stakes, unit)
+		if err != nil {
+			return respError(err), nil
+		}
+		if len(backerIDs) == 0 {
+			return addTournPlayer(tx, tournament, tp)
+		}
+
+		switch _, err := tx.TournPlayerGet(tournamentID, playerID); err {
+		case nil:
+			return respError(core.ErrDuplicateTournPlayer), nil
+		case db.ErrNotFound:
+			// OK
+		default:
+			return nil, errors.WithMessage(err, "getting tournament player")
+		}
+		players, err := tx.PlayerSelectForUpdate(append([]string{playerID}, backerIDs...))
+		if err != nil {
+			return nil, errors.WithMessage(err, "getting players")
+		}
+		if len(players) != len(backerIDs)+1 {
+			return respError(core.ErrPlayerNotFound), nil
+		}
+
+		req := tp.NewBackingRequest()
+		if err := tx.BackingRequestInsert(req); err != nil {
+			return nil, errors.WithMessage(err, "inserting backing request")
+		}
+		return respJSON(http.StatusAccepted, req)
+	})
+}
+
+// tournPlayer returns player joined to the tournament, nil is returned if
+// player is not joined.
+func (a *application) tournPlayer(ctx context.Context, tournamentID int, playerID string) (*core.TournPlayer, error) {
+	var tp *core.TournPlayer
+	err := a.view(ctx, func(tx db.Tx) (err error) {
+		tp, err = tx.TournPlayerGet(tournamentID, playerID)
+		return err
+	})
+	switch errors.Cause(err) {
 	case nil:
-		// OK
+		return tp, nil
 	case db.ErrNotFound:
-		return respConflict(core.ErrTournamentNotFound.Error()), nil
+		return nil, nil
 	default:
-		return nil, errors.WithMessage(err, "getting tournament")
+		return nil, errors.WithMessage(err, "getting tournament player")
 	}
-	playerIDs := append([]string{playerID}, backerIDs...)
-	players, err := db.PlayerSelectForUpdate(tx, playerIDs)
+}
+
+// addTournPlayer deducts deposits of tournament player and its backers and
+// stores the tournament player. Tournament must be locked by the caller.
+func addTournPlayer(tx db.Tx, tournament *core.Tournament, tp *core.TournPlayer) (*apiResponse, error) {
+	playerIDs := make([]string, len(tp.Backers))
+	for i, b := range tp.Backers {
+		playerIDs[i] = b.PlayerID
+	}
+	players, err := tx.PlayerSelectForUpdate(playerIDs)
 	if err != nil {
 		return nil, errors.WithMessage(err, "getting players for update")
 	}
 
-	tp, err := tournament.NewTournPlayer(playerID, backerIDs)
+	entry, err := tp.DeductDeposit(players, tournament)
 	if err != nil {
-		return respConflict(err.Error()), nil
+		return respError(err), nil
 	}
 
-	if err := tp.DeductDeposit(players); err != nil {
-		return respConflict(err.Error()), nil
-	}
-
-	err = db.TournPlayerInsert(tx, tp)
+	err = tx.TournPlayerInsert(tp)
 	switch err {
 	case nil:
 		// OK
 	case db.ErrAlreadyExists:
-		return respConflict(core.ErrDuplicateTournPlayer.Error()), nil
+		return respError(core.ErrDuplicateTournPlayer), nil
 	default:
 		return nil, errors.WithMessage(err, "inserting tournament player")
 	}
 
 	for _, acc := range players {
-		if err := db.PlayerUpdate(tx, acc); err != nil {
+		if err := tx.PlayerUpdate(acc); err != nil {
 			return nil, errors.WithMessage(err, "updating player balance")
 		}
 	}
-
-	if err := tx.Commit(); err != nil {
-		return nil, errors.WithMessage(err, "committing transaction")
+	if err := tx.TournamentUpdate(tournament); err != nil {
+		return nil, errors.WithMessage(err, "updating tournament")
+	}
+	if err := tx.LedgerEntryInsert(entry); err != nil {
+		return nil, errors.WithMessage(err, "inserting ledger entry")
 	}
+
 	return respOK(), nil
 }
 
-func (a *application) resultTroutnament(tournamentID int, winners map[string]int64) (*apiResponse, error) {
-	tx, err := a.db.Begin()
-	if err != nil {
-		return nil, errors.WithMessage(err, "starting transaction")
-	}
-	defer tx.Rollback()
+// respondBacking records backer response to the backing request. Player joins
+// the tournament when the last backer accepts the request.
+func (a *application) respondBacking(ctx context.Context, key *core.IdempotencyKey, present presenter, requestID int64, backerID string, accept bool) (*apiResponse, error) {
+	return a.transaction(ctx, key, present, func(tx db.Tx) (*apiResponse, error) {
+		req, err := tx.BackingRequestGet(requestID)
+		switch err {
+		case nil:
+			// OK
+		case db.ErrNotFound:
+			return respError(core.ErrBackingRequestNotFound), nil
+		default:
+			return nil, errors.WithMessage(err, "getting backing request")
+		}
 
-	tournament, err := db.TournamentGetForUpdate(tx, tournamentID)
-	switch err {
+		// backing requests are changed only while holding tournament lock,
+		// request is locked after the tournament to keep lock order
+		tournament, err := tx.TournamentGetForUpdate(req.TournamentID)
+		if err != nil {
+			return nil, errors.WithMessage(err, "getting tournament for update")
+		}
+		if req, err = tx.BackingRequestGetForUpdate(requestID); err != nil {
+			return nil, errors.WithMessage(err, "getting backing request for update")
+		}
+
+		resp := respOK()
+		if accept {
+			tp, err := req.Accept(backerID, tournament)
+			if err != nil {
+				return respError(err), nil
+			}
+			if tp != nil {
+				if resp, err = addTournPlayer(tx, tournament, tp); err != nil || resp.status != http.StatusNoContent {
+					return resp, err
+				}
+			}
+		} else if err := req.Decline(backerID, tournament); err != nil {
+			return respError(err), nil
+		}
+
+		if err := tx.BackingRequestUpdate(req); err != nil {
+			return nil, errors.WithMessage(err, "updating backing request")
+		}
+		return resp, nil
+	})
+}
+
+// backingRequest returns backing request by its ID, nil is returned if
+// request is not found.
+func (a *application) backingRequest(ctx context.Context, requestID int64) (*core.BackingRequest, error) {
+	var req *core.BackingRequest
+	err := a.view(ctx, func(tx db.Tx) (err error) {
+		req, err = tx.BackingRequestGet(requestID)
+		return err
+	})
+	switch errors.Cause(err) {
 	case nil:
-		// OK
+		return req, nil
 	case db.ErrNotFound:
-		return respConflict(core.ErrTournamentNotFound.Error()), nil
+		return nil, nil
 	default:
-		return nil, errors.WithMessage(err, "getting tournament for update")
+		return nil, errors.WithMessage(err, "getting backing request")
 	}
+}
 
-	if err := tournament.MarkFinished(); err != nil {
-		return respConflict(err.Error()), nil
+// playerBackingRequests returns pending backing requests proposed by or
+// waiting for consent of a given player.
+func (a *application) playerBackingRequests(ctx context.Context, playerID string) ([]core.BackingRequest, error) {
+	var reqs []core.BackingRequest
+	err := a.view(ctx, func(tx db.Tx) (err error) {
+		reqs, err = tx.BackingRequestListPending(playerID)
+		return err
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "listing backing requests")
 	}
-	if err := db.TournamentUpdate(tx, tournament); err != nil {
-		return nil, errors.WithMessage(err, "updating tournament")
+	if reqs == nil {
+		reqs = []core.BackingRequest{}
 	}
+	return reqs, nil
+}
 
-	tws := make([]*core.TournWinner, 0, len(winners))
-	playerIDs := sort.StringSlice{}
-	for playerID, prize := range winners {
-		tp, err := db.TournPlayerGet(tx, tournamentID, playerID)
+// leaveTournament withdraws player from the tournament and refunds deposits to
+// the player and its backers. Tournament row is locked to serialize withdrawal
+// with tournament state changes and resulting.
+func (a *application) leaveTournament(ctx context.Context, key *core.IdempotencyKey, tournamentID int, playerID string) (*apiResponse, error) {
+	return a.transaction(ctx, key, nil, func(tx db.Tx) (*apiResponse, error) {
+		tournament, err := tx.TournamentGetForUpdate(tournamentID)
 		switch err {
 		case nil:
 			// OK
 		case db.ErrNotFound:
-			return respConflict(core.ErrTournPlayerNotFound.Error()), nil
+			return respError(core.ErrTournamentNotFound), nil
+		default:
+			return nil, errors.WithMessage(err, "getting tournament for update")
+		}
+
+		tp, err := tx.TournPlayerGet(tournamentID, playerID)
+		switch err {
+		case nil:
+			// OK
+		case db.ErrNotFound:
+			return respError(core.ErrTournPlayerNotFound), nil
 		default:
 			return nil, errors.WithMessage(err, "getting tournament player")
 		}
 
-		tw, err := tp.NewTournWinner(prize)
+		playerIDs := make([]string, len(tp.Backers))
+		for i, b := range tp.Backers {
+			playerIDs[i] = b.PlayerID
+		}
+		players, err := tx.PlayerSelectForUpdate(playerIDs)
 		if err != nil {
-			return respConflict(err.Error()), nil
+			return nil, errors.WithMessage(err, "getting players for update")
 		}
 
-		for _, b := range tw.Backers {
-			playerIDs = append(playerIDs, b.PlayerID)
+		transfers, err := stakeTransfers(tx, tournamentID)
+		if err != nil {
+			return nil, err
+		}
+		sales := tp.ReturnSoldStakes(transfers)
+		entry, err := tp.Withdraw(players, tournament)
+		if err != nil {
+			return respError(err), nil
+		}
+		entries := []*core.LedgerEntry{entry}
+		if len(sales) > 0 {
+			entry, err := tp.RefundStakeSales(sales, players)
+			if err != nil {
+				return respError(err), nil
+			}
+			entries = append(entries, entry)
+		}
+
+		if err := tx.TournPlayerDelete(tp); err != nil {
+			return nil, errors.WithMessage(err, "deleting tournament player")
+		}
+		for _, acc := range players {
+			if err := tx.PlayerUpdate(acc); err != nil {
+				return nil, errors.WithMessage(err, "updating player balance")
+			}
+		}
+		if err := tx.TournamentUpdate(tournament); err != nil {
+			return nil, errors.WithMessage(err, "updating tournament")
+		}
+		for _, entry := range entries {
+			if err := tx.LedgerEntryInsert(entry); err != nil {
+				return nil, errors.WithMessage(err, "inserting ledger entry")
+			}
 		}
-		tws = append(tws, tw)
-	}
 
-	// retrieve all player accounts in single query to prevent deadlocks
-	// between multiple tournament resulting requests
-	players, err := db.PlayerSelectForUpdate(tx, playerIDs)
+		return respOK(), nil
+	})
+}
+
+// stakeTransfers returns transfer log records of deposits and stake sales of
+// the tournament, from which sales to reverse on refunds are found.
+func stakeTransfers(tx db.Tx, tournamentID int) ([]core.Transfer, error) {
+	transfers, err := tx.TransferLogSelect(db.TransferFilter{
+		TournamentID: tournamentID,
+		Ops: []core.TransferOp{
+			core.TransferDeposit,
+			core.TransferRefund,
+			core.TransferStakeSale,
+			core.TransferStakeRefund,
+		},
+	})
 	if err != nil {
-		return nil, errors.WithMessage(err, "getting player accounts")
+		return nil, errors.WithMessage(err, "selecting stake transfers")
 	}
+	return transfers, nil
+}
 
-	for _, tw := range tws {
-		if err := tw.PayoutPrize(players); err != nil {
-			return respConflict(err.Error()), nil
-		}
-		if err := db.TournamentWinnerInsert(tx, tw); err != nil {
-			return nil, errors.WithMessage(err, "inserting tournament winner")
+// offerStake lists part of tournament player's own stake for sale.
+func (a *application) offerStake(ctx context.Context, key *core.IdempotencyKey, tournamentID int, playerID string, available, markup int64) (*apiResponse, error) {
+	return a.transaction(ctx, key, nil, func(tx db.Tx) (*apiResponse, error) {
+		tournament, err := tx.TournamentGetForUpdate(tournamentID)
+		switch err {
+		case nil:
+			// OK
+		case db.ErrNotFound:
+			return respError(core.ErrTournamentNotFound), nil
+		default:
+			return nil, errors.WithMessage(err, "getting tournament for update")
 		}
-	}
-	for _, acc := range players {
-		if err := db.PlayerUpdate(tx, acc); err != nil {
-			return nil, errors.WithMessage(err, "updating player account")
+
+		tp, err := tx.TournPlayerGet(tournamentID, playerID)
+		switch err {
+		case nil:
+			// OK
+		case db.ErrNotFound:
+			return respError(core.ErrTournPlayerNotFound), nil
+		default:
+			return nil, errors.WithMessage(err, "getting tournament player")
 		}
-	}
 
-	if err := tx.Commit(); err != nil {
-		return nil, errors.WithMessage(err, "committing transaction")
-	}
-	return respOK(), nil
+		offer, err := tp.NewStakeOffer(tournament, available, markup)
+		if err != nil {
+			return respError(err), nil
+		}
+		switch err := tx.StakeOfferInsert(offer); err {
+		case nil:
+			return respJSON(http.StatusCreated, offer)
+		case db.ErrAlreadyExists:
+			return respError(core.ErrDuplicateStakeOffer), nil
+		default:
+			return nil, errors.WithMessage(err, "inserting stake offer")
+		}
+	})
 }
 
-func (a *application) reset(dsn string) error {
-	if err := db.RecreateDB(dsn); err != nil {
+// stakeOffers returns available stake offers of a tournament.
+func (a *application) stakeOffers(ctx context.Context, tournamentID int) ([]core.StakeOffer, error) {
+	var offers []core.StakeOffer
+	err := a.view(ctx, func(tx db.Tx) (err error) {
+		offers, err = tx.StakeOfferList(tournamentID)
 		return err
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "listing stake offers")
+	}
+	if offers == nil {
+		offers = []core.StakeOffer{}
 	}
-	if err := db.CreateSchema(a.db); err != nil {
+	return offers, nil
+}
+
+// stakeOffer returns stake offer by its ID, nil is returned if offer is not
+// found.
+func (a *application) stakeOffer(ctx context.Context, offerID int64) (*core.StakeOffer, error) {
+	var offer *core.StakeOffer
+	err := a.view(ctx, func(tx db.Tx) (err error) {
+		offer, err = tx.StakeOfferGet(offerID)
 		return err
+	})
+	switch errors.Cause(err) {
+	case nil:
+		return offer, nil
+	case db.ErrNotFound:
+		return nil, nil
+	default:
+		return nil, errors.WithMessage(err, "getting stake offer")
 	}
-	return nil
+}
+
+// buyStake sells basis points of offered stake to the buyer. Tournament, the
+// offer and the tournament player are locked, so concurrent purchases can not
+// oversell the offer or overwrite backers of each other.
+func (a *application) buyStake(ctx context.Context, key *core.IdempotencyKey, present presenter, offerID int64, buyerID string, bps int64) (*apiResponse, error) {
+	return a.transaction(ctx, key, present, func(tx db.Tx) (*apiResponse, error) {
+		offer, err := tx.StakeOfferGet(offerID)
+		switch err {
+		case nil:
+			// OK
+		case db.ErrNotFound:
+			return respError(core.ErrStakeOfferNotFound), nil
+		default:
+			return nil, errors.WithMessage(err, "getting stake offer")
+		}
+
+		// offer is locked after the tournament to keep lock order
+		tournament, err := tx.TournamentGetForUpdate(offer.TournamentID)
+		if err != nil {
+			return nil, errors.WithMessage(err, "getting tournament for update")
+		}
+		switch offer, err = tx.StakeOfferGetForUpdate(offerID); err {
+		case nil:
+			// OK
+		case db.ErrNotFound:
+			return respError(core.ErrStakeOfferNotFound), nil
+		default:
+			return nil, errors.WithMessage(err, "getting stake offer for update")
+		}
+		// offer read above may be older than the locks, backers are read
+		// by a locking read so that concurrent purchases are not lost
+		tp, err := tx.TournPlayerGetForUpdate(offer.TournamentID, offer.PlayerID)
+		switch err {
+		case nil:
+			// OK
+		case db.ErrNotFound:
+			return respError(core.ErrTournPlayerNotFound), nil
+		default:
+			return nil, errors.WithMessage(err, "getting tournament player for update")
+		}
+		players, err := tx.PlayerSelectForUpdate([]string{offer.PlayerID, buyerID})
+		if err != nil {
+			return nil, errors.WithMessage(err, "getting players for update")
+		}
+
+		entry, err := offer.Buy(tp, tournament, buyerID, bps, players)
+		if err != nil {
+			return respError(err), nil
+		}
+
+		if err := tx.StakeOfferUpdate(offer); err != nil {
+			return nil, errors.WithMessage(err, "updating stake offer")
+		}
+		if err := tx.TournPlayerUpdate(tp); err != nil {
+			return nil, errors.WithMessage(err, "updating tournament player")
+		}
+		for _, acc := range players {
+			if err := tx.PlayerUpdate(acc); err != nil {
+				return nil, errors.WithMessage(err, "updating player balance")
+			}
+		}
+		if err := tx.LedgerEntryInsert(entry); err != nil {
+			return nil, errors.WithMessage(err, "inserting ledger entry")
+		}
+		return respOK(), nil
+	})
+}
+
+// resultTroutnament pays out prizes to tournament winners. Prizes are either
+// given explicitly by winners or computed by the tournament payout structure
+// from finishing places. Sum of prizes must not exceed the tournament prize
+// pool unless overlay is set, in which case the difference is covered by the
+// house. Prize pool not claimed by prizes is collected by the house with the
+// rake.
+func (a *application) resultTroutnament(ctx context.Context, key *core.IdempotencyKey, present presenter, tournamentID int, winners map[string]int64, places []core.Place, overlay bool) (*apiResponse, error) {
+	return a.transaction(ctx, key, present, func(tx db.Tx) (*apiResponse, error) {
+		tournament, err := tx.TournamentGetForUpdate(tournamentID)
+		switch err {
+		case nil:
+			// OK
+		case db.ErrNotFound:
+			return respError(core.ErrTournamentNotFound), nil
+		default:
+			return nil, errors.WithMessage(err, "getting tournament for update")
+		}
+
+		if err := tournament.MarkFinished(); err != nil {
+			return respError(err), nil
+		}
+
+		if len(places) > 0 {
+			tps, err := tx.TournPlayerList(tournamentID)
+			if err != nil {
+				return nil, errors.WithMessage(err, "listing tournament players")
+			}
+			winners, err = tournament.PlacePrizes(places, len(tps))
+			if err != nil {
+				return respError(err), nil
+			}
+		}
+
+		var total int64
+		for _, prize := range winners {
+			total += prize
+		}
+		if err := tournament.CheckPrizes(total, overlay); err != nil {
+			return respError(err), nil
+		}
+		rake, err := tournament.CollectRake(total)
+		if err != nil {
+			return respError(err), nil
+		}
+		if rake != nil {
+			if err := tx.LedgerEntryInsert(rake); err != nil {
+				return nil, errors.WithMessage(err, "inserting ledger entry")
+			}
+		}
+
+		// pay out winners in player ID order to keep ledger deterministic
+		winnerIDs := make([]string, 0, len(winners))
+		for playerID := range winners {
+			winnerIDs = append(winnerIDs, playerID)
+		}
+		sort.Strings(winnerIDs)
+
+		tws := make([]*core.TournWinner, 0, len(winners))
+		playerIDs := sort.StringSlice{}
+		for _, playerID := range winnerIDs {
+			tp, err := tx.TournPlayerGet(tournamentID, playerID)
+			switch err {
+			case nil:
+				// OK
+			case db.ErrNotFound:
+				return respError(core.ErrTournPlayerNotFound), nil
+			default:
+				return nil, errors.WithMessage(err, "getting tournament player")
+			}
+
+			tw, err := tp.NewTournWinner(winners[playerID])
+			if err != nil {
+				return respError(err), nil
+			}
+
+			for _, b := range tw.Backers {
+				playerIDs = append(playerIDs, b.PlayerID)
+			}
+			tws = append(tws, tw)
+		}
+
+		// retrieve all player accounts in single query to prevent deadlocks
+		// between multiple tournament resulting requests
+		players, err := tx.PlayerSelectForUpdate(playerIDs)
+		if err != nil {
+			return nil, errors.WithMessage(err, "getting player accounts")
+		}
+
+		for _, tw := range tws {
+			entry, err := tw.PayoutPrize(players, tournament)
+			if err != nil {
+				return respError(err), nil
+			}
+			if err := tx.TournamentWinnerInsert(tw); err != nil {
+				return nil, errors.WithMessage(err, "inserting tournament winner")
+			}
+			if err := tx.LedgerEntryInsert(entry); err != nil {
+				return nil, errors.WithMessage(err, "inserting ledger entry")
+			}
+		}
+		if err := tx.TournamentUpdate(tournament); err != nil {
+			return nil, errors.WithMessage(err, "updating tournament")
+		}
+		for _, acc := range players {
+			if err := tx.PlayerUpdate(acc); err != nil {
+				return nil, errors.WithMessage(err, "updating player account")
+			}
+		}
+
+		return respOK(), nil
+	})
+}
+
+func (a *application) cancelTournament(ctx context.Context, key *core.IdempotencyKey, present presenter, tournamentID int) (*apiResponse, error) {
+	return a.transaction(ctx, key, present, func(tx db.Tx) (*apiResponse, error) {
+		tournament, err := tx.TournamentGetForUpdate(tournamentID)
+		switch err {
+		case nil:
+			// OK
+		case db.ErrNotFound:
+			return respError(core.ErrTournamentNotFound), nil
+		default:
+			return nil, errors.WithMessage(err, "getting tournament for update")
+		}
+
+		if err := tournament.MarkCancelled(); err != nil {
+			return respError(err), nil
+		}
+		if err := tx.BackingRequestExpire(tournamentID); err != nil {
+			return nil, errors.WithMessage(err, "expiring backing requests")
+		}
+
+		tps, err := tx.TournPlayerList(tournamentID)
+		if err != nil {
+			return nil, errors.WithMessage(err, "listing tournament players")
+		}
+		var playerIDs []string
+		for _, tp := range tps {
+			for _, b := range tp.Backers {
+				playerIDs = append(playerIDs, b.PlayerID)
+			}
+		}
+
+		// retrieve all player accounts in single query to prevent deadlocks
+		players, err := tx.PlayerSelectForUpdate(playerIDs)
+		if err != nil {
+			return nil, errors.WithMessage(err, "getting player accounts")
+		}
+
+		transfers, err := stakeTransfers(tx, tournamentID)
+		if err != nil {
+			return nil, err
+		}
+		for _, tp := range tps {
+			sales := tp.ReturnSoldStakes(transfers)
+			entry, err := tp.Refund(players, tournament)
+			if err != nil {
+				return respError(err), nil
+			}
+			if err := tx.LedgerEntryInsert(entry); err != nil {
+				return nil, errors.WithMessage(err, "inserting ledger entry")
+			}
+			if len(sales) == 0 {
+				continue
+			}
+			if entry, err = tp.RefundStakeSales(sales, players); err != nil {
+				return respError(err), nil
+			}
+			if err := tx.LedgerEntryInsert(entry); err != nil {
+				return nil, errors.WithMessage(err, "inserting ledger entry")
+			}
+		}
+		for _, acc := range players {
+			if err := tx.PlayerUpdate(acc); err != nil {
+				return nil, errors.WithMessage(err, "updating player account")
+			}
+		}
+		if err := tx.TournamentUpdate(tournament); err != nil {
+			return nil, errors.WithMessage(err, "updating tournament")
+		}
+
+		return respOK(), nil
+	})
+}
+
+// reconcile replays the ledger and compares the result with stored player
+// balances, tournament pots and tournament winners.
+func (a *application) reconcile(ctx context.Context) (*core.ReconcileReport, error) {
+	var report *core.ReconcileReport
+	err := a.view(ctx, func(tx db.Tx) error {
+		players, err := tx.PlayerList()
+		if err != nil {
+			return errors.WithMessage(err, "listing players")
+		}
+		tournaments, err := tx.TournamentList()
+		if err != nil {
+			return errors.WithMessage(err, "listing tournaments")
+		}
+		winners, err := tx.TournamentWinnerList()
+		if err != nil {
+			return errors.WithMessage(err, "listing tournament winners")
+		}
+		sums, err := tx.LedgerSumsGet()
+		if err != nil {
+			return errors.WithMessage(err, "aggregating ledger")
+		}
+		report = core.Reconcile(players, tournaments, winners, sums)
+		return nil
+	})
+	return report, err
+}
+
+func (a *application) reset(ctx context.Context) error {
+	return a.store.Reset(ctx)
 }
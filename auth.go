@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// role tells which operations an authenticated client may perform.
+type role string
+
+const (
+	// roleAdmin and roleOperator may call every endpoint.
+	roleAdmin    role = "admin"
+	roleOperator role = "operator"
+	// rolePlayer may only act as the player it belongs to, see
+	// playerRoutes.
+	rolePlayer role = "player"
+)
+
+// principal is an authenticated API client.
+type principal struct {
+	role     role
+	playerID string
+}
+
+var (
+	errUnauthenticated = &apiError{
+		Code:    "UNAUTHENTICATED",
+		Status:  http.StatusUnauthorized,
+		Message: "missing or invalid credentials",
+	}
+	errForbidden = &apiError{
+		Code:    "FORBIDDEN",
+		Status:  http.StatusForbidden,
+		Message: "operation is not permitted",
+	}
+)
+
+type principalKey struct{}
+
+// principalOf returns client authenticated for the request the context
+// belongs to, nil is returned if requests are not authenticated.
+func principalOf(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+// newPrincipal validates role and player ID of a client.
+func newPrincipal(r role, playerID string) (*principal, error) {
+	switch r {
+	case roleAdmin, roleOperator:
+		return &principal{role: r}, nil
+	case rolePlayer:
+		if playerID == "" {
+			return nil, errors.New("player role requires player ID")
+		}
+		return &principal{role: r, playerID: playerID}, nil
+	default:
+		return nil, errors.Errorf("unknown role %q", r)
+	}
+}
+
+// authenticator authenticates requests by API keys given in X-Api-Key header
+// or by HS256 signed JWTs given as bearer tokens in Authorization header.
+type authenticator struct {
+	// apiKeys holds clients keyed by SHA-256 hashes of their API keys
+	apiKeys   map[[sha256.Size]byte]*principal
+	jwtSecret []byte
+	now       func() time.Time
+}
+
+// newAuthenticator creates authenticator from API keys given as
+// key=role or key=player:playerId pairs and JWT signing secret. Either keys
+// or the secret must be given.
+func newAuthenticator(keys []string, jwtSecret string) (*authenticator, error) {
+	if len(keys) == 0 && jwtSecret == "" {
+		return nil, errors.New("no API keys or JWT secret given")
+	}
+	a := &authenticator{
+		apiKeys:   make(map[[sha256.Size]byte]*principal),
+		jwtSecret: []byte(jwtSecret),
+		now:       time.Now,
+	}
+	for _, pair := range keys {
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 {
+			return nil, errors.New("invalid API key, must be key=role")
+		}
+		r, playerID := pair[i+1:], ""
+		if j := strings.Index(r, ":"); j >= 0 {
+			r, playerID = r[:j], r[j+1:]
+		}
+		p, err := newPrincipal(role(r), playerID)
+		if err != nil {
+			return nil, errors.WithMessage(err, "invalid API key")
+		}
+		a.apiKeys[sha256.Sum256([]byte(pair[:i]))] = p
+	}
+	return a, nil
+}
+
+// jwtLeeway is clock skew tolerated when checking validity period of JWTs.
+const jwtLeeway = 30 * time.Second
+
+// jwtClaims are JWT claims recognized by the authenticator. Subject is the
+// player ID of player tokens.
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	Role      role   `json:"role"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+}
+
+// parseJWT verifies HS256 signature and validity period of the token. Tokens
+// must expire, tokens without exp claim are rejected.
+func (a *authenticator) parseJWT(token string) (*principal, error) {
+	if len(a.jwtSecret) == 0 {
+		return nil, errors.New("JWT authentication is not configured")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.WithMessage(err, "decoding token header")
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, errors.WithMessage(err, "decoding token header")
+	}
+	// algorithm is fixed, so tokens signed otherwise or not at all are
+	// never accepted
+	if header.Alg != "HS256" {
+		return nil, errors.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.WithMessage(err, "decoding token signature")
+	}
+	mac := hmac.New(sha256.New, a.jwtSecret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	var claims jwtClaims
+	if data, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return nil, errors.WithMessage(err, "decoding token claims")
+	}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, errors.WithMessage(err, "decoding token claims")
+	}
+	if claims.ExpiresAt == 0 {
+		return nil, errors.New("token has no expiration")
+	}
+	now, leeway := a.now().Unix(), int64(jwtLeeway/time.Second)
+	if now >= claims.ExpiresAt+leeway {
+		return nil, errors.New("token expired")
+	}
+	if claims.NotBefore != 0 && now+leeway < claims.NotBefore {
+		return nil, errors.New("token not valid yet")
+	}
+	return newPrincipal(claims.Role, claims.Subject)
+}
+
+// authenticate returns client making the request.
+func (a *authenticator) authenticate(r *http.Request) (*principal, error) {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		p, ok := a.apiKeys[sha256.Sum256([]byte(key))]
+		if !ok {
+			return nil, errors.New("unknown API key")
+		}
+		return p, nil
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return a.parseJWT(auth[7:])
+	}
+	return nil, errors.New("missing credentials")
+}
+
+// playerParam extracts ID of the player a request acts for.
+type playerParam func(r *http.Request, params map[string]string) (string, error)
+
+// queryParam returns player ID given by query parameter.
+func queryParam(name string) playerParam {
+	return func(r *http.Request, _ map[string]string) (string, error) {
+		return r.URL.Query().Get(name), nil
+	}
+}
+
+// pathParam returns player ID given by URL path parameter.
+func pathParam(name string) playerParam {
+	return func(_ *http.Request, params map[string]string) (string, error) {
+		return params[name], nil
+	}
+}
+
+// bodyField returns player ID given by field of JSON request body. Field
+// names are matched case insensitively like JSON decoding of handlers does,
+// so the body must name the field only once.
+func bodyField(name string) playerParam {
+	return func(r *http.Request, _ map[string]string) (string, error) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
+			// malformed bodies are rejected by handlers
+			return "", nil
+		}
+		var value string
+		found := false
+		for k, v := range fields {
+			if !strings.EqualFold(k, name) {
+				continue
+			}
+			if found {
+				return "", errors.Errorf("duplicate %s field", name)
+			}
+			if err := json.Unmarshal(v, &value); err != nil {
+				return "", nil
+			}
+			found = true
+		}
+		return value, nil
+	}
+}
+
+// playerRoute is a route players may call for themselves.
+type playerRoute struct {
+	method  string
+	pattern string
+	// player gives ID of the player the request acts for, nil if the
+	// route is available to all players
+	player playerParam
+}
+
+// playerRoutes lists all routes callable by players. Players join
+// tournaments only as themselves, backers consent by responding to backing
+// requests with their own credentials.
+var playerRoutes = []playerRoute{
+	{"GET", "/balance", queryParam("playerId")},
+	{"GET", "/joinTournament", queryParam("playerId")},
+	{"GET", "/acceptBacking", queryParam("backerId")},
+	{"GET", "/declineBacking", queryParam("backerId")},
+	{"GET", "/players/:playerId/transactions", pathParam("playerId")},
+	{"GET", "/players/:playerId/backings", pathParam("playerId")},
+	{"GET", "/players/:playerId/backingRequests", pathParam("playerId")},
+	{"GET", "/v2/players/:playerId", pathParam("playerId")},
+	{"GET", "/v2/players/:playerId/transactions", pathParam("playerId")},
+	{"GET", "/v2/players/:playerId/backings", pathParam("playerId")},
+	{"GET", "/v2/players/:playerId/backing-requests", pathParam("playerId")},
+	{"GET", "/v2/tournaments/:tournamentId", nil},
+	{"POST", "/v2/tournaments/:tournamentId/entries", bodyField("playerId")},
+	{"POST", "/v2/backing-requests/:requestId/responses", bodyField("backerId")},
+}
+
+// matchRoute matches URL path with route pattern and returns values of its
+// parameters.
+func matchRoute(pattern, path string) (map[string]string, bool) {
+	ps := strings.Split(strings.Trim(pattern, "/"), "/")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(ps) != len(parts) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, p := range ps {
+		switch {
+		case strings.HasPrefix(p, ":"):
+			params[p[1:]] = parts[i]
+		case p != parts[i]:
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// authorize checks that the client may make the request.
+func authorize(p *principal, r *http.Request) error {
+	if p.role != rolePlayer {
+		return nil
+	}
+	for _, route := range playerRoutes {
+		if route.method != r.Method {
+			continue
+		}
+		params, ok := matchRoute(route.pattern, r.URL.Path)
+		if !ok {
+			continue
+		}
+		if route.player == nil {
+			return nil
+		}
+		playerID, err := route.player(r, params)
+		if err != nil || playerID != p.playerID {
+			return errForbidden
+		}
+		return nil
+	}
+	return errForbidden
+}
+
+// handler runs h for authenticated requests permitted to the client.
+func (a *authenticator) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := a.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			respondError(w, r, errUnauthenticated)
+			return
+		}
+		if err := authorize(p, r); err != nil {
+			respondError(w, r, err)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
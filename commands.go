@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Exit codes of administrative commands.
+const (
+	exitOK    = 0
+	exitDrift = 1
+	exitError = 2
+)
+
+// runCommand executes administrative command given as command line arguments
+// instead of starting the web server. It returns process exit code.
+func runCommand(ctx context.Context, app *application, args []string) int {
+	switch args[0] {
+	case "reconcile":
+		return reconcileCommand(ctx, app)
+	case "migrate":
+		return migrateCommand(ctx, app, args[1:])
+	default:
+		logrus.WithField("command", args[0]).Error("unknown command")
+		return exitError
+	}
+}
+
+// reconcileCommand prints JSON ledger reconciliation report to standard
+// output. Non zero exit code is returned if any drift is found.
+func reconcileCommand(ctx context.Context, app *application) int {
+	report, err := app.reconcile(ctx)
+	if err != nil {
+		logrus.WithError(err).Error("reconciling ledger")
+		return exitError
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		logrus.WithError(err).Error("writing reconciliation report")
+		return exitError
+	}
+	if !report.OK() {
+		return exitDrift
+	}
+	return exitOK
+}
+
+// migrateCommand applies pending schema migrations with up, reverts the
+// latest one with down or prints JSON migration status with status argument.
+func migrateCommand(ctx context.Context, app *application, args []string) int {
+	if len(args) != 1 {
+		logrus.Error("usage: sts migrate up|down|status")
+		return exitError
+	}
+
+	switch args[0] {
+	case "up":
+		versions, err := app.store.MigrateUp(ctx)
+		if err != nil {
+			logrus.WithError(err).Error("applying migrations")
+			return exitError
+		}
+		for _, v := range versions {
+			logrus.WithField("version", v).Info("applied migration")
+		}
+	case "down":
+		version, err := app.store.MigrateDown(ctx)
+		if err != nil {
+			logrus.WithError(err).Error("reverting migration")
+			return exitError
+		}
+		logrus.WithField("version", version).Info("reverted migration")
+	case "status":
+		status, err := app.store.MigrateStatus(ctx)
+		if err != nil {
+			logrus.WithError(err).Error("getting migration status")
+			return exitError
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(status); err != nil {
+			logrus.WithError(err).Error("writing migration status")
+			return exitError
+		}
+	default:
+		logrus.WithField("command", args[0]).Error("unknown migrate command")
+		return exitError
+	}
+	return exitOK
+}
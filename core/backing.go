@@ -0,0 +1,110 @@
+package core
+
+// BackingStatus is a state of a backing request.
+type BackingStatus string
+
+const (
+	// BackingPending requests wait for backers to accept them.
+	BackingPending BackingStatus = "pending"
+	// BackingAccepted requests were accepted by all backers and the player
+	// joined the tournament.
+	BackingAccepted BackingStatus = "accepted"
+	// BackingDeclined requests were declined by at least one backer.
+	BackingDeclined BackingStatus = "declined"
+	// BackingExpired requests were not completed before tournament
+	// registration closed.
+	BackingExpired BackingStatus = "expired"
+)
+
+// BackingStake is a stake of the player or one of its backers proposed in a
+// backing request.
+type BackingStake struct {
+	PlayerID string `json:"playerId"`
+	Points   int64  `json:"points"`
+	Accepted bool   `json:"accepted"`
+}
+
+// BackingRequest is a proposal of a player to join the tournament backed by
+// other players. Deposits are deducted only when all backers accept their
+// stakes. The first stake is always the player's own one.
+type BackingRequest struct {
+	ID           int64          `json:"id"`
+	TournamentID int            `json:"tournamentId"`
+	PlayerID     string         `json:"playerId"`
+	Status       BackingStatus  `json:"status"`
+	Stakes       []BackingStake `json:"stakes"`
+}
+
+// NewBackingRequest creates a pending backing request proposing stakes of the
+// tournament player. Player's own stake is accepted right away.
+func (tp *TournPlayer) NewBackingRequest() *BackingRequest {
+	stakes := make([]BackingStake, len(tp.Backers))
+	for i, b := range tp.Backers {
+		stakes[i] = BackingStake{
+			PlayerID: b.PlayerID,
+			Points:   b.Points,
+			Accepted: b.PlayerID == tp.PlayerID,
+		}
+	}
+	return &BackingRequest{
+		TournamentID: tp.TournamentID,
+		PlayerID:     tp.PlayerID,
+		Status:       BackingPending,
+		Stakes:       stakes,
+	}
+}
+
+// backerStake returns pending request stake of a given backer.
+func (r *BackingRequest) backerStake(backerID string, t *Tournament) (*BackingStake, error) {
+	if r.Status != BackingPending {
+		return nil, ErrBackingRequestClosed
+	}
+	if err := t.checkRegistrationOpen(); err != nil {
+		return nil, ErrBackingRequestExpired
+	}
+	for i := range r.Stakes {
+		if s := &r.Stakes[i]; s.PlayerID == backerID && backerID != r.PlayerID {
+			return s, nil
+		}
+	}
+	return nil, ErrBackerNotFound
+}
+
+// Accept records backer consent with its stake. Once all backers accept the
+// request, tournament player is returned and request is marked as accepted,
+// nil tournament player is returned while some backers did not respond yet.
+// This function will mutate the request.
+func (r *BackingRequest) Accept(backerID string, t *Tournament) (*TournPlayer, error) {
+	s, err := r.backerStake(backerID, t)
+	if err != nil {
+		return nil, err
+	}
+	if s.Accepted {
+		return nil, ErrBackingAlreadyAccepted
+	}
+	s.Accepted = true
+
+	stakes := make([]Backer, len(r.Stakes))
+	for i, s := range r.Stakes {
+		if !s.Accepted {
+			return nil, nil
+		}
+		stakes[i] = Backer{PlayerID: s.PlayerID, Points: s.Points}
+	}
+	tp, err := t.NewStakedTournPlayer(r.PlayerID, stakes)
+	if err != nil {
+		return nil, err
+	}
+	r.Status = BackingAccepted
+	return tp, nil
+}
+
+// Decline rejects the request by one of its backers. This function will
+// mutate the request.
+func (r *BackingRequest) Decline(backerID string, t *Tournament) error {
+	if _, err := r.backerStake(backerID, t); err != nil {
+		return err
+	}
+	r.Status = BackingDeclined
+	return nil
+}
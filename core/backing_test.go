@@ -0,0 +1,120 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBackingRequest(t *testing.T) {
+	tp := TournPlayer{
+		TournamentID: 1,
+		PlayerID:     "P1",
+		Fee:          100,
+		Backers: []Backer{
+			{PlayerID: "P1", Points: 20},
+			{PlayerID: "P2", Points: 80},
+		},
+	}
+	assert.Equal(t, &BackingRequest{
+		TournamentID: 1,
+		PlayerID:     "P1",
+		Status:       BackingPending,
+		Stakes: []BackingStake{
+			{PlayerID: "P1", Points: 20, Accepted: true},
+			{PlayerID: "P2", Points: 80},
+		},
+	}, tp.NewBackingRequest())
+}
+
+func newTestBackingRequest() *BackingRequest {
+	return &BackingRequest{
+		ID:           1,
+		TournamentID: 1,
+		PlayerID:     "P1",
+		Status:       BackingPending,
+		Stakes: []BackingStake{
+			{PlayerID: "P1", Points: 20, Accepted: true},
+			{PlayerID: "P2", Points: 40},
+			{PlayerID: "P3", Points: 40},
+		},
+	}
+}
+
+func TestBackingRequestAccept(t *testing.T) {
+	tournament := &Tournament{ID: 1, EntryDeposit: 100, State: TournamentRegistrationOpen}
+
+	r := newTestBackingRequest()
+	tp, err := r.Accept("P2", tournament)
+	assert.NoError(t, err)
+	assert.Nil(t, tp)
+	assert.Equal(t, BackingPending, r.Status)
+	assert.True(t, r.Stakes[1].Accepted)
+
+	_, err = r.Accept("P2", tournament)
+	assert.Equal(t, ErrBackingAlreadyAccepted, err)
+	_, err = r.Accept("P1", tournament)
+	assert.Equal(t, ErrBackerNotFound, err)
+	_, err = r.Accept("P4", tournament)
+	assert.Equal(t, ErrBackerNotFound, err)
+
+	tp, err = r.Accept("P3", tournament)
+	assert.NoError(t, err)
+	assert.Equal(t, BackingAccepted, r.Status)
+	assert.Equal(t, &TournPlayer{
+		TournamentID: 1,
+		PlayerID:     "P1",
+		Fee:          100,
+		Backers: []Backer{
+			{PlayerID: "P1", Points: 20},
+			{PlayerID: "P2", Points: 40},
+			{PlayerID: "P3", Points: 40},
+		},
+	}, tp)
+
+	_, err = r.Accept("P3", tournament)
+	assert.Equal(t, ErrBackingRequestClosed, err)
+}
+
+func TestBackingRequestAcceptClosedRegistration(t *testing.T) {
+	tests := []struct {
+		msg   string
+		state TournamentState
+	}{
+		{"announced", TournamentAnnounced},
+		{"registration closed", TournamentRegistrationClosed},
+		{"running", TournamentRunning},
+		{"cancelled", TournamentCancelled},
+	}
+
+	for _, test := range tests {
+		tournament := &Tournament{ID: 1, EntryDeposit: 100, State: test.state}
+		r := newTestBackingRequest()
+		_, err := r.Accept("P2", tournament)
+		assert.Equal(t, ErrBackingRequestExpired, err, test.msg)
+		assert.Equal(t, ErrBackingRequestExpired, r.Decline("P2", tournament), test.msg)
+	}
+}
+
+func TestBackingRequestAcceptChangedDeposit(t *testing.T) {
+	tournament := &Tournament{ID: 1, EntryDeposit: 90, State: TournamentRegistrationOpen}
+
+	r := newTestBackingRequest()
+	r.Stakes[1].Accepted = true
+	_, err := r.Accept("P3", tournament)
+	assert.Equal(t, ErrInvalidStakes, err)
+	assert.Equal(t, BackingPending, r.Status)
+}
+
+func TestBackingRequestDecline(t *testing.T) {
+	tournament := &Tournament{ID: 1, EntryDeposit: 100, State: TournamentRegistrationOpen}
+
+	r := newTestBackingRequest()
+	assert.Equal(t, ErrBackerNotFound, r.Decline("P1", tournament))
+	assert.Equal(t, ErrBackerNotFound, r.Decline("P4", tournament))
+	assert.NoError(t, r.Decline("P3", tournament))
+	assert.Equal(t, BackingDeclined, r.Status)
+	assert.Equal(t, ErrBackingRequestClosed, r.Decline("P2", tournament))
+	_, err := r.Accept("P2", tournament)
+	assert.Equal(t, ErrBackingRequestClosed, err)
+}
@@ -10,8 +10,36 @@ var (
 	ErrDuplicateTournament      = errors.New("duplicate tournament")
 	ErrDuplicateTournPlayer     = errors.New("duplicate tournament player")
 	ErrTournamentFinished       = errors.New("tournament is finished")
+	ErrTournamentCancelled      = errors.New("tournament is cancelled")
+	ErrRegistrationClosed       = errors.New("tournament registration is not open")
+	ErrInvalidTournamentState   = errors.New("invalid tournament state")
 	ErrInvalidTournamentDeposit = errors.New("invalid tournament deposit value, must greater than 0")
 	ErrInvalidTournamentPrize   = errors.New("invalid tournament prize value, must be greater than 0")
 	ErrTooManyBackers           = errors.New("too many player backers")
+	ErrInvalidRake              = errors.New("invalid tournament rake, percentage must be between 0 and 100 and fee must not be negative")
+	ErrPrizesExceedPool         = errors.New("tournament prizes exceed prize pool")
+	ErrInvalidPayoutStructure   = errors.New("invalid payout structure, place percentages must be positive and sum up to 100")
+	ErrUnknownPayoutTemplate    = errors.New("unknown payout template")
+	ErrNoPayoutStructure        = errors.New("tournament has no payout structure")
+	ErrMissingPlaces            = errors.New("finishing places do not cover all paid places")
+	ErrDuplicatePlaces          = errors.New("duplicate finishing places")
+	ErrEmptyPlace               = errors.New("finishing place without players")
 	ErrDuplicateBackers         = errors.New("duplicate backers")
+	ErrBackingRequestNotFound   = errors.New("backing request not found")
+	ErrBackingRequestClosed     = errors.New("backing request is not pending")
+	ErrBackingRequestExpired    = errors.New("backing request expired, tournament registration is not open")
+	ErrBackerNotFound           = errors.New("backer not found in backing request")
+	ErrBackingAlreadyAccepted   = errors.New("backing request already accepted by backer")
+	ErrStakingClosed            = errors.New("tournament stakes can not be traded after tournament start")
+	ErrInvalidStakeOffer        = errors.New("invalid stake offer, player must keep part of its own stake and markup must be at least 10000 basis points")
+	ErrInvalidStakePurchase     = errors.New("invalid stake purchase")
+	ErrStakeOfferExceeded       = errors.New("stake purchase exceeds available stake offer")
+	ErrStakeOfferNotFound       = errors.New("stake offer not found")
+	ErrDuplicateStakeOffer      = errors.New("duplicate stake offer")
+	ErrInvalidStakes            = errors.New("invalid stakes, every stake must be positive and stakes must sum up to tournament deposit")
+	ErrInvalidTransferOp        = errors.New("invalid transfer operation")
+	ErrInvalidAccountKind       = errors.New("invalid ledger account")
+	ErrUnbalancedLedgerEntry    = errors.New("ledger entry transfers do not sum up to zero")
+	ErrNegativePotBalance       = errors.New("operation would result in negative tournament pot balance")
+	ErrIdempotencyKeyReused     = errors.New("idempotency key was already used for a different request")
 )
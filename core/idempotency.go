@@ -0,0 +1,22 @@
+package core
+
+// IdempotencyKey holds client supplied key of a mutating request together with
+// request fingerprint and the response which was sent for it. Repeated
+// requests with the same key are answered with the stored response. Keys are
+// scoped by Client, so keys chosen by different clients are independent.
+type IdempotencyKey struct {
+	Client      string
+	Key         string
+	Fingerprint string
+	Status      int
+	Body        string
+}
+
+// Check verifies that a repeated request with the same key is identical to the
+// original one.
+func (k *IdempotencyKey) Check(fingerprint string) error {
+	if k.Fingerprint != fingerprint {
+		return ErrIdempotencyKeyReused
+	}
+	return nil
+}
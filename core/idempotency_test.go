@@ -0,0 +1,14 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKeyCheck(t *testing.T) {
+	k := IdempotencyKey{Key: "k1", Fingerprint: "abc"}
+	assert.NoError(t, k.Check("abc"))
+	assert.Equal(t, ErrIdempotencyKeyReused, k.Check("abd"))
+	assert.Equal(t, ErrIdempotencyKeyReused, k.Check(""))
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// AccountKind identifies the kind of ledger account a transfer is posted to.
+type AccountKind byte
+
+const (
+	// AccountPlayer is a player balance account.
+	AccountPlayer AccountKind = 'P'
+	// AccountPot is a per tournament account collecting entry deposits and
+	// paying out prizes.
+	AccountPot AccountKind = 'T'
+	// AccountHouse is the service operator account. It is the counterparty
+	// of player fund and take operations and covers prize overlays, so its
+	// balance is the negated sum of all other account balances.
+	AccountHouse AccountKind = 'H'
+)
+
+var accountKindNames = map[AccountKind]string{
+	AccountPlayer: "player",
+	AccountPot:    "pot",
+	AccountHouse:  "house",
+}
+
+func (k AccountKind) String() string {
+	if name, ok := accountKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("AccountKind(%q)", byte(k))
+}
+
+func (k AccountKind) MarshalText() ([]byte, error) {
+	if _, ok := accountKindNames[k]; !ok {
+		return nil, ErrInvalidAccountKind
+	}
+	return []byte(k.String()), nil
+}
+
+func (k *AccountKind) UnmarshalText(text []byte) error {
+	for v, name := range accountKindNames {
+		if name == string(text) {
+			*k = v
+			return nil
+		}
+	}
+	return ErrInvalidAccountKind
+}
+
+// LedgerEntry is a set of transfers forming a single balanced accounting
+// operation. Points are never created or destroyed by a ledger entry, they
+// are only moved between accounts, so sum of all entry transfers is zero.
+type LedgerEntry struct {
+	ID        int64
+	Time      time.Time
+	Op        TransferOp
+	Transfers []Transfer
+}
+
+// newLedgerEntry creates a ledger entry of given transfers. All transfers are
+// marked with entry operation.
+func newLedgerEntry(op TransferOp, transfers ...Transfer) (*LedgerEntry, error) {
+	for i := range transfers {
+		transfers[i].Op = op
+	}
+	e := &LedgerEntry{
+		Op:        op,
+		Transfers: transfers,
+	}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Validate checks ledger entry invariants: transfers must be of the same
+// operation as the entry, must be posted to a valid account and must sum up to
+// zero.
+func (e *LedgerEntry) Validate() error {
+	if _, ok := transferOpNames[e.Op]; !ok {
+		return ErrInvalidTransferOp
+	}
+	sum := int64(0)
+	for _, t := range e.Transfers {
+		if t.Op != e.Op {
+			return ErrInvalidTransferOp
+		}
+		switch t.Account {
+		case AccountPlayer:
+			if t.PlayerID == "" {
+				return ErrInvalidAccountKind
+			}
+		case AccountPot, AccountHouse:
+			if t.PlayerID != "" {
+				return ErrInvalidAccountKind
+			}
+		default:
+			return ErrInvalidAccountKind
+		}
+		sum += t.Points
+	}
+	if sum != 0 {
+		return ErrUnbalancedLedgerEntry
+	}
+	return nil
+}
+
+// NewBalanceEntry creates a ledger entry for direct player balance change.
+// Positive delta is recorded as fund operation, negative one as take, the
+// house account is the counterparty in both cases.
+func NewBalanceEntry(playerID string, delta int64) (*LedgerEntry, error) {
+	op := TransferFund
+	if delta < 0 {
+		op = TransferTake
+	}
+	return newLedgerEntry(op,
+		Transfer{
+			Account:  AccountPlayer,
+			PlayerID: playerID,
+			Points:   delta,
+		},
+		Transfer{
+			Account: AccountHouse,
+			Points:  -delta,
+		},
+	)
+}
+
+// NewOpeningBalanceEntry creates a ledger entry opening player balance which
+// existed before the ledger, the house account is the counterparty.
+func NewOpeningBalanceEntry(playerID string, balance int64) (*LedgerEntry, error) {
+	return newLedgerEntry(TransferOpening,
+		Transfer{
+			Account:  AccountPlayer,
+			PlayerID: playerID,
+			Points:   balance,
+		},
+		Transfer{
+			Account: AccountHouse,
+			Points:  -balance,
+		},
+	)
+}
+
+// NewOpeningPotEntry creates a ledger entry opening tournament pot which
+// existed before the ledger, the house account is the counterparty.
+func NewOpeningPotEntry(tournamentID int, pot int64) (*LedgerEntry, error) {
+	return newLedgerEntry(TransferOpening,
+		Transfer{
+			Account:      AccountPot,
+			Points:       pot,
+			TournamentID: tournamentID,
+		},
+		Transfer{
+			Account:      AccountHouse,
+			Points:       -pot,
+			TournamentID: tournamentID,
+		},
+	)
+}
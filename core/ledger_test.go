@@ -0,0 +1,129 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedgerEntryValidate(t *testing.T) {
+	tests := []struct {
+		msg   string
+		entry LedgerEntry
+		err   error
+	}{
+		{
+			msg:   "empty entry",
+			entry: LedgerEntry{Op: TransferFund},
+		},
+		{
+			msg:   "invalid op",
+			entry: LedgerEntry{},
+			err:   ErrInvalidTransferOp,
+		},
+		{
+			msg: "mixed ops",
+			entry: LedgerEntry{
+				Op: TransferFund,
+				Transfers: []Transfer{
+					{Op: TransferFund, Account: AccountPlayer, PlayerID: "P1", Points: 10},
+					{Op: TransferTake, Account: AccountHouse, Points: -10},
+				},
+			},
+			err: ErrInvalidTransferOp,
+		},
+		{
+			msg: "invalid account",
+			entry: LedgerEntry{
+				Op: TransferFund,
+				Transfers: []Transfer{
+					{Op: TransferFund, Account: AccountPlayer, Points: 10},
+					{Op: TransferFund, Account: AccountHouse, Points: -10},
+				},
+			},
+			err: ErrInvalidAccountKind,
+		},
+		{
+			msg: "unbalanced",
+			entry: LedgerEntry{
+				Op: TransferPrize,
+				Transfers: []Transfer{
+					{Op: TransferPrize, Account: AccountPlayer, PlayerID: "P1", Points: 10},
+					{Op: TransferPrize, Account: AccountPot, TournamentID: 1, Points: -9},
+				},
+			},
+			err: ErrUnbalancedLedgerEntry,
+		},
+		{
+			msg: "balanced",
+			entry: LedgerEntry{
+				Op: TransferPrize,
+				Transfers: []Transfer{
+					{Op: TransferPrize, Account: AccountPlayer, PlayerID: "P1", Points: 10},
+					{Op: TransferPrize, Account: AccountPot, TournamentID: 1, Points: -9},
+					{Op: TransferPrize, Account: AccountHouse, TournamentID: 1, Points: -1},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.err, test.entry.Validate(), test.msg)
+	}
+}
+
+func TestNewBalanceEntry(t *testing.T) {
+	tests := []struct {
+		delta int64
+		entry *LedgerEntry
+	}{
+		{
+			delta: 10,
+			entry: &LedgerEntry{
+				Op: TransferFund,
+				Transfers: []Transfer{
+					{Op: TransferFund, Account: AccountPlayer, PlayerID: "P1", Points: 10},
+					{Op: TransferFund, Account: AccountHouse, Points: -10},
+				},
+			},
+		},
+		{
+			delta: -10,
+			entry: &LedgerEntry{
+				Op: TransferTake,
+				Transfers: []Transfer{
+					{Op: TransferTake, Account: AccountPlayer, PlayerID: "P1", Points: -10},
+					{Op: TransferTake, Account: AccountHouse, Points: 10},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		entry, err := NewBalanceEntry("P1", test.delta)
+		assert.NoError(t, err)
+		assert.Equal(t, test.entry, entry)
+	}
+}
+
+func TestNewOpeningEntries(t *testing.T) {
+	entry, err := NewOpeningBalanceEntry("P1", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, &LedgerEntry{
+		Op: TransferOpening,
+		Transfers: []Transfer{
+			{Op: TransferOpening, Account: AccountPlayer, PlayerID: "P1", Points: 10},
+			{Op: TransferOpening, Account: AccountHouse, Points: -10},
+		},
+	}, entry)
+
+	entry, err = NewOpeningPotEntry(1, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, &LedgerEntry{
+		Op: TransferOpening,
+		Transfers: []Transfer{
+			{Op: TransferOpening, Account: AccountPot, TournamentID: 1, Points: 20},
+			{Op: TransferOpening, Account: AccountHouse, TournamentID: 1, Points: -20},
+		},
+	}, entry)
+}
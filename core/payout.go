@@ -0,0 +1,183 @@
+package core
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// PayoutStructure describes how the tournament prize pool is distributed
+// among finishing places. Either explicit Percentages per place or a named
+// Template choosing percentages by the number of tournament players is set.
+type PayoutStructure struct {
+	Template    string  `json:"template,omitempty"`
+	Percentages []int64 `json:"percentages,omitempty"`
+}
+
+// payoutTier holds place percentages used for tournaments with up to
+// MaxPlayers players, zero MaxPlayers matches any field size.
+type payoutTier struct {
+	MaxPlayers  int
+	Percentages []int64
+}
+
+// payoutTemplates holds named payout structures, tiers are ordered by field
+// size.
+var payoutTemplates = map[string][]payoutTier{
+	"winner-takes-all": {
+		{Percentages: []int64{100}},
+	},
+	"standard": {
+		{MaxPlayers: 5, Percentages: []int64{100}},
+		{MaxPlayers: 10, Percentages: []int64{65, 35}},
+		{MaxPlayers: 20, Percentages: []int64{50, 30, 20}},
+		{MaxPlayers: 50, Percentages: []int64{40, 25, 15, 12, 8}},
+		{Percentages: []int64{30, 20, 14, 10, 8, 6, 5, 4, 3}},
+	},
+}
+
+// Validate checks that exactly one of template and percentages is set, the
+// template is known and percentages are positive and sum up to 100.
+func (p *PayoutStructure) Validate() error {
+	switch {
+	case p.Template != "" && len(p.Percentages) > 0:
+		return ErrInvalidPayoutStructure
+	case p.Template != "":
+		if _, ok := payoutTemplates[p.Template]; !ok {
+			return ErrUnknownPayoutTemplate
+		}
+		return nil
+	default:
+		return validatePercentages(p.Percentages)
+	}
+}
+
+func validatePercentages(percentages []int64) error {
+	var sum int64
+	for _, pct := range percentages {
+		if pct <= 0 {
+			return ErrInvalidPayoutStructure
+		}
+		sum += pct
+	}
+	if sum != 100 {
+		return ErrInvalidPayoutStructure
+	}
+	return nil
+}
+
+// PlacePercentages returns prize pool percentages of paid places for a
+// tournament with given number of players.
+func (p *PayoutStructure) PlacePercentages(fieldSize int) ([]int64, error) {
+	if p.Template == "" {
+		return p.Percentages, validatePercentages(p.Percentages)
+	}
+	tiers, ok := payoutTemplates[p.Template]
+	if !ok {
+		return nil, ErrUnknownPayoutTemplate
+	}
+	for _, tier := range tiers {
+		if tier.MaxPlayers == 0 || fieldSize <= tier.MaxPlayers {
+			return tier.Percentages, nil
+		}
+	}
+	return nil, ErrUnknownPayoutTemplate
+}
+
+// splitWeighted splits points proportionally to weights rounding every part
+// down. Remaining points are distributed one by one starting with the first
+// part, so that higher places and the player's own stake never lose on
+// rounding.
+func splitWeighted(points int64, weights []int64) []int64 {
+	var total int64
+	for _, w := range weights {
+		total += w
+	}
+	parts := make([]int64, len(weights))
+	if total <= 0 {
+		return parts
+	}
+	r := points
+	for i, w := range weights {
+		parts[i] = mulDiv(points, w, total)
+		r -= parts[i]
+	}
+	for i := 0; r > 0; i = (i + 1) % len(parts) {
+		parts[i]++
+		r--
+	}
+	return parts
+}
+
+// Place holds players sharing a finishing place. In JSON a place is either a
+// single player ID or a list of tied player IDs.
+type Place []string
+
+func (p *Place) UnmarshalJSON(data []byte) error {
+	var playerID string
+	if err := json.Unmarshal(data, &playerID); err == nil {
+		*p = Place{playerID}
+		return nil
+	}
+	var playerIDs []string
+	if err := json.Unmarshal(data, &playerIDs); err != nil {
+		return err
+	}
+	*p = Place(playerIDs)
+	return nil
+}
+
+// PlacePrizes computes prizes of finishing players from the tournament prize
+// pool according to the tournament payout structure. Places hold player IDs
+// in finishing order, at least all paid places must be given unless fieldSize,
+// the number of tournament players, is smaller. Players tied at a shared
+// place occupy as many places as there are tied players, prizes of those
+// places are combined and split evenly between them, remainder goes to the
+// players in player ID order. Players without a prize are omitted from the
+// result.
+func (t *Tournament) PlacePrizes(places []Place, fieldSize int) (map[string]int64, error) {
+	if t.Payout == nil {
+		return nil, ErrNoPayoutStructure
+	}
+	var playerIDs []string
+	for _, place := range places {
+		if len(place) == 0 {
+			return nil, ErrEmptyPlace
+		}
+		playerIDs = append(playerIDs, place...)
+	}
+	if hasDuplicates(playerIDs) {
+		return nil, ErrDuplicatePlaces
+	}
+	percentages, err := t.Payout.PlacePercentages(fieldSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(playerIDs) < len(percentages) && len(playerIDs) < fieldSize {
+		return nil, ErrMissingPlaces
+	}
+	if len(percentages) > len(playerIDs) {
+		// field is smaller than the number of paid places, the whole pool is
+		// split among finishers keeping ratios between their percentages
+		percentages = percentages[:len(playerIDs)]
+	}
+	placePrizes := splitWeighted(t.PrizePool(), percentages)
+
+	prizes := make(map[string]int64)
+	pos := 0
+	for _, place := range places {
+		var combined int64
+		for i := pos; i < pos+len(place) && i < len(placePrizes); i++ {
+			combined += placePrizes[i]
+		}
+		pos += len(place)
+
+		tied := append([]string(nil), place...)
+		sort.Strings(tied)
+		for i, prize := range splitPoints(combined, len(tied)) {
+			if prize > 0 {
+				prizes[tied[i]] = prize
+			}
+		}
+	}
+	return prizes, nil
+}
@@ -0,0 +1,197 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayoutStructureValidate(t *testing.T) {
+	tests := []struct {
+		msg    string
+		payout PayoutStructure
+		err    error
+	}{
+		{"percentages", PayoutStructure{Percentages: []int64{50, 30, 20}}, nil},
+		{"single place", PayoutStructure{Percentages: []int64{100}}, nil},
+		{"template", PayoutStructure{Template: "standard"}, nil},
+		{"empty", PayoutStructure{}, ErrInvalidPayoutStructure},
+		{"sum below 100", PayoutStructure{Percentages: []int64{50, 30}}, ErrInvalidPayoutStructure},
+		{"sum above 100", PayoutStructure{Percentages: []int64{60, 50}}, ErrInvalidPayoutStructure},
+		{"zero place", PayoutStructure{Percentages: []int64{100, 0}}, ErrInvalidPayoutStructure},
+		{"negative place", PayoutStructure{Percentages: []int64{110, -10}}, ErrInvalidPayoutStructure},
+		{"unknown template", PayoutStructure{Template: "unknown"}, ErrUnknownPayoutTemplate},
+		{"template and percentages", PayoutStructure{Template: "standard", Percentages: []int64{100}}, ErrInvalidPayoutStructure},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.err, test.payout.Validate(), test.msg)
+	}
+}
+
+func TestPlacePercentages(t *testing.T) {
+	tests := []struct {
+		payout      PayoutStructure
+		fieldSize   int
+		percentages []int64
+	}{
+		{PayoutStructure{Percentages: []int64{50, 30, 20}}, 2, []int64{50, 30, 20}},
+		{PayoutStructure{Template: "winner-takes-all"}, 100, []int64{100}},
+		{PayoutStructure{Template: "standard"}, 5, []int64{100}},
+		{PayoutStructure{Template: "standard"}, 6, []int64{65, 35}},
+		{PayoutStructure{Template: "standard"}, 20, []int64{50, 30, 20}},
+		{PayoutStructure{Template: "standard"}, 50, []int64{40, 25, 15, 12, 8}},
+		{PayoutStructure{Template: "standard"}, 51, []int64{30, 20, 14, 10, 8, 6, 5, 4, 3}},
+	}
+
+	for _, test := range tests {
+		msg := fmt.Sprintf("%+v field %d", test.payout, test.fieldSize)
+		percentages, err := test.payout.PlacePercentages(test.fieldSize)
+		assert.NoError(t, err, msg)
+		assert.Equal(t, test.percentages, percentages, msg)
+	}
+}
+
+func TestSplitWeighted(t *testing.T) {
+	tests := []struct {
+		points  int64
+		weights []int64
+		parts   []int64
+	}{
+		{points: 100, weights: []int64{50, 30, 20}, parts: []int64{50, 30, 20}},
+		{points: 1000, weights: []int64{50, 30, 20}, parts: []int64{500, 300, 200}},
+		{points: 99, weights: []int64{50, 30, 20}, parts: []int64{50, 30, 19}},
+		{points: 11, weights: []int64{50, 30, 20}, parts: []int64{6, 3, 2}},
+		{points: 2, weights: []int64{40, 30, 30}, parts: []int64{1, 1, 0}},
+		{points: 10, weights: []int64{65}, parts: []int64{10}},
+		{points: 0, weights: []int64{50, 50}, parts: []int64{0, 0}},
+		{points: 10, weights: []int64{}, parts: []int64{}},
+	}
+
+	for _, test := range tests {
+		msg := fmt.Sprintf("%d by %v", test.points, test.weights)
+		assert.Equal(t, test.parts, splitWeighted(test.points, test.weights), msg)
+	}
+}
+
+func TestPlacePrizes(t *testing.T) {
+	top3 := &PayoutStructure{Percentages: []int64{50, 30, 20}}
+	tests := []struct {
+		msg        string
+		tournament Tournament
+		places     []Place
+		fieldSize  int
+		prizes     map[string]int64
+		err        error
+	}{
+		{
+			msg:        "no payout structure",
+			tournament: Tournament{Pot: 1000},
+			places:     []Place{{"P1"}},
+			fieldSize:  1,
+			err:        ErrNoPayoutStructure,
+		},
+		{
+			msg:        "duplicate places",
+			tournament: Tournament{Pot: 1000, Payout: top3},
+			places:     []Place{{"P1"}, {"P2"}, {"P1"}},
+			fieldSize:  5,
+			err:        ErrDuplicatePlaces,
+		},
+		{
+			msg:        "missing places",
+			tournament: Tournament{Pot: 1000, Payout: top3},
+			places:     []Place{{"P1"}, {"P2"}},
+			fieldSize:  5,
+			err:        ErrMissingPlaces,
+		},
+		{
+			msg:        "paid places",
+			tournament: Tournament{Pot: 1000, Payout: top3},
+			places:     []Place{{"P1"}, {"P2"}, {"P3"}, {"P4"}, {"P5"}},
+			fieldSize:  5,
+			prizes:     map[string]int64{"P1": 500, "P2": 300, "P3": 200},
+		},
+		{
+			msg:        "rounding remainder to top places",
+			tournament: Tournament{Pot: 1001, Payout: top3, RakePercent: 10},
+			places:     []Place{{"P3"}, {"P2"}, {"P1"}},
+			fieldSize:  5,
+			prizes:     map[string]int64{"P3": 451, "P2": 270, "P1": 180},
+		},
+		{
+			msg:        "field smaller than paid places",
+			tournament: Tournament{Pot: 200, Payout: top3},
+			places:     []Place{{"P1"}, {"P2"}},
+			fieldSize:  2,
+			prizes:     map[string]int64{"P1": 125, "P2": 75},
+		},
+		{
+			msg:        "template by field size",
+			tournament: Tournament{Pot: 700, Payout: &PayoutStructure{Template: "standard"}},
+			places:     []Place{{"P1"}, {"P2"}, {"P3"}},
+			fieldSize:  7,
+			prizes:     map[string]int64{"P1": 455, "P2": 245},
+		},
+		{
+			msg:        "empty place",
+			tournament: Tournament{Pot: 1000, Payout: top3},
+			places:     []Place{{"P1"}, {}, {"P2"}},
+			fieldSize:  3,
+			err:        ErrEmptyPlace,
+		},
+		{
+			msg:        "duplicate tied places",
+			tournament: Tournament{Pot: 1000, Payout: top3},
+			places:     []Place{{"P1", "P2"}, {"P2"}},
+			fieldSize:  3,
+			err:        ErrDuplicatePlaces,
+		},
+		{
+			msg:        "tie for second place",
+			tournament: Tournament{Pot: 1000, Payout: top3},
+			places:     []Place{{"P1"}, {"P3", "P2"}, {"P4"}},
+			fieldSize:  5,
+			prizes:     map[string]int64{"P1": 500, "P2": 250, "P3": 250},
+		},
+		{
+			msg:        "tie remainder in player ID order",
+			tournament: Tournament{Pot: 1001, Payout: top3},
+			places:     []Place{{"P4", "P2", "P3"}, {"P1"}},
+			fieldSize:  5,
+			prizes:     map[string]int64{"P2": 334, "P3": 334, "P4": 333},
+		},
+		{
+			msg:        "tie crossing last paid place",
+			tournament: Tournament{Pot: 1000, Payout: top3},
+			places:     []Place{{"P1"}, {"P2"}, {"P3", "P4", "P5"}},
+			fieldSize:  5,
+			prizes:     map[string]int64{"P1": 500, "P2": 300, "P3": 67, "P4": 67, "P5": 66},
+		},
+		{
+			msg:        "zero prizes omitted",
+			tournament: Tournament{Pot: 1, Payout: top3},
+			places:     []Place{{"P1"}, {"P2"}, {"P3"}},
+			fieldSize:  3,
+			prizes:     map[string]int64{"P1": 1},
+		},
+	}
+
+	for _, test := range tests {
+		prizes, err := test.tournament.PlacePrizes(test.places, test.fieldSize)
+		assert.Equal(t, test.err, err, test.msg)
+		assert.Equal(t, test.prizes, prizes, test.msg)
+	}
+}
+
+func TestPlaceJSON(t *testing.T) {
+	var places []Place
+	err := json.Unmarshal([]byte(`["P1", ["P2", "P3"], "P4"]`), &places)
+	assert.NoError(t, err)
+	assert.Equal(t, []Place{{"P1"}, {"P2", "P3"}, {"P4"}}, places)
+
+	err = json.Unmarshal([]byte(`[1]`), &places)
+	assert.Error(t, err)
+}
@@ -0,0 +1,49 @@
+package core
+
+// Backing summarizes player's investment into a single tournament player,
+// including the player's own stake. Stake is the net amount paid for the
+// stake including refunds and stake purchase markups, Payout is the sum of
+// prizes received and Net is the resulting gain or loss.
+type Backing struct {
+	TournamentID int             `json:"tournamentId"`
+	PlayerID     string          `json:"playerId"`
+	State        TournamentState `json:"state"`
+	Stake        int64           `json:"stake"`
+	Payout       int64           `json:"payout"`
+	Net          int64           `json:"net"`
+}
+
+// BackingPortfolio lists all backings of a player. Aggregated stake, payout,
+// net result and ROI include only backings of finished tournaments, ROI is
+// zero if no stake was settled.
+type BackingPortfolio struct {
+	PlayerID string    `json:"playerId"`
+	Backings []Backing `json:"backings"`
+	Stake    int64     `json:"stake"`
+	Payout   int64     `json:"payout"`
+	Net      int64     `json:"net"`
+	ROI      float64   `json:"roi"`
+}
+
+// NewBackingPortfolio aggregates player backings.
+func NewBackingPortfolio(playerID string, backings []Backing) *BackingPortfolio {
+	p := &BackingPortfolio{
+		PlayerID: playerID,
+		Backings: backings,
+	}
+	if p.Backings == nil {
+		p.Backings = []Backing{}
+	}
+	for _, b := range backings {
+		if b.State != TournamentFinished {
+			continue
+		}
+		p.Stake += b.Stake
+		p.Payout += b.Payout
+		p.Net += b.Net
+	}
+	if p.Stake > 0 {
+		p.ROI = float64(p.Net) / float64(p.Stake)
+	}
+	return p
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBackingPortfolio(t *testing.T) {
+	tests := []struct {
+		msg      string
+		backings []Backing
+		result   BackingPortfolio
+	}{
+		{
+			msg:    "no backings",
+			result: BackingPortfolio{PlayerID: "P1", Backings: []Backing{}},
+		},
+		{
+			msg: "finished and running tournaments",
+			backings: []Backing{
+				{TournamentID: 1, PlayerID: "P1", State: TournamentFinished, Stake: 50, Payout: 100, Net: 50},
+				{TournamentID: 2, PlayerID: "P2", State: TournamentFinished, Stake: 30, Payout: 0, Net: -30},
+				{TournamentID: 3, PlayerID: "P2", State: TournamentRunning, Stake: 20, Payout: 0, Net: -20},
+			},
+			result: BackingPortfolio{
+				PlayerID: "P1",
+				Backings: []Backing{
+					{TournamentID: 1, PlayerID: "P1", State: TournamentFinished, Stake: 50, Payout: 100, Net: 50},
+					{TournamentID: 2, PlayerID: "P2", State: TournamentFinished, Stake: 30, Payout: 0, Net: -30},
+					{TournamentID: 3, PlayerID: "P2", State: TournamentRunning, Stake: 20, Payout: 0, Net: -20},
+				},
+				Stake:  80,
+				Payout: 100,
+				Net:    20,
+				ROI:    0.25,
+			},
+		},
+		{
+			msg: "refunded stake",
+			backings: []Backing{
+				{TournamentID: 1, PlayerID: "P2", State: TournamentCancelled},
+			},
+			result: BackingPortfolio{
+				PlayerID: "P1",
+				Backings: []Backing{
+					{TournamentID: 1, PlayerID: "P2", State: TournamentCancelled},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, &test.result, NewBackingPortfolio("P1", test.backings), test.msg)
+	}
+}
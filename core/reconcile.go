@@ -0,0 +1,159 @@
+package core
+
+import "sort"
+
+// DriftKind identifies which consistency check found a drift.
+type DriftKind string
+
+const (
+	// DriftPlayerBalance is reported when stored player balance differs from
+	// the sum of player account transfers.
+	DriftPlayerBalance DriftKind = "player_balance"
+	// DriftPotBalance is reported when stored tournament pot differs from the
+	// sum of tournament pot account transfers.
+	DriftPotBalance DriftKind = "pot_balance"
+	// DriftUnbalancedEntry is reported for ledger entries whose transfers do
+	// not sum up to zero.
+	DriftUnbalancedEntry DriftKind = "unbalanced_entry"
+	// DriftWinnerPayout is reported when prize transfers of a finished
+	// tournament do not match stored tournament winner backer shares.
+	DriftWinnerPayout DriftKind = "winner_payout"
+)
+
+// Drift describes a single mismatch between stored state and the ledger.
+// Expected value is derived from the ledger, Actual one is stored.
+type Drift struct {
+	Kind         DriftKind `json:"kind"`
+	PlayerID     string    `json:"playerId,omitempty"`
+	TournamentID int       `json:"tournamentId,omitempty"`
+	BackerID     string    `json:"backerId,omitempty"`
+	EntryID      int64     `json:"entryId,omitempty"`
+	Expected     int64     `json:"expected"`
+	Actual       int64     `json:"actual"`
+}
+
+// PrizeKey identifies points won by a backer (or the player itself) of a
+// tournament player.
+type PrizeKey struct {
+	TournamentID int
+	PlayerID     string
+	BackerID     string
+}
+
+// LedgerSums holds ledger transfers aggregated per account.
+type LedgerSums struct {
+	Players map[string]int64
+	Pots    map[int]int64
+	House   int64
+	// Unbalanced holds transfer sums of ledger entries not summing up to zero.
+	Unbalanced map[int64]int64
+	// Prizes holds prize transfers to player accounts.
+	Prizes map[PrizeKey]int64
+}
+
+// ReconcileReport is a result of ledger reconciliation.
+type ReconcileReport struct {
+	Players      int     `json:"players"`
+	Tournaments  int     `json:"tournaments"`
+	HouseBalance int64   `json:"houseBalance"`
+	Drifts       []Drift `json:"drifts"`
+}
+
+// OK reports whether reconciliation found no drifts.
+func (r *ReconcileReport) OK() bool {
+	return len(r.Drifts) == 0
+}
+
+// Reconcile replays aggregated ledger against stored players, tournaments and
+// tournament winners and reports every mismatch found.
+func Reconcile(players []Player, tournaments []Tournament, winners []TournWinner, ledger *LedgerSums) *ReconcileReport {
+	r := &ReconcileReport{
+		Players:      len(players),
+		Tournaments:  len(tournaments),
+		HouseBalance: ledger.House,
+		Drifts:       []Drift{},
+	}
+
+	for _, p := range players {
+		if expected := ledger.Players[p.PlayerID]; expected != p.Balance {
+			r.Drifts = append(r.Drifts, Drift{
+				Kind:     DriftPlayerBalance,
+				PlayerID: p.PlayerID,
+				Expected: expected,
+				Actual:   p.Balance,
+			})
+		}
+	}
+
+	finished := make(map[int]bool)
+	for _, t := range tournaments {
+		if expected := ledger.Pots[t.ID]; expected != t.Pot {
+			r.Drifts = append(r.Drifts, Drift{
+				Kind:         DriftPotBalance,
+				TournamentID: t.ID,
+				Expected:     expected,
+				Actual:       t.Pot,
+			})
+		}
+		if t.State == TournamentFinished {
+			finished[t.ID] = true
+		}
+	}
+
+	for id, sum := range ledger.Unbalanced {
+		r.Drifts = append(r.Drifts, Drift{
+			Kind:     DriftUnbalancedEntry,
+			EntryID:  id,
+			Expected: 0,
+			Actual:   sum,
+		})
+	}
+
+	stored := make(map[PrizeKey]int64)
+	for _, tw := range winners {
+		if !finished[tw.TournamentID] {
+			continue
+		}
+		for _, b := range tw.Backers {
+			stored[PrizeKey{TournamentID: tw.TournamentID, PlayerID: tw.PlayerID, BackerID: b.PlayerID}] += b.Points
+		}
+	}
+	keys := make(map[PrizeKey]struct{})
+	for k := range stored {
+		keys[k] = struct{}{}
+	}
+	for k := range ledger.Prizes {
+		if finished[k.TournamentID] {
+			keys[k] = struct{}{}
+		}
+	}
+	for k := range keys {
+		if expected, actual := ledger.Prizes[k], stored[k]; expected != actual {
+			r.Drifts = append(r.Drifts, Drift{
+				Kind:         DriftWinnerPayout,
+				TournamentID: k.TournamentID,
+				PlayerID:     k.PlayerID,
+				BackerID:     k.BackerID,
+				Expected:     expected,
+				Actual:       actual,
+			})
+		}
+	}
+
+	sort.Slice(r.Drifts, func(i, j int) bool {
+		a, b := r.Drifts[i], r.Drifts[j]
+		switch {
+		case a.Kind != b.Kind:
+			return a.Kind < b.Kind
+		case a.TournamentID != b.TournamentID:
+			return a.TournamentID < b.TournamentID
+		case a.PlayerID != b.PlayerID:
+			return a.PlayerID < b.PlayerID
+		case a.BackerID != b.BackerID:
+			return a.BackerID < b.BackerID
+		default:
+			return a.EntryID < b.EntryID
+		}
+	})
+	return r
+}
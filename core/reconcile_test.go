@@ -0,0 +1,73 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcile(t *testing.T) {
+	players := []Player{
+		{PlayerID: "P1", Balance: 550},
+		{PlayerID: "P2", Balance: 40},
+		{PlayerID: "P3", Balance: 0},
+	}
+	tournaments := []Tournament{
+		{ID: 1, State: TournamentFinished, Pot: 0},
+		{ID: 2, State: TournamentRunning, Pot: 100},
+	}
+	winners := []TournWinner{
+		{
+			TournamentID: 1,
+			PlayerID:     "P1",
+			Prize:        1000,
+			Backers: []Backer{
+				{PlayerID: "P1", Points: 500},
+				{PlayerID: "P2", Points: 500},
+			},
+		},
+	}
+
+	t.Run("consistent", func(t *testing.T) {
+		sums := &LedgerSums{
+			Players: map[string]int64{"P1": 550, "P2": 40},
+			Pots:    map[int]int64{1: 0, 2: 100},
+			House:   -690,
+			Prizes: map[PrizeKey]int64{
+				{TournamentID: 1, PlayerID: "P1", BackerID: "P1"}: 500,
+				{TournamentID: 1, PlayerID: "P1", BackerID: "P2"}: 500,
+			},
+		}
+		r := Reconcile(players, tournaments, winners, sums)
+		assert.True(t, r.OK())
+		assert.Equal(t, &ReconcileReport{
+			Players:      3,
+			Tournaments:  2,
+			HouseBalance: -690,
+			Drifts:       []Drift{},
+		}, r)
+	})
+
+	t.Run("drifted", func(t *testing.T) {
+		sums := &LedgerSums{
+			Players:    map[string]int64{"P1": 550, "P2": 50, "P3": 0},
+			Pots:       map[int]int64{2: 90},
+			House:      -690,
+			Unbalanced: map[int64]int64{7: 10},
+			Prizes: map[PrizeKey]int64{
+				{TournamentID: 1, PlayerID: "P1", BackerID: "P1"}: 500,
+				{TournamentID: 1, PlayerID: "P1", BackerID: "P3"}: 500,
+				{TournamentID: 2, PlayerID: "P2", BackerID: "P2"}: 10,
+			},
+		}
+		r := Reconcile(players, tournaments, winners, sums)
+		assert.False(t, r.OK())
+		assert.Equal(t, []Drift{
+			{Kind: DriftPlayerBalance, PlayerID: "P2", Expected: 50, Actual: 40},
+			{Kind: DriftPotBalance, TournamentID: 2, Expected: 90, Actual: 100},
+			{Kind: DriftUnbalancedEntry, EntryID: 7, Expected: 0, Actual: 10},
+			{Kind: DriftWinnerPayout, TournamentID: 1, PlayerID: "P1", BackerID: "P2", Expected: 0, Actual: 500},
+			{Kind: DriftWinnerPayout, TournamentID: 1, PlayerID: "P1", BackerID: "P3", Expected: 500, Actual: 0},
+		}, r.Drifts)
+	})
+}
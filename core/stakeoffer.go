@@ -0,0 +1,240 @@
+package core
+
+// StakeOffer is an offer of a tournament player to sell part of its own
+// stake. Available and Markup are given in basis points, Available of the
+// entry deposit still for sale and Markup of the stake price, 10000 meaning
+// the stake is sold at its deposit value.
+type StakeOffer struct {
+	ID           int64  `json:"id"`
+	TournamentID int    `json:"tournamentId"`
+	PlayerID     string `json:"playerId"`
+	Available    int64  `json:"available"`
+	Markup       int64  `json:"markup"`
+}
+
+// checkStakingOpen verifies that stakes of tournament players may be traded,
+// it is allowed until the tournament starts.
+func (t *Tournament) checkStakingOpen() error {
+	switch t.State {
+	case TournamentRegistrationOpen, TournamentRegistrationClosed:
+		return nil
+	case TournamentFinished:
+		return ErrTournamentFinished
+	case TournamentCancelled:
+		return ErrTournamentCancelled
+	default:
+		return ErrStakingClosed
+	}
+}
+
+// ownStake returns stake of the tournament player itself.
+func (tp *TournPlayer) ownStake() *Backer {
+	for i := range tp.Backers {
+		if tp.Backers[i].PlayerID == tp.PlayerID {
+			return &tp.Backers[i]
+		}
+	}
+	return nil
+}
+
+// NewStakeOffer creates an offer to sell available basis points of the
+// tournament player's own stake at a given markup. Player must keep part of
+// its own stake and markup can not be lower than 10000.
+func (tp *TournPlayer) NewStakeOffer(t *Tournament, available, markup int64) (*StakeOffer, error) {
+	if err := t.checkStakingOpen(); err != nil {
+		return nil, err
+	}
+	own := tp.ownStake()
+	if own == nil || available <= 0 || markup < 10000 {
+		return nil, ErrInvalidStakeOffer
+	}
+	if mulDiv(tp.Fee, available, 10000) >= own.Points {
+		return nil, ErrInvalidStakeOffer
+	}
+	return &StakeOffer{
+		TournamentID: tp.TournamentID,
+		PlayerID:     tp.PlayerID,
+		Available:    available,
+		Markup:       markup,
+	}, nil
+}
+
+// Buy sells basis points of the offered stake to the buyer. Buyer pays the
+// stake deposit value with markup to the selling player and becomes its
+// backer, prizes are then split by the stakes. This function will mutate the
+// offer, tournament player and given players map. Returned ledger entry
+// records the payment.
+func (o *StakeOffer) Buy(tp *TournPlayer, t *Tournament, buyerID string, bps int64, players map[string]*Player) (*LedgerEntry, error) {
+	if err := t.checkStakingOpen(); err != nil {
+		return nil, err
+	}
+	if buyerID == o.PlayerID {
+		return nil, ErrInvalidStakePurchase
+	}
+	if bps > o.Available {
+		return nil, ErrStakeOfferExceeded
+	}
+	points := mulDiv(tp.Fee, bps, 10000)
+	own := tp.ownStake()
+	if bps <= 0 || points <= 0 || own == nil || own.Points <= points {
+		return nil, ErrInvalidStakePurchase
+	}
+	buyer, ok := players[buyerID]
+	if !ok {
+		return nil, ErrPlayerNotFound
+	}
+	seller, ok := players[o.PlayerID]
+	if !ok {
+		return nil, ErrPlayerNotFound
+	}
+	price := mulDiv(points, o.Markup, 10000)
+	if buyer.Balance < price {
+		return nil, ErrNegativePlayerBalance
+	}
+
+	entry, err := newLedgerEntry(TransferStakeSale,
+		Transfer{
+			Account:        AccountPlayer,
+			PlayerID:       buyerID,
+			Points:         -price,
+			TournamentID:   tp.TournamentID,
+			BackedPlayerID: tp.PlayerID,
+		},
+		Transfer{
+			Account:        AccountPlayer,
+			PlayerID:       o.PlayerID,
+			Points:         price,
+			TournamentID:   tp.TournamentID,
+			BackedPlayerID: tp.PlayerID,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	buyer.Balance -= price
+	seller.Balance += price
+	own.Points -= points
+	bought := false
+	for i := range tp.Backers {
+		if tp.Backers[i].PlayerID == buyerID {
+			tp.Backers[i].Points += points
+			bought = true
+		}
+	}
+	if !bought {
+		tp.Backers = append(tp.Backers, Backer{PlayerID: buyerID, Points: points})
+	}
+	o.Available -= bps
+	return entry, nil
+}
+
+// StakeSale is a purchase of tournament player's stake. Points is the
+// deposit value of the bought stake, Price is the price the buyer paid for it.
+type StakeSale struct {
+	BuyerID string
+	Points  int64
+	Price   int64
+}
+
+// ReturnSoldStakes finds stake sales of the tournament player in transfer log
+// records of its entry and returns the bought stakes back to the player, so
+// that refund of the entry returns every backer its own deposit. Sales
+// reversed by earlier refunds are skipped. This function will mutate the
+// tournament player, returned sales must be refunded to buyers by
+// RefundStakeSales once the entry is refunded.
+func (tp *TournPlayer) ReturnSoldStakes(transfers []Transfer) []StakeSale {
+	deposits := make(map[string]int64)
+	prices := make(map[string]int64)
+	for _, tr := range transfers {
+		if tr.Account != AccountPlayer || tr.TournamentID != tp.TournamentID || tr.BackedPlayerID != tp.PlayerID {
+			continue
+		}
+		switch tr.Op {
+		case TransferDeposit, TransferRefund:
+			deposits[tr.PlayerID] -= tr.Points
+		case TransferStakeSale, TransferStakeRefund:
+			if tr.PlayerID != tp.PlayerID {
+				prices[tr.PlayerID] -= tr.Points
+			}
+		}
+	}
+
+	var sales []StakeSale
+	returned := int64(0)
+	for _, b := range tp.Backers {
+		if b.PlayerID == tp.PlayerID || prices[b.PlayerID] <= 0 {
+			continue
+		}
+		sales = append(sales, StakeSale{
+			BuyerID: b.PlayerID,
+			Points:  b.Points - deposits[b.PlayerID],
+			Price:   prices[b.PlayerID],
+		})
+		returned += b.Points - deposits[b.PlayerID]
+	}
+	if len(sales) == 0 {
+		return nil
+	}
+
+	backers := make([]Backer, 0, len(tp.Backers))
+	for _, b := range tp.Backers {
+		switch {
+		case b.PlayerID == tp.PlayerID:
+			b.Points += returned
+		case prices[b.PlayerID] > 0:
+			// buyers which were not backers before keep no stake
+			if b.Points = deposits[b.PlayerID]; b.Points == 0 {
+				continue
+			}
+		}
+		backers = append(backers, b)
+	}
+	tp.Backers = backers
+	return sales
+}
+
+// RefundStakeSales refunds prices of reversed stake sales from the tournament
+// player to buyers. This function will mutate given players map. Returned
+// ledger entry records the refunds.
+func (tp *TournPlayer) RefundStakeSales(sales []StakeSale, players map[string]*Player) (*LedgerEntry, error) {
+	seller, ok := players[tp.PlayerID]
+	if !ok {
+		return nil, ErrPlayerNotFound
+	}
+	transfers := make([]Transfer, 0, len(sales)+1)
+	total := int64(0)
+	for _, sale := range sales {
+		if _, ok := players[sale.BuyerID]; !ok {
+			return nil, ErrPlayerNotFound
+		}
+		transfers = append(transfers, Transfer{
+			Account:        AccountPlayer,
+			PlayerID:       sale.BuyerID,
+			Points:         sale.Price,
+			TournamentID:   tp.TournamentID,
+			BackedPlayerID: tp.PlayerID,
+		})
+		total += sale.Price
+	}
+	if seller.Balance < total {
+		return nil, ErrNegativePlayerBalance
+	}
+	transfers = append(transfers, Transfer{
+		Account:        AccountPlayer,
+		PlayerID:       tp.PlayerID,
+		Points:         -total,
+		TournamentID:   tp.TournamentID,
+		BackedPlayerID: tp.PlayerID,
+	})
+	entry, err := newLedgerEntry(TransferStakeRefund, transfers...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sale := range sales {
+		players[sale.BuyerID].Balance += sale.Price
+	}
+	seller.Balance -= total
+	return entry, nil
+}
@@ -0,0 +1,255 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStakeOffer(t *testing.T) {
+	tp := TournPlayer{
+		TournamentID: 1,
+		PlayerID:     "P1",
+		Fee:          100,
+		Backers: []Backer{
+			{PlayerID: "P1", Points: 80},
+			{PlayerID: "P2", Points: 20},
+		},
+	}
+	tests := []struct {
+		msg       string
+		state     TournamentState
+		available int64
+		markup    int64
+		offer     *StakeOffer
+		err       error
+	}{
+		{
+			msg:       "valid offer",
+			state:     TournamentRegistrationOpen,
+			available: 6000,
+			markup:    12000,
+			offer:     &StakeOffer{TournamentID: 1, PlayerID: "P1", Available: 6000, Markup: 12000},
+		},
+		{
+			msg:       "registration closed",
+			state:     TournamentRegistrationClosed,
+			available: 6000,
+			markup:    10000,
+			offer:     &StakeOffer{TournamentID: 1, PlayerID: "P1", Available: 6000, Markup: 10000},
+		},
+		{
+			msg:       "running tournament",
+			state:     TournamentRunning,
+			available: 6000,
+			markup:    10000,
+			err:       ErrStakingClosed,
+		},
+		{
+			msg:       "finished tournament",
+			state:     TournamentFinished,
+			available: 6000,
+			markup:    10000,
+			err:       ErrTournamentFinished,
+		},
+		{
+			msg:       "whole own stake",
+			state:     TournamentRegistrationOpen,
+			available: 8000,
+			markup:    10000,
+			err:       ErrInvalidStakeOffer,
+		},
+		{
+			msg:       "zero available",
+			state:     TournamentRegistrationOpen,
+			available: 0,
+			markup:    10000,
+			err:       ErrInvalidStakeOffer,
+		},
+		{
+			msg:       "discount",
+			state:     TournamentRegistrationOpen,
+			available: 6000,
+			markup:    9999,
+			err:       ErrInvalidStakeOffer,
+		},
+	}
+
+	for _, test := range tests {
+		tournament := &Tournament{ID: 1, EntryDeposit: 100, State: test.state}
+		offer, err := tp.NewStakeOffer(tournament, test.available, test.markup)
+		assert.Equal(t, test.err, err, test.msg)
+		assert.Equal(t, test.offer, offer, test.msg)
+	}
+}
+
+func TestStakeOfferBuy(t *testing.T) {
+	tournament := &Tournament{ID: 1, EntryDeposit: 100, State: TournamentRegistrationOpen}
+	tp := &TournPlayer{
+		TournamentID: 1,
+		PlayerID:     "P1",
+		Fee:          100,
+		Backers: []Backer{
+			{PlayerID: "P1", Points: 80},
+			{PlayerID: "P2", Points: 20},
+		},
+	}
+	offer := &StakeOffer{ID: 1, TournamentID: 1, PlayerID: "P1", Available: 6000, Markup: 12000}
+	players := map[string]*Player{
+		"P1": {PlayerID: "P1", Balance: 0},
+		"P2": {PlayerID: "P2", Balance: 100},
+		"P3": {PlayerID: "P3", Balance: 40},
+	}
+
+	entry, err := offer.Buy(tp, tournament, "P3", 2500, players)
+	assert.NoError(t, err)
+	assert.Equal(t, &LedgerEntry{
+		Op: TransferStakeSale,
+		Transfers: []Transfer{
+			{Op: TransferStakeSale, Account: AccountPlayer, PlayerID: "P3", Points: -30, TournamentID: 1, BackedPlayerID: "P1"},
+			{Op: TransferStakeSale, Account: AccountPlayer, PlayerID: "P1", Points: 30, TournamentID: 1, BackedPlayerID: "P1"},
+		},
+	}, entry)
+	assert.Equal(t, int64(3500), offer.Available)
+	assert.Equal(t, []Backer{
+		{PlayerID: "P1", Points: 55},
+		{PlayerID: "P2", Points: 20},
+		{PlayerID: "P3", Points: 25},
+	}, tp.Backers)
+	assert.Equal(t, int64(30), players["P1"].Balance)
+	assert.Equal(t, int64(10), players["P3"].Balance)
+
+	// existing backer buys additional stake
+	_, err = offer.Buy(tp, tournament, "P2", 1000, players)
+	assert.NoError(t, err)
+	assert.Equal(t, []Backer{
+		{PlayerID: "P1", Points: 45},
+		{PlayerID: "P2", Points: 30},
+		{PlayerID: "P3", Points: 25},
+	}, tp.Backers)
+	assert.Equal(t, int64(2500), offer.Available)
+	assert.Equal(t, int64(42), players["P1"].Balance)
+	assert.Equal(t, int64(88), players["P2"].Balance)
+
+	tests := []struct {
+		msg     string
+		buyerID string
+		bps     int64
+		err     error
+	}{
+		{msg: "oversell", buyerID: "P2", bps: 2501, err: ErrStakeOfferExceeded},
+		{msg: "seller", buyerID: "P1", bps: 100, err: ErrInvalidStakePurchase},
+		{msg: "zero points", buyerID: "P2", bps: 99, err: ErrInvalidStakePurchase},
+		{msg: "unknown buyer", buyerID: "P4", bps: 100, err: ErrPlayerNotFound},
+		{msg: "insufficient balance", buyerID: "P3", bps: 1000, err: ErrNegativePlayerBalance},
+	}
+	for _, test := range tests {
+		entry, err := offer.Buy(tp, tournament, test.buyerID, test.bps, players)
+		assert.Equal(t, test.err, err, test.msg)
+		assert.Nil(t, entry, test.msg)
+		assert.Equal(t, int64(2500), offer.Available, test.msg)
+	}
+
+	tournament.State = TournamentRunning
+	_, err = offer.Buy(tp, tournament, "P2", 100, players)
+	assert.Equal(t, ErrStakingClosed, err)
+}
+
+func TestReturnSoldStakes(t *testing.T) {
+	transfer := func(op TransferOp, playerID string, points int64) Transfer {
+		return Transfer{Op: op, Account: AccountPlayer, PlayerID: playerID, Points: points, TournamentID: 1, BackedPlayerID: "P1"}
+	}
+	tests := []struct {
+		msg       string
+		backers   []Backer
+		transfers []Transfer
+		sales     []StakeSale
+		out       []Backer
+	}{
+		{
+			msg:     "no sales",
+			backers: []Backer{{PlayerID: "P1", Points: 80}, {PlayerID: "P2", Points: 20}},
+			transfers: []Transfer{
+				transfer(TransferDeposit, "P1", -80),
+				transfer(TransferDeposit, "P2", -20),
+			},
+			out: []Backer{{PlayerID: "P1", Points: 80}, {PlayerID: "P2", Points: 20}},
+		},
+		{
+			msg:     "sales to backer and new buyer",
+			backers: []Backer{{PlayerID: "P1", Points: 45}, {PlayerID: "P2", Points: 30}, {PlayerID: "P3", Points: 25}},
+			transfers: []Transfer{
+				transfer(TransferDeposit, "P1", -80),
+				transfer(TransferDeposit, "P2", -20),
+				transfer(TransferStakeSale, "P3", -30),
+				transfer(TransferStakeSale, "P1", 30),
+				transfer(TransferStakeSale, "P2", -12),
+				transfer(TransferStakeSale, "P1", 12),
+				{Op: TransferDeposit, Account: AccountPlayer, PlayerID: "P3", Points: -100, TournamentID: 1, BackedPlayerID: "P3"},
+			},
+			sales: []StakeSale{
+				{BuyerID: "P2", Points: 10, Price: 12},
+				{BuyerID: "P3", Points: 25, Price: 30},
+			},
+			out: []Backer{{PlayerID: "P1", Points: 80}, {PlayerID: "P2", Points: 20}},
+		},
+		{
+			msg:     "sales reversed by earlier refund",
+			backers: []Backer{{PlayerID: "P1", Points: 100}},
+			transfers: []Transfer{
+				transfer(TransferDeposit, "P1", -100),
+				transfer(TransferStakeSale, "P3", -30),
+				transfer(TransferStakeSale, "P1", 30),
+				transfer(TransferRefund, "P1", 100),
+				transfer(TransferStakeRefund, "P3", 30),
+				transfer(TransferStakeRefund, "P1", -30),
+				transfer(TransferDeposit, "P1", -100),
+			},
+			out: []Backer{{PlayerID: "P1", Points: 100}},
+		},
+	}
+
+	for _, test := range tests {
+		tp := &TournPlayer{TournamentID: 1, PlayerID: "P1", Fee: 100, Backers: test.backers}
+		sales := tp.ReturnSoldStakes(test.transfers)
+		assert.Equal(t, test.sales, sales, test.msg)
+		assert.Equal(t, test.out, tp.Backers, test.msg)
+	}
+}
+
+func TestRefundStakeSales(t *testing.T) {
+	tp := &TournPlayer{TournamentID: 1, PlayerID: "P1", Fee: 100}
+	sales := []StakeSale{
+		{BuyerID: "P2", Points: 10, Price: 12},
+		{BuyerID: "P3", Points: 25, Price: 30},
+	}
+
+	players := map[string]*Player{
+		"P1": {PlayerID: "P1", Balance: 41},
+		"P2": {PlayerID: "P2", Balance: 0},
+	}
+	entry, err := tp.RefundStakeSales(sales, players)
+	assert.Equal(t, ErrPlayerNotFound, err)
+	assert.Nil(t, entry)
+
+	players["P3"] = &Player{PlayerID: "P3", Balance: 0}
+	entry, err = tp.RefundStakeSales(sales, players)
+	assert.Equal(t, ErrNegativePlayerBalance, err)
+	assert.Nil(t, entry)
+	assert.Equal(t, int64(41), players["P1"].Balance)
+
+	players["P1"].Balance = 42
+	entry, err = tp.RefundStakeSales(sales, players)
+	assert.NoError(t, err)
+	assert.Equal(t, &LedgerEntry{
+		Op: TransferStakeRefund,
+		Transfers: []Transfer{
+			{Op: TransferStakeRefund, Account: AccountPlayer, PlayerID: "P2", Points: 12, TournamentID: 1, BackedPlayerID: "P1"},
+			{Op: TransferStakeRefund, Account: AccountPlayer, PlayerID: "P3", Points: 30, TournamentID: 1, BackedPlayerID: "P1"},
+			{Op: TransferStakeRefund, Account: AccountPlayer, PlayerID: "P1", Points: -42, TournamentID: 1, BackedPlayerID: "P1"},
+		},
+	}, entry)
+	assert.Equal(t, int64(0), players["P1"].Balance)
+	assert.Equal(t, int64(12), players["P2"].Balance)
+	assert.Equal(t, int64(30), players["P3"].Balance)
+}
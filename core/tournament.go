@@ -1,9 +1,21 @@
 package core
 
+import "math/big"
+
 type Tournament struct {
 	ID           int
 	EntryDeposit int64
-	Active       bool
+	State        TournamentState
+	// Pot holds entry deposits collected from tournament players which are
+	// not paid out yet.
+	Pot int64
+	// RakePercent of the collected pot and flat HouseFee are taken by the
+	// house when tournament is resulted.
+	RakePercent int
+	HouseFee    int64
+	// Payout distributes the prize pool by finishing places, prizes are given
+	// explicitly when resulting tournaments without payout structure.
+	Payout *PayoutStructure
 }
 
 type Backer struct {
@@ -60,7 +72,14 @@ func splitPoints(points int64, n int) []int64 {
 	return parts
 }
 
-// NewTournament creates a new tournament object.
+// mulDiv returns points*num/den rounded towards zero. Intermediate product is
+// calculated without overflow.
+func mulDiv(points, num, den int64) int64 {
+	r := new(big.Int).Mul(big.NewInt(points), big.NewInt(num))
+	return r.Quo(r, big.NewInt(den)).Int64()
+}
+
+// NewTournament creates a new tournament object in announced state.
 func NewTournament(tournamentID int, deposit int64) (*Tournament, error) {
 	if deposit <= 0 {
 		return nil, ErrInvalidTournamentDeposit
@@ -68,32 +87,158 @@ func NewTournament(tournamentID int, deposit int64) (*Tournament, error) {
 	return &Tournament{
 		ID:           tournamentID,
 		EntryDeposit: deposit,
-		Active:       true,
+		State:        TournamentAnnounced,
 	}, nil
 }
 
+// SetRake configures the house cut taken from the tournament pot: percentage
+// of collected deposits and a flat fee.
+func (t *Tournament) SetRake(percent int, fee int64) error {
+	if percent < 0 || percent > 100 || fee < 0 {
+		return ErrInvalidRake
+	}
+	t.RakePercent = percent
+	t.HouseFee = fee
+	return nil
+}
+
+// SetPayout configures the payout structure used to compute prizes by
+// finishing places, nil payout requires explicit prizes.
+func (t *Tournament) SetPayout(p *PayoutStructure) error {
+	if p != nil {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+	t.Payout = p
+	return nil
+}
+
+// Rake returns the amount of points house takes from the current pot. Rake
+// never exceeds the pot.
+func (t *Tournament) Rake() int64 {
+	if t.Pot <= 0 {
+		return 0
+	}
+	rake := mulDiv(t.Pot, int64(t.RakePercent), 100) + t.HouseFee
+	if rake > t.Pot {
+		return t.Pot
+	}
+	return rake
+}
+
+// PrizePool returns the amount of points available for prizes, it is the
+// current pot minus rake.
+func (t *Tournament) PrizePool() int64 {
+	return t.Pot - t.Rake()
+}
+
+// CheckPrizes verifies that total prizes do not exceed the prize pool. Prizes
+// exceeding the pool are accepted only if overlay is explicitly allowed, the
+// difference is then covered by the house.
+func (t *Tournament) CheckPrizes(total int64, overlay bool) error {
+	if !overlay && total > t.PrizePool() {
+		return ErrPrizesExceedPool
+	}
+	return nil
+}
+
+// CollectRake moves rake from the tournament pot to the house account
+// together with the part of the prize pool not claimed by prizes totalling
+// prizes, so that the pot is emptied by paying out the prizes. Nil ledger entry
+// is returned if there is nothing to collect. This function will mutate the
+// tournament.
+func (t *Tournament) CollectRake(prizes int64) (*LedgerEntry, error) {
+	rake := t.Rake()
+	if unclaimed := t.PrizePool() - prizes; unclaimed > 0 {
+		rake += unclaimed
+	}
+	if rake == 0 {
+		return nil, nil
+	}
+	entry, err := newLedgerEntry(TransferRake,
+		Transfer{
+			Account:      AccountPot,
+			Points:       -rake,
+			TournamentID: t.ID,
+		},
+		Transfer{
+			Account:      AccountHouse,
+			Points:       rake,
+			TournamentID: t.ID,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	t.Pot -= rake
+	return entry, nil
+}
+
+// checkRegistrationOpen verifies that players may join or leave the
+// tournament.
+func (t *Tournament) checkRegistrationOpen() error {
+	switch t.State {
+	case TournamentRegistrationOpen:
+		return nil
+	case TournamentFinished:
+		return ErrTournamentFinished
+	case TournamentCancelled:
+		return ErrTournamentCancelled
+	default:
+		return ErrRegistrationClosed
+	}
+}
+
 // NewTournPlayer joins given player and its backers to the tournament by
-// creating new tournament player object.
+// creating new tournament player object. Entry deposit is split equally
+// between the player and its backers.
 func (t *Tournament) NewTournPlayer(playerID string, backerIDs []string) (*TournPlayer, error) {
 	ids := append([]string{playerID}, backerIDs...)
 	if t.EntryDeposit < int64(len(ids)) {
 		return nil, ErrTooManyBackers
 	}
-	if !t.Active {
-		return nil, ErrTournamentFinished
-	}
-	if hasDuplicates(ids) {
-		return nil, ErrDuplicateBackers
-	}
 
 	parts := splitPoints(t.EntryDeposit, len(ids))
-	b := make([]Backer, len(parts))
+	stakes := make([]Backer, len(parts))
 	for i, pts := range parts {
-		b[i] = Backer{
+		stakes[i] = Backer{
 			PlayerID: ids[i],
 			Points:   pts,
 		}
 	}
+	return t.NewStakedTournPlayer(playerID, stakes)
+}
+
+// NewStakedTournPlayer joins given player to the tournament with explicit
+// stakes of the player and its backers. Stakes must start with the player's
+// own stake, every stake must be positive and all of them must sum up to the
+// tournament entry deposit.
+func (t *Tournament) NewStakedTournPlayer(playerID string, stakes []Backer) (*TournPlayer, error) {
+	if err := t.checkRegistrationOpen(); err != nil {
+		return nil, err
+	}
+	if len(stakes) == 0 || stakes[0].PlayerID != playerID {
+		return nil, ErrInvalidStakes
+	}
+	ids := make([]string, len(stakes))
+	total := int64(0)
+	for i, s := range stakes {
+		if s.Points <= 0 {
+			return nil, ErrInvalidStakes
+		}
+		ids[i] = s.PlayerID
+		total += s.Points
+	}
+	if hasDuplicates(ids) {
+		return nil, ErrDuplicateBackers
+	}
+	if total != t.EntryDeposit {
+		return nil, ErrInvalidStakes
+	}
+
+	b := make([]Backer, len(stakes))
+	copy(b, stakes)
 	return &TournPlayer{
 		TournamentID: t.ID,
 		PlayerID:     playerID,
@@ -102,41 +247,172 @@ func (t *Tournament) NewTournPlayer(playerID string, backerIDs []string) (*Tourn
 	}, nil
 }
 
-// MarkFinished updates tournament to be marked as finished.
-func (t *Tournament) MarkFinished() error {
-	if !t.Active {
+// StakesFromBasisPoints converts stakes given in basis points of the entry
+// deposit to points. Basis points must be positive and sum up to 10000,
+// rounding remainder goes to the first stakes.
+func (t *Tournament) StakesFromBasisPoints(playerIDs []string, bps []int64) ([]Backer, error) {
+	if len(playerIDs) != len(bps) {
+		return nil, ErrInvalidStakes
+	}
+	total := int64(0)
+	for _, bp := range bps {
+		if bp <= 0 {
+			return nil, ErrInvalidStakes
+		}
+		total += bp
+	}
+	if total != 10000 {
+		return nil, ErrInvalidStakes
+	}
+
+	parts := splitWeighted(t.EntryDeposit, bps)
+	stakes := make([]Backer, len(parts))
+	for i, pts := range parts {
+		stakes[i] = Backer{
+			PlayerID: playerIDs[i],
+			Points:   pts,
+		}
+	}
+	return stakes, nil
+}
+
+// checkNotEnded verifies that the tournament is neither finished nor
+// cancelled.
+func (t *Tournament) checkNotEnded() error {
+	switch t.State {
+	case TournamentFinished:
 		return ErrTournamentFinished
+	case TournamentCancelled:
+		return ErrTournamentCancelled
+	default:
+		return nil
 	}
-	t.Active = false
-	return nil
+}
+
+// MarkFinished updates tournament to be marked as finished. Only running
+// tournaments can be finished.
+func (t *Tournament) MarkFinished() error {
+	if err := t.checkNotEnded(); err != nil {
+		return err
+	}
+	return t.Transition(TournamentFinished)
+}
+
+// MarkCancelled updates tournament to be marked as cancelled. Finished
+// tournaments can not be cancelled.
+func (t *Tournament) MarkCancelled() error {
+	if err := t.checkNotEnded(); err != nil {
+		return err
+	}
+	return t.Transition(TournamentCancelled)
 }
 
 // DeductDeposit updates player and its backers balances to pay for
-// participating in a tournament. This function will mutate given players map.
-func (tp *TournPlayer) DeductDeposit(players map[string]*Player) error {
+// participating in a tournament. Deposits are moved to the tournament pot. This
+// function will mutate given players map and tournament. Returned ledger entry
+// records the movement.
+func (tp *TournPlayer) DeductDeposit(players map[string]*Player, t *Tournament) (*LedgerEntry, error) {
+	transfers := make([]Transfer, 0, len(tp.Backers)+1)
+	total := int64(0)
 	for _, b := range tp.Backers {
 		p, ok := players[b.PlayerID]
 		if !ok {
-			return ErrPlayerNotFound
+			return nil, ErrPlayerNotFound
 		}
 		if p.Balance < b.Points {
-			return ErrNegativePlayerBalance
+			return nil, ErrNegativePlayerBalance
 		}
+		transfers = append(transfers, Transfer{
+			Account:        AccountPlayer,
+			PlayerID:       b.PlayerID,
+			Points:         -b.Points,
+			TournamentID:   tp.TournamentID,
+			BackedPlayerID: tp.PlayerID,
+		})
+		total += b.Points
 	}
+	transfers = append(transfers, Transfer{
+		Account:        AccountPot,
+		Points:         total,
+		TournamentID:   tp.TournamentID,
+		BackedPlayerID: tp.PlayerID,
+	})
+	entry, err := newLedgerEntry(TransferDeposit, transfers...)
+	if err != nil {
+		return nil, err
+	}
+
 	for _, b := range tp.Backers {
 		players[b.PlayerID].Balance -= b.Points
 	}
-	return nil
+	t.Pot += total
+	return entry, nil
+}
+
+// Refund returns deposits of the player and its backers back from the
+// tournament pot. This function will mutate given players map and tournament.
+// Returned ledger entry records the movement.
+func (tp *TournPlayer) Refund(players map[string]*Player, t *Tournament) (*LedgerEntry, error) {
+	transfers := make([]Transfer, 0, len(tp.Backers)+1)
+	total := int64(0)
+	for _, b := range tp.Backers {
+		if _, ok := players[b.PlayerID]; !ok {
+			return nil, ErrPlayerNotFound
+		}
+		transfers = append(transfers, Transfer{
+			Account:        AccountPlayer,
+			PlayerID:       b.PlayerID,
+			Points:         b.Points,
+			TournamentID:   tp.TournamentID,
+			BackedPlayerID: tp.PlayerID,
+		})
+		total += b.Points
+	}
+	if t.Pot < total {
+		return nil, ErrNegativePotBalance
+	}
+	transfers = append(transfers, Transfer{
+		Account:        AccountPot,
+		Points:         -total,
+		TournamentID:   tp.TournamentID,
+		BackedPlayerID: tp.PlayerID,
+	})
+	entry, err := newLedgerEntry(TransferRefund, transfers...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range tp.Backers {
+		players[b.PlayerID].Balance += b.Points
+	}
+	t.Pot -= total
+	return entry, nil
+}
+
+// Withdraw removes player from the tournament by refunding deposits of the
+// player and its backers. Players may withdraw only while tournament
+// registration is open. This function will mutate given players map and
+// tournament.
+func (tp *TournPlayer) Withdraw(players map[string]*Player, t *Tournament) (*LedgerEntry, error) {
+	if err := t.checkRegistrationOpen(); err != nil {
+		return nil, err
+	}
+	return tp.Refund(players, t)
 }
 
 // NewTournWinner creates a new tournament winner object for a given tournament
-// player and tournament winner prize. Prize is distributed in equal parts for
-// all participation backers with the same algorithm as participation fee.
+// player and tournament winner prize. Prize is distributed between the player
+// and its backers proportionally to their stakes in the entry deposit,
+// rounding remainder goes to the first stakes.
 func (tp *TournPlayer) NewTournWinner(prize int64) (*TournWinner, error) {
 	if prize < 0 {
 		return nil, ErrInvalidTournamentPrize
 	}
-	parts := splitPoints(prize, len(tp.Backers))
+	stakes := make([]int64, len(tp.Backers))
+	for i, b := range tp.Backers {
+		stakes[i] = b.Points
+	}
+	parts := splitWeighted(prize, stakes)
 	b := make([]Backer, len(parts))
 	for i, pts := range parts {
 		b[i] = Backer{
@@ -153,15 +429,55 @@ func (tp *TournPlayer) NewTournWinner(prize int64) (*TournWinner, error) {
 }
 
 // PayoutPrize updates tournament winner and its backers balances to receive
-// winners prize. This function will mutate given player map.
-func (tw *TournWinner) PayoutPrize(players map[string]*Player) error {
+// winners prize. Prize is paid out of the tournament pot, if pot balance is not
+// sufficient the remainder (overlay) is covered by the house account. This
+// function will mutate given player map and tournament. Returned ledger entry
+// records the movement.
+func (tw *TournWinner) PayoutPrize(players map[string]*Player, t *Tournament) (*LedgerEntry, error) {
+	transfers := make([]Transfer, 0, len(tw.Backers)+2)
+	total := int64(0)
 	for _, b := range tw.Backers {
 		if _, ok := players[b.PlayerID]; !ok {
-			return ErrPlayerNotFound
+			return nil, ErrPlayerNotFound
 		}
+		transfers = append(transfers, Transfer{
+			Account:        AccountPlayer,
+			PlayerID:       b.PlayerID,
+			Points:         b.Points,
+			TournamentID:   tw.TournamentID,
+			BackedPlayerID: tw.PlayerID,
+		})
+		total += b.Points
+	}
+
+	fromPot := total
+	if fromPot > t.Pot {
+		fromPot = t.Pot
 	}
+	if fromPot != 0 {
+		transfers = append(transfers, Transfer{
+			Account:        AccountPot,
+			Points:         -fromPot,
+			TournamentID:   tw.TournamentID,
+			BackedPlayerID: tw.PlayerID,
+		})
+	}
+	if overlay := total - fromPot; overlay != 0 {
+		transfers = append(transfers, Transfer{
+			Account:        AccountHouse,
+			Points:         -overlay,
+			TournamentID:   tw.TournamentID,
+			BackedPlayerID: tw.PlayerID,
+		})
+	}
+	entry, err := newLedgerEntry(TransferPrize, transfers...)
+	if err != nil {
+		return nil, err
+	}
+
 	for _, b := range tw.Backers {
 		players[b.PlayerID].Balance += b.Points
 	}
-	return nil
+	t.Pot -= fromPot
+	return entry, nil
 }
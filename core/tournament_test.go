@@ -86,14 +86,24 @@ func TestTournamentMarkFinished(t *testing.T) {
 		err error
 	}{
 		{
-			in:  &Tournament{Active: true},
-			out: &Tournament{Active: false},
+			in:  &Tournament{State: TournamentRunning},
+			out: &Tournament{State: TournamentFinished},
 		},
 		{
-			in:  &Tournament{Active: false},
-			out: &Tournament{Active: false},
+			in:  &Tournament{State: TournamentRegistrationOpen},
+			out: &Tournament{State: TournamentRegistrationOpen},
+			err: &TransitionError{From: TournamentRegistrationOpen, To: TournamentFinished},
+		},
+		{
+			in:  &Tournament{State: TournamentFinished},
+			out: &Tournament{State: TournamentFinished},
 			err: ErrTournamentFinished,
 		},
+		{
+			in:  &Tournament{State: TournamentCancelled},
+			out: &Tournament{State: TournamentCancelled},
+			err: ErrTournamentCancelled,
+		},
 	}
 
 	for _, test := range tests {
@@ -103,6 +113,39 @@ func TestTournamentMarkFinished(t *testing.T) {
 	}
 }
 
+func TestTournamentMarkCancelled(t *testing.T) {
+	tests := []struct {
+		in  *Tournament
+		out *Tournament
+		err error
+	}{
+		{
+			in:  &Tournament{State: TournamentRegistrationOpen},
+			out: &Tournament{State: TournamentCancelled},
+		},
+		{
+			in:  &Tournament{State: TournamentRunning},
+			out: &Tournament{State: TournamentCancelled},
+		},
+		{
+			in:  &Tournament{State: TournamentFinished},
+			out: &Tournament{State: TournamentFinished},
+			err: ErrTournamentFinished,
+		},
+		{
+			in:  &Tournament{State: TournamentCancelled},
+			out: &Tournament{State: TournamentCancelled},
+			err: ErrTournamentCancelled,
+		},
+	}
+
+	for _, test := range tests {
+		err := test.in.MarkCancelled()
+		assert.Equal(t, test.err, err)
+		assert.Equal(t, test.out, test.in)
+	}
+}
+
 func TestNewTournPlayer(t *testing.T) {
 	tests := []struct {
 		msg       string
@@ -117,28 +160,58 @@ func TestNewTournPlayer(t *testing.T) {
 			t: Tournament{
 				ID:           123,
 				EntryDeposit: 3,
-				Active:       true,
+				State:        TournamentRegistrationOpen,
 			},
 			playerID:  "P1",
 			backerIDs: []string{"P2", "P3", "P4"},
 			err:       ErrTooManyBackers,
 		},
 		{
-			msg: "inactive tournament",
+			msg: "finished tournament",
 			t: Tournament{
 				ID:           123,
 				EntryDeposit: 1,
-				Active:       false,
+				State:        TournamentFinished,
 			},
 			playerID: "P1",
 			err:      ErrTournamentFinished,
 		},
+		{
+			msg: "cancelled tournament",
+			t: Tournament{
+				ID:           123,
+				EntryDeposit: 1,
+				State:        TournamentCancelled,
+			},
+			playerID: "P1",
+			err:      ErrTournamentCancelled,
+		},
+		{
+			msg: "announced tournament",
+			t: Tournament{
+				ID:           123,
+				EntryDeposit: 1,
+				State:        TournamentAnnounced,
+			},
+			playerID: "P1",
+			err:      ErrRegistrationClosed,
+		},
+		{
+			msg: "running tournament",
+			t: Tournament{
+				ID:           123,
+				EntryDeposit: 1,
+				State:        TournamentRunning,
+			},
+			playerID: "P1",
+			err:      ErrRegistrationClosed,
+		},
 		{
 			msg: "single player",
 			t: Tournament{
 				ID:           123,
 				EntryDeposit: 1,
-				Active:       true,
+				State:        TournamentRegistrationOpen,
 			},
 			playerID: "P1",
 			tp: &TournPlayer{
@@ -155,7 +228,7 @@ func TestNewTournPlayer(t *testing.T) {
 			t: Tournament{
 				ID:           123,
 				EntryDeposit: 100,
-				Active:       true,
+				State:        TournamentRegistrationOpen,
 			},
 			playerID:  "P1",
 			backerIDs: []string{"P2", "P3"},
@@ -175,7 +248,7 @@ func TestNewTournPlayer(t *testing.T) {
 			t: Tournament{
 				ID:           123,
 				EntryDeposit: 100,
-				Active:       true,
+				State:        TournamentRegistrationOpen,
 			},
 			playerID:  "P1",
 			backerIDs: []string{"P2", "P2"},
@@ -186,7 +259,7 @@ func TestNewTournPlayer(t *testing.T) {
 			t: Tournament{
 				ID:           123,
 				EntryDeposit: 100,
-				Active:       true,
+				State:        TournamentRegistrationOpen,
 			},
 			playerID:  "P1",
 			backerIDs: []string{"P1", "P2"},
@@ -201,12 +274,167 @@ func TestNewTournPlayer(t *testing.T) {
 	}
 }
 
+func TestNewStakedTournPlayer(t *testing.T) {
+	tournament := Tournament{
+		ID:           123,
+		EntryDeposit: 100,
+		State:        TournamentRegistrationOpen,
+	}
+	tests := []struct {
+		msg    string
+		t      Tournament
+		stakes []Backer
+		tp     *TournPlayer
+		err    error
+	}{
+		{
+			msg: "uneven stakes",
+			t:   tournament,
+			stakes: []Backer{
+				{PlayerID: "P1", Points: 20},
+				{PlayerID: "P2", Points: 40},
+				{PlayerID: "P3", Points: 40},
+			},
+			tp: &TournPlayer{
+				TournamentID: 123,
+				PlayerID:     "P1",
+				Fee:          100,
+				Backers: []Backer{
+					{PlayerID: "P1", Points: 20},
+					{PlayerID: "P2", Points: 40},
+					{PlayerID: "P3", Points: 40},
+				},
+			},
+		},
+		{
+			msg: "closed registration",
+			t:   Tournament{ID: 123, EntryDeposit: 100, State: TournamentRunning},
+			stakes: []Backer{
+				{PlayerID: "P1", Points: 100},
+			},
+			err: ErrRegistrationClosed,
+		},
+		{
+			msg: "no stakes",
+			t:   tournament,
+			err: ErrInvalidStakes,
+		},
+		{
+			msg: "player stake not first",
+			t:   tournament,
+			stakes: []Backer{
+				{PlayerID: "P2", Points: 50},
+				{PlayerID: "P1", Points: 50},
+			},
+			err: ErrInvalidStakes,
+		},
+		{
+			msg: "zero stake",
+			t:   tournament,
+			stakes: []Backer{
+				{PlayerID: "P1", Points: 100},
+				{PlayerID: "P2", Points: 0},
+			},
+			err: ErrInvalidStakes,
+		},
+		{
+			msg: "stakes below deposit",
+			t:   tournament,
+			stakes: []Backer{
+				{PlayerID: "P1", Points: 50},
+				{PlayerID: "P2", Points: 49},
+			},
+			err: ErrInvalidStakes,
+		},
+		{
+			msg: "stakes above deposit",
+			t:   tournament,
+			stakes: []Backer{
+				{PlayerID: "P1", Points: 50},
+				{PlayerID: "P2", Points: 51},
+			},
+			err: ErrInvalidStakes,
+		},
+		{
+			msg: "duplicate backers",
+			t:   tournament,
+			stakes: []Backer{
+				{PlayerID: "P1", Points: 50},
+				{PlayerID: "P2", Points: 25},
+				{PlayerID: "P2", Points: 25},
+			},
+			err: ErrDuplicateBackers,
+		},
+	}
+
+	for _, test := range tests {
+		tp, err := test.t.NewStakedTournPlayer("P1", test.stakes)
+		assert.Equal(t, test.err, err, test.msg)
+		assert.Equal(t, test.tp, tp, test.msg)
+	}
+}
+
+func TestStakesFromBasisPoints(t *testing.T) {
+	tournament := Tournament{ID: 123, EntryDeposit: 99}
+	tests := []struct {
+		msg       string
+		playerIDs []string
+		bps       []int64
+		stakes    []Backer
+		err       error
+	}{
+		{
+			msg:       "uneven stakes",
+			playerIDs: []string{"P1", "P2", "P3"},
+			bps:       []int64{2000, 4000, 4000},
+			stakes: []Backer{
+				{PlayerID: "P1", Points: 20},
+				{PlayerID: "P2", Points: 40},
+				{PlayerID: "P3", Points: 39},
+			},
+		},
+		{
+			msg:       "single stake",
+			playerIDs: []string{"P1"},
+			bps:       []int64{10000},
+			stakes: []Backer{
+				{PlayerID: "P1", Points: 99},
+			},
+		},
+		{
+			msg:       "mismatched stakes",
+			playerIDs: []string{"P1", "P2"},
+			bps:       []int64{10000},
+			err:       ErrInvalidStakes,
+		},
+		{
+			msg:       "sum below 10000",
+			playerIDs: []string{"P1", "P2"},
+			bps:       []int64{5000, 4999},
+			err:       ErrInvalidStakes,
+		},
+		{
+			msg:       "negative stake",
+			playerIDs: []string{"P1", "P2"},
+			bps:       []int64{10001, -1},
+			err:       ErrInvalidStakes,
+		},
+	}
+
+	for _, test := range tests {
+		stakes, err := tournament.StakesFromBasisPoints(test.playerIDs, test.bps)
+		assert.Equal(t, test.err, err, test.msg)
+		assert.Equal(t, test.stakes, stakes, test.msg)
+	}
+}
+
 func TestDeductDeposit(t *testing.T) {
 	tests := []struct {
 		msg string
 		tp  TournPlayer
 		in  map[string]*Player
 		out map[string]*Player
+		pot int64
 		err error
 	}{
 		{
@@ -263,13 +491,161 @@ func TestDeductDeposit(t *testing.T) {
 				"P3": &Player{Balance: 250},
 				"P4": &Player{Balance: 100},
 			},
+			pot: -144,
+		},
+		{
+			msg: "valid deposit",
+			tp: TournPlayer{
+				TournamentID: 1,
+				PlayerID:     "P1",
+				Backers: []Backer{
+					{PlayerID: "P1", Points: 50},
+					{PlayerID: "P2", Points: 50},
+				},
+			},
+			in: map[string]*Player{
+				"P1": &Player{Balance: 100},
+				"P2": &Player{Balance: 50},
+			},
+			out: map[string]*Player{
+				"P1": &Player{Balance: 50},
+				"P2": &Player{Balance: 0},
+			},
+			pot: 100,
 		},
 	}
 
 	for _, test := range tests {
-		err := test.tp.DeductDeposit(test.in)
+		tournament := &Tournament{}
+		entry, err := test.tp.DeductDeposit(test.in, tournament)
 		assert.Equal(t, test.err, err, test.msg)
 		assert.Equal(t, test.out, test.in, test.msg)
+		assert.Equal(t, test.pot, tournament.Pot, test.msg)
+		if err == nil {
+			assert.Equal(t, TransferDeposit, entry.Op, test.msg)
+			assert.Len(t, entry.Transfers, len(test.tp.Backers)+1, test.msg)
+			assert.NoError(t, entry.Validate(), test.msg)
+		}
+	}
+}
+
+func TestRefund(t *testing.T) {
+	tests := []struct {
+		msg    string
+		tp     TournPlayer
+		potIn  int64
+		in     map[string]*Player
+		out    map[string]*Player
+		potOut int64
+		err    error
+	}{
+		{
+			msg: "missing player",
+			tp: TournPlayer{
+				Backers: []Backer{
+					{PlayerID: "P1", Points: 1},
+				},
+			},
+			potIn:  1,
+			in:     map[string]*Player{},
+			out:    map[string]*Player{},
+			potOut: 1,
+			err:    ErrPlayerNotFound,
+		},
+		{
+			msg: "insufficient pot",
+			tp: TournPlayer{
+				Backers: []Backer{
+					{PlayerID: "P1", Points: 100},
+				},
+			},
+			potIn: 50,
+			in: map[string]*Player{
+				"P1": &Player{Balance: 0},
+			},
+			out: map[string]*Player{
+				"P1": &Player{Balance: 0},
+			},
+			potOut: 50,
+			err:    ErrNegativePotBalance,
+		},
+		{
+			msg: "valid refund",
+			tp: TournPlayer{
+				TournamentID: 1,
+				PlayerID:     "P1",
+				Fee:          100,
+				Backers: []Backer{
+					{PlayerID: "P1", Points: 50},
+					{PlayerID: "P2", Points: 50},
+				},
+			},
+			potIn: 300,
+			in: map[string]*Player{
+				"P1": &Player{Balance: 0},
+				"P2": &Player{Balance: 10},
+			},
+			out: map[string]*Player{
+				"P1": &Player{Balance: 50},
+				"P2": &Player{Balance: 60},
+			},
+			potOut: 200,
+		},
+	}
+
+	for _, test := range tests {
+		tournament := &Tournament{Pot: test.potIn}
+		entry, err := test.tp.Refund(test.in, tournament)
+		assert.Equal(t, test.err, err, test.msg)
+		assert.Equal(t, test.out, test.in, test.msg)
+		assert.Equal(t, test.potOut, tournament.Pot, test.msg)
+		if err == nil {
+			assert.Equal(t, TransferRefund, entry.Op, test.msg)
+			assert.NoError(t, entry.Validate(), test.msg)
+		}
+	}
+}
+
+func TestWithdraw(t *testing.T) {
+	tp := TournPlayer{
+		TournamentID: 1,
+		PlayerID:     "P1",
+		Fee:          100,
+		Backers: []Backer{
+			{PlayerID: "P1", Points: 50},
+			{PlayerID: "P2", Points: 50},
+		},
+	}
+	tests := []struct {
+		state TournamentState
+		err   error
+	}{
+		{state: TournamentAnnounced, err: ErrRegistrationClosed},
+		{state: TournamentRegistrationOpen},
+		{state: TournamentRegistrationClosed, err: ErrRegistrationClosed},
+		{state: TournamentRunning, err: ErrRegistrationClosed},
+		{state: TournamentFinished, err: ErrTournamentFinished},
+		{state: TournamentCancelled, err: ErrTournamentCancelled},
+	}
+
+	for _, test := range tests {
+		players := map[string]*Player{
+			"P1": &Player{Balance: 0},
+			"P2": &Player{Balance: 0},
+		}
+		tournament := &Tournament{State: test.state, Pot: 100}
+		entry, err := tp.Withdraw(players, tournament)
+		assert.Equal(t, test.err, err, string(test.state))
+		if err != nil {
+			assert.Nil(t, entry, string(test.state))
+			assert.Equal(t, int64(100), tournament.Pot, string(test.state))
+			assert.Equal(t, int64(0), players["P1"].Balance, string(test.state))
+			continue
+		}
+		assert.Equal(t, TransferRefund, entry.Op, string(test.state))
+		assert.Equal(t, int64(0), tournament.Pot, string(test.state))
+		assert.Equal(t, int64(50), players["P1"].Balance, string(test.state))
+		assert.Equal(t, int64(50), players["P2"].Balance, string(test.state))
 	}
 }
 
@@ -314,9 +690,33 @@ func TestNewTournWinner(t *testing.T) {
 				PlayerID:     "P1",
 				Prize:        500,
 				Backers: []Backer{
-					{PlayerID: "P1", Points: 167},
-					{PlayerID: "P3", Points: 167},
-					{PlayerID: "P3", Points: 166},
+					{PlayerID: "P1", Points: 170},
+					{PlayerID: "P3", Points: 165},
+					{PlayerID: "P3", Points: 165},
+				},
+			},
+		},
+		{
+			msg: "uneven stakes",
+			tp: TournPlayer{
+				TournamentID: 123,
+				PlayerID:     "P1",
+				Fee:          100,
+				Backers: []Backer{
+					{PlayerID: "P1", Points: 20},
+					{PlayerID: "P2", Points: 40},
+					{PlayerID: "P3", Points: 40},
+				},
+			},
+			prize: 1001,
+			tw: &TournWinner{
+				TournamentID: 123,
+				PlayerID:     "P1",
+				Prize:        1001,
+				Backers: []Backer{
+					{PlayerID: "P1", Points: 201},
+					{PlayerID: "P2", Points: 400},
+					{PlayerID: "P3", Points: 400},
 				},
 			},
 		},
@@ -335,6 +735,7 @@ func TestPayoutPrize(t *testing.T) {
 		tw  TournWinner
 		in  map[string]*Player
 		out map[string]*Player
+		pot int64
 		err error
 	}{
 		{
@@ -342,6 +743,7 @@ func TestPayoutPrize(t *testing.T) {
 			tw:  TournWinner{},
 			in:  map[string]*Player{},
 			out: map[string]*Player{},
+			pot: 80,
 		},
 		{
 			msg: "missing player",
@@ -352,6 +754,7 @@ func TestPayoutPrize(t *testing.T) {
 			},
 			in:  map[string]*Player{},
 			out: map[string]*Player{},
+			pot: 80,
 			err: ErrPlayerNotFound,
 		},
 		{
@@ -376,12 +779,243 @@ func TestPayoutPrize(t *testing.T) {
 				"P3": &Player{Balance: -50},
 				"P4": &Player{Balance: 100},
 			},
+			pot: 224,
+		},
+		{
+			msg: "overlay payout",
+			tw: TournWinner{
+				TournamentID: 1,
+				PlayerID:     "P1",
+				Prize:        100,
+				Backers: []Backer{
+					{PlayerID: "P1", Points: 60},
+					{PlayerID: "P2", Points: 40},
+				},
+			},
+			in: map[string]*Player{
+				"P1": &Player{Balance: 0},
+				"P2": &Player{Balance: 0},
+			},
+			out: map[string]*Player{
+				"P1": &Player{Balance: 60},
+				"P2": &Player{Balance: 40},
+			},
+			pot: 0,
 		},
 	}
 
 	for _, test := range tests {
-		err := test.tw.PayoutPrize(test.in)
+		tournament := &Tournament{Pot: 80}
+		entry, err := test.tw.PayoutPrize(test.in, tournament)
 		assert.Equal(t, test.err, err, test.msg)
 		assert.Equal(t, test.out, test.in, test.msg)
+		assert.Equal(t, test.pot, tournament.Pot, test.msg)
+		if err == nil {
+			assert.Equal(t, TransferPrize, entry.Op, test.msg)
+			assert.NoError(t, entry.Validate(), test.msg)
+		}
+	}
+}
+
+func TestPayoutPrizeOverlay(t *testing.T) {
+	tw := TournWinner{
+		TournamentID: 1,
+		PlayerID:     "P1",
+		Prize:        100,
+		Backers: []Backer{
+			{PlayerID: "P1", Points: 100},
+		},
+	}
+	players := map[string]*Player{"P1": &Player{PlayerID: "P1"}}
+	tournament := &Tournament{ID: 1, Pot: 80}
+
+	entry, err := tw.PayoutPrize(players, tournament)
+	assert.NoError(t, err)
+	assert.Equal(t, &LedgerEntry{
+		Op: TransferPrize,
+		Transfers: []Transfer{
+			{Op: TransferPrize, Account: AccountPlayer, PlayerID: "P1", Points: 100, TournamentID: 1, BackedPlayerID: "P1"},
+			{Op: TransferPrize, Account: AccountPot, Points: -80, TournamentID: 1, BackedPlayerID: "P1"},
+			{Op: TransferPrize, Account: AccountHouse, Points: -20, TournamentID: 1, BackedPlayerID: "P1"},
+		},
+	}, entry)
+	assert.Equal(t, int64(0), tournament.Pot)
+	assert.Equal(t, int64(100), players["P1"].Balance)
+}
+
+func TestSetRake(t *testing.T) {
+	tests := []struct {
+		percent int
+		fee     int64
+		err     error
+	}{
+		{percent: 0, fee: 0, err: nil},
+		{percent: 10, fee: 5, err: nil},
+		{percent: 100, fee: 0, err: nil},
+		{percent: -1, fee: 0, err: ErrInvalidRake},
+		{percent: 101, fee: 0, err: ErrInvalidRake},
+		{percent: 10, fee: -1, err: ErrInvalidRake},
+	}
+
+	for _, test := range tests {
+		msg := fmt.Sprintf("percent %d fee %d", test.percent, test.fee)
+		tournament := &Tournament{ID: 1, EntryDeposit: 100}
+		err := tournament.SetRake(test.percent, test.fee)
+		assert.Equal(t, test.err, err, msg)
+		if err == nil {
+			assert.Equal(t, test.percent, tournament.RakePercent, msg)
+			assert.Equal(t, test.fee, tournament.HouseFee, msg)
+		} else {
+			assert.Equal(t, 0, tournament.RakePercent, msg)
+			assert.Equal(t, int64(0), tournament.HouseFee, msg)
+		}
+	}
+}
+
+func TestPrizePool(t *testing.T) {
+	tests := []struct {
+		msg        string
+		tournament Tournament
+		rake       int64
+		pool       int64
+	}{
+		{
+			msg:        "no rake",
+			tournament: Tournament{Pot: 1000},
+			rake:       0,
+			pool:       1000,
+		},
+		{
+			msg:        "percentage",
+			tournament: Tournament{Pot: 1000, RakePercent: 10},
+			rake:       100,
+			pool:       900,
+		},
+		{
+			msg:        "percentage rounded down",
+			tournament: Tournament{Pot: 999, RakePercent: 10},
+			rake:       99,
+			pool:       900,
+		},
+		{
+			msg:        "flat fee",
+			tournament: Tournament{Pot: 1000, HouseFee: 50},
+			rake:       50,
+			pool:       950,
+		},
+		{
+			msg:        "percentage and flat fee",
+			tournament: Tournament{Pot: 1000, RakePercent: 5, HouseFee: 50},
+			rake:       100,
+			pool:       900,
+		},
+		{
+			msg:        "fee exceeding pot",
+			tournament: Tournament{Pot: 30, HouseFee: 50},
+			rake:       30,
+			pool:       0,
+		},
+		{
+			msg:        "empty pot",
+			tournament: Tournament{RakePercent: 10, HouseFee: 50},
+			rake:       0,
+			pool:       0,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.rake, test.tournament.Rake(), test.msg)
+		assert.Equal(t, test.pool, test.tournament.PrizePool(), test.msg)
+	}
+}
+
+func TestCheckPrizes(t *testing.T) {
+	tournament := &Tournament{Pot: 1000, RakePercent: 10}
+	tests := []struct {
+		total   int64
+		overlay bool
+		err     error
+	}{
+		{total: 0, overlay: false, err: nil},
+		{total: 900, overlay: false, err: nil},
+		{total: 901, overlay: false, err: ErrPrizesExceedPool},
+		{total: 1000, overlay: false, err: ErrPrizesExceedPool},
+		{total: 100000, overlay: true, err: nil},
+	}
+
+	for _, test := range tests {
+		msg := fmt.Sprintf("total %d overlay %t", test.total, test.overlay)
+		assert.Equal(t, test.err, tournament.CheckPrizes(test.total, test.overlay), msg)
+	}
+}
+
+func TestCollectRake(t *testing.T) {
+	tests := []struct {
+		msg        string
+		tournament Tournament
+		prizes     int64
+		rake       int64
+		pot        int64
+	}{
+		{
+			msg:        "whole prize pool",
+			tournament: Tournament{ID: 1, Pot: 1000, RakePercent: 10, HouseFee: 5},
+			prizes:     895,
+			rake:       105,
+			pot:        895,
+		},
+		{
+			msg:        "unclaimed prize pool",
+			tournament: Tournament{ID: 1, Pot: 1000, RakePercent: 10, HouseFee: 5},
+			prizes:     800,
+			rake:       200,
+			pot:        800,
+		},
+		{
+			msg:        "overlay",
+			tournament: Tournament{ID: 1, Pot: 1000, RakePercent: 10, HouseFee: 5},
+			prizes:     2000,
+			rake:       105,
+			pot:        895,
+		},
+		{
+			msg:        "no prizes",
+			tournament: Tournament{ID: 1, Pot: 1000},
+			prizes:     0,
+			rake:       1000,
+			pot:        0,
+		},
+		{
+			msg:        "no rake",
+			tournament: Tournament{ID: 1, Pot: 1000},
+			prizes:     1000,
+			rake:       0,
+			pot:        1000,
+		},
+		{
+			msg:        "empty pot",
+			tournament: Tournament{ID: 1, RakePercent: 10},
+			prizes:     100,
+			rake:       0,
+			pot:        0,
+		},
+	}
+
+	for _, test := range tests {
+		tournament := test.tournament
+		entry, err := tournament.CollectRake(test.prizes)
+		assert.NoError(t, err, test.msg)
+		if test.rake == 0 {
+			assert.Nil(t, entry, test.msg)
+		} else {
+			assert.Equal(t, &LedgerEntry{
+				Op: TransferRake,
+				Transfers: []Transfer{
+					{Op: TransferRake, Account: AccountPot, Points: -test.rake, TournamentID: 1},
+					{Op: TransferRake, Account: AccountHouse, Points: test.rake, TournamentID: 1},
+				},
+			}, entry, test.msg)
+		}
+		assert.Equal(t, test.pot, tournament.Pot, test.msg)
 	}
 }
@@ -0,0 +1,88 @@
+package core
+
+import "fmt"
+
+// TournamentState is a tournament lifecycle state.
+type TournamentState string
+
+const (
+	// TournamentAnnounced is the initial state, tournament is known but
+	// players can not join it yet.
+	TournamentAnnounced TournamentState = "announced"
+	// TournamentRegistrationOpen state allows players to join tournament.
+	TournamentRegistrationOpen TournamentState = "registration_open"
+	// TournamentRegistrationClosed state no longer allows players to join,
+	// but tournament is not started yet.
+	TournamentRegistrationClosed TournamentState = "registration_closed"
+	// TournamentRunning state is for tournaments in progress, only running
+	// tournaments can be resulted.
+	TournamentRunning TournamentState = "running"
+	// TournamentFinished is a final state of resulted tournament.
+	TournamentFinished TournamentState = "finished"
+	// TournamentCancelled is a final state of cancelled tournament.
+	TournamentCancelled TournamentState = "cancelled"
+)
+
+// tournamentTransitions lists legal target states for each non final state.
+var tournamentTransitions = map[TournamentState][]TournamentState{
+	TournamentAnnounced: {
+		TournamentRegistrationOpen,
+		TournamentCancelled,
+	},
+	TournamentRegistrationOpen: {
+		TournamentRegistrationClosed,
+		TournamentRunning,
+		TournamentCancelled,
+	},
+	TournamentRegistrationClosed: {
+		TournamentRegistrationOpen,
+		TournamentRunning,
+		TournamentCancelled,
+	},
+	TournamentRunning: {
+		TournamentFinished,
+		TournamentCancelled,
+	},
+}
+
+// ParseTournamentState validates tournament state name.
+func ParseTournamentState(name string) (TournamentState, error) {
+	s := TournamentState(name)
+	switch s {
+	case TournamentFinished, TournamentCancelled:
+		return s, nil
+	}
+	if _, ok := tournamentTransitions[s]; !ok {
+		return "", ErrInvalidTournamentState
+	}
+	return s, nil
+}
+
+// CanTransition reports whether tournament in state s may be moved to state to.
+func (s TournamentState) CanTransition(to TournamentState) bool {
+	for _, next := range tournamentTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionError is returned for illegal tournament state transitions.
+type TransitionError struct {
+	From TournamentState
+	To   TournamentState
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("illegal tournament state transition from %s to %s", e.From, e.To)
+}
+
+// Transition moves tournament to a given state.
+func (t *Tournament) Transition(to TournamentState) error {
+	if !t.State.CanTransition(to) {
+		return &TransitionError{From: t.State, To: to}
+	}
+	t.State = to
+	return nil
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTournamentState(t *testing.T) {
+	for _, s := range []TournamentState{
+		TournamentAnnounced,
+		TournamentRegistrationOpen,
+		TournamentRegistrationClosed,
+		TournamentRunning,
+		TournamentFinished,
+		TournamentCancelled,
+	} {
+		state, err := ParseTournamentState(string(s))
+		assert.NoError(t, err)
+		assert.Equal(t, s, state)
+	}
+	_, err := ParseTournamentState("active")
+	assert.Equal(t, ErrInvalidTournamentState, err)
+}
+
+func TestTournamentTransition(t *testing.T) {
+	tests := []struct {
+		from TournamentState
+		to   TournamentState
+		ok   bool
+	}{
+		{from: TournamentAnnounced, to: TournamentRegistrationOpen, ok: true},
+		{from: TournamentAnnounced, to: TournamentRunning, ok: false},
+		{from: TournamentAnnounced, to: TournamentCancelled, ok: true},
+		{from: TournamentRegistrationOpen, to: TournamentRegistrationClosed, ok: true},
+		{from: TournamentRegistrationOpen, to: TournamentRunning, ok: true},
+		{from: TournamentRegistrationOpen, to: TournamentFinished, ok: false},
+		{from: TournamentRegistrationClosed, to: TournamentRegistrationOpen, ok: true},
+		{from: TournamentRegistrationClosed, to: TournamentRunning, ok: true},
+		{from: TournamentRunning, to: TournamentRegistrationOpen, ok: false},
+		{from: TournamentRunning, to: TournamentFinished, ok: true},
+		{from: TournamentRunning, to: TournamentCancelled, ok: true},
+		{from: TournamentFinished, to: TournamentCancelled, ok: false},
+		{from: TournamentFinished, to: TournamentRunning, ok: false},
+		{from: TournamentCancelled, to: TournamentRegistrationOpen, ok: false},
+	}
+
+	for _, test := range tests {
+		tournament := Tournament{State: test.from}
+		err := tournament.Transition(test.to)
+		msg := string(test.from) + " -> " + string(test.to)
+		if test.ok {
+			assert.NoError(t, err, msg)
+			assert.Equal(t, test.to, tournament.State, msg)
+		} else {
+			assert.Equal(t, &TransitionError{From: test.from, To: test.to}, err, msg)
+			assert.Equal(t, test.from, tournament.State, msg)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// TransferOp identifies the kind of operation which caused a player balance
+// change.
+type TransferOp byte
+
+const (
+	TransferFund        TransferOp = 'F'
+	TransferTake        TransferOp = 'T'
+	TransferDeposit     TransferOp = 'D'
+	TransferPrize       TransferOp = 'P'
+	TransferRefund      TransferOp = 'R'
+	TransferRake        TransferOp = 'K'
+	TransferStakeSale   TransferOp = 'S'
+	TransferStakeRefund TransferOp = 'B'
+	TransferOpening     TransferOp = 'O'
+)
+
+var transferOpNames = map[TransferOp]string{
+	TransferFund:        "fund",
+	TransferTake:        "take",
+	TransferDeposit:     "deposit",
+	TransferPrize:       "prize",
+	TransferRefund:      "refund",
+	TransferRake:        "rake",
+	TransferStakeSale:   "stake_sale",
+	TransferStakeRefund: "stake_refund",
+	TransferOpening:     "opening",
+}
+
+// ParseTransferOp converts transfer operation name as returned by String
+// method back to TransferOp value.
+func ParseTransferOp(name string) (TransferOp, error) {
+	for op, n := range transferOpNames {
+		if n == name {
+			return op, nil
+		}
+	}
+	return 0, ErrInvalidTransferOp
+}
+
+func (op TransferOp) String() string {
+	if name, ok := transferOpNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("TransferOp(%q)", byte(op))
+}
+
+func (op TransferOp) MarshalText() ([]byte, error) {
+	if _, ok := transferOpNames[op]; !ok {
+		return nil, ErrInvalidTransferOp
+	}
+	return []byte(op.String()), nil
+}
+
+func (op *TransferOp) UnmarshalText(text []byte) error {
+	v, err := ParseTransferOp(string(text))
+	if err != nil {
+		return err
+	}
+	*op = v
+	return nil
+}
+
+// Transfer is a single ledger posting, it changes the balance of one account
+// by Points. For player accounts PlayerID holds the account owner, for
+// tournament pot accounts TournamentID identifies the pot. TournamentID is
+// zero and BackedPlayerID is empty for transfers not related to any
+// tournament. For tournament deposits and prizes BackedPlayerID holds the
+// tournament player on whose behalf the points were deposited or won, it is
+// equal to PlayerID for the player's own share.
+type Transfer struct {
+	ID             int64       `json:"id"`
+	EntryID        int64       `json:"entryId"`
+	Time           time.Time   `json:"time"`
+	Op             TransferOp  `json:"op"`
+	Account        AccountKind `json:"account"`
+	PlayerID       string      `json:"playerId,omitempty"`
+	Points         int64       `json:"points"`
+	TournamentID   int         `json:"tournamentId,omitempty"`
+	BackedPlayerID string      `json:"backedPlayerId,omitempty"`
+}
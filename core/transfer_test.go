@@ -0,0 +1,52 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTransferOp(t *testing.T) {
+	tests := []struct {
+		name string
+		op   TransferOp
+		err  error
+	}{
+		{name: "fund", op: TransferFund},
+		{name: "take", op: TransferTake},
+		{name: "deposit", op: TransferDeposit},
+		{name: "prize", op: TransferPrize},
+		{name: "refund", op: TransferRefund},
+		{name: "rake", op: TransferRake},
+		{name: "stake_sale", op: TransferStakeSale},
+		{name: "stake_refund", op: TransferStakeRefund},
+		{name: "opening", op: TransferOpening},
+		{name: "", err: ErrInvalidTransferOp},
+		{name: "F", err: ErrInvalidTransferOp},
+	}
+
+	for _, test := range tests {
+		op, err := ParseTransferOp(test.name)
+		assert.Equal(t, test.err, err, test.name)
+		assert.Equal(t, test.op, op, test.name)
+		if err == nil {
+			assert.Equal(t, test.name, op.String(), test.name)
+		}
+	}
+}
+
+func TestTransferJSON(t *testing.T) {
+	data, err := json.Marshal(Transfer{Op: TransferDeposit, Account: AccountPlayer, PlayerID: "P1", Points: -10, TournamentID: 1})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"id": 0,
+		"entryId": 0,
+		"time": "0001-01-01T00:00:00Z",
+		"op": "deposit",
+		"account": "player",
+		"playerId": "P1",
+		"points": -10,
+		"tournamentId": 1
+	}`, string(data))
+}
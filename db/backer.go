@@ -0,0 +1,94 @@
+package db
+
+import (
+	"github.com/20170819lgg/sts/core"
+	"github.com/Masterminds/squirrel"
+)
+
+// backerKey identifies tournament player or winner the backers belong to.
+type backerKey struct {
+	tournamentID int
+	playerID     string
+}
+
+// backersSelect loads backers of given tournament players or winners from
+// the backer table. Backers are returned in their original order keyed by
+// tournament and backed player. Backer rows are locked if forUpdate is set.
+func backersSelect(q squirrel.Queryer, table string, keys []backerKey, forUpdate bool) (map[backerKey][]core.Backer, error) {
+	backers := make(map[backerKey][]core.Backer)
+	if len(keys) == 0 {
+		return backers, nil
+	}
+
+	// players are grouped by tournament to match primary key prefixes
+	var ids []int
+	playerIDs := make(map[int][]string)
+	for _, k := range keys {
+		if _, ok := playerIDs[k.tournamentID]; !ok {
+			ids = append(ids, k.tournamentID)
+		}
+		playerIDs[k.tournamentID] = append(playerIDs[k.tournamentID], k.playerID)
+	}
+	where := make(squirrel.Or, len(ids))
+	for i, id := range ids {
+		where[i] = squirrel.Eq{
+			"tournament_id": id,
+			"player_id":     playerIDs[id],
+		}
+	}
+
+	query := squirrel.
+		Select("tournament_id", "player_id", "backer_id", "points").
+		From(table).
+		Where(where).
+		OrderBy("tournament_id", "player_id", "seq")
+	if forUpdate {
+		query = query.Suffix("FOR UPDATE")
+	}
+
+	rows, err := squirrel.QueryWith(q, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k backerKey
+		var b core.Backer
+		if err := rows.Scan(&k.tournamentID, &k.playerID, &b.PlayerID, &b.Points); err != nil {
+			return nil, err
+		}
+		backers[k] = append(backers[k], b)
+	}
+	return backers, rows.Err()
+}
+
+// backersInsert stores backers of a tournament player or winner into the
+// backer table.
+func backersInsert(e squirrel.Execer, table string, tournamentID int, playerID string, backers []core.Backer) error {
+	if len(backers) == 0 {
+		return nil
+	}
+
+	query := squirrel.
+		Insert(table).
+		Columns("tournament_id", "player_id", "seq", "backer_id", "points")
+	for i, b := range backers {
+		query = query.Values(tournamentID, playerID, i, b.PlayerID, b.Points)
+	}
+	_, err := squirrel.ExecWith(e, query)
+	return err
+}
+
+// backersDelete removes all backers of a tournament player or winner from
+// the backer table.
+func backersDelete(e squirrel.Execer, table string, tournamentID int, playerID string) error {
+	query := squirrel.
+		Delete(table).
+		Where(squirrel.Eq{
+			"tournament_id": tournamentID,
+			"player_id":     playerID,
+		})
+	_, err := squirrel.ExecWith(e, query)
+	return err
+}
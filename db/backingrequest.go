@@ -0,0 +1,172 @@
+package db
+
+import (
+	"github.com/20170819lgg/sts/core"
+	"github.com/Masterminds/squirrel"
+)
+
+// backingRequestSelect is generic function for querying backing requests
+// together with their stakes.
+func backingRequestSelect(q squirrel.Queryer, d queryDecorator) ([]core.BackingRequest, error) {
+	query := d(squirrel.
+		Select("backing_request_id", "tournament_id", "player_id", "status").
+		From("backing_request"))
+
+	rows, err := squirrel.QueryWith(q, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rs []core.BackingRequest
+	idx := make(map[int64]int)
+	for rows.Next() {
+		var r core.BackingRequest
+		if err := rows.Scan(&r.ID, &r.TournamentID, &r.PlayerID, &r.Status); err != nil {
+			return nil, err
+		}
+		idx[r.ID] = len(rs)
+		rs = append(rs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(rs) == 0 {
+		return rs, nil
+	}
+
+	ids := make([]int64, len(rs))
+	for i, r := range rs {
+		ids[i] = r.ID
+	}
+	stakeQuery := squirrel.
+		Select("backing_request_id", "player_id", "points", "accepted").
+		From("backing_request_stake").
+		Where(squirrel.Eq{"backing_request_id": ids}).
+		OrderBy("backing_request_id", "seq")
+
+	stakeRows, err := squirrel.QueryWith(q, stakeQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer stakeRows.Close()
+
+	for stakeRows.Next() {
+		var id int64
+		var s core.BackingStake
+		if err := stakeRows.Scan(&id, &s.PlayerID, &s.Points, &s.Accepted); err != nil {
+			return nil, err
+		}
+		r := &rs[idx[id]]
+		r.Stakes = append(r.Stakes, s)
+	}
+	return rs, stakeRows.Err()
+}
+
+// BackingRequestGet returns backing request by its ID.
+func BackingRequestGet(q squirrel.Queryer, requestID int64) (*core.BackingRequest, error) {
+	return backingRequestGet(q, func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
+		return b.Where("backing_request_id = ?", requestID)
+	})
+}
+
+// BackingRequestGetForUpdate returns backing request by its ID and locks it
+// until the end of the transaction.
+func BackingRequestGetForUpdate(q squirrel.Queryer, requestID int64) (*core.BackingRequest, error) {
+	return backingRequestGet(q, func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
+		return b.Where("backing_request_id = ?", requestID).Suffix("FOR UPDATE")
+	})
+}
+
+func backingRequestGet(q squirrel.Queryer, d queryDecorator) (*core.BackingRequest, error) {
+	rs, err := backingRequestSelect(q, d)
+	switch {
+	case err != nil:
+		return nil, err
+	case len(rs) == 0:
+		return nil, ErrNotFound
+	default:
+		return &rs[0], nil
+	}
+}
+
+// BackingRequestListPending returns pending backing requests proposed by or
+// waiting for consent of a given player.
+func BackingRequestListPending(q squirrel.Queryer, playerID string) ([]core.BackingRequest, error) {
+	return backingRequestSelect(q, func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
+		return b.
+			Where(squirrel.Eq{"status": core.BackingPending}).
+			Where(squirrel.Or{
+				squirrel.Eq{"player_id": playerID},
+				squirrel.Expr("backing_request_id IN (SELECT backing_request_id FROM backing_request_stake WHERE player_id = ?)", playerID),
+			}).
+			OrderBy("backing_request_id")
+	})
+}
+
+// BackingRequestInsert stores new backing request and sets its ID.
+func BackingRequestInsert(e squirrel.Execer, r *core.BackingRequest) error {
+	query := squirrel.
+		Insert("backing_request").
+		SetMap(map[string]interface{}{
+			"tournament_id": r.TournamentID,
+			"player_id":     r.PlayerID,
+			"status":        r.Status,
+		})
+	id, err := insertID(e, query, "backing_request_id")
+	if err != nil {
+		return err
+	}
+
+	stakes := squirrel.
+		Insert("backing_request_stake").
+		Columns("backing_request_id", "seq", "player_id", "points", "accepted")
+	for i, s := range r.Stakes {
+		stakes = stakes.Values(id, i, s.PlayerID, s.Points, s.Accepted)
+	}
+	if _, err := squirrel.ExecWith(e, stakes); err != nil {
+		return err
+	}
+	r.ID = id
+	return nil
+}
+
+// BackingRequestUpdate stores backing request status and stake consents.
+func BackingRequestUpdate(e squirrel.Execer, r *core.BackingRequest) error {
+	query := squirrel.
+		Update("backing_request").
+		Set("status", r.Status).
+		Where("backing_request_id = ?", r.ID)
+	if _, err := squirrel.ExecWith(e, query); err != nil {
+		return err
+	}
+
+	for i, s := range r.Stakes {
+		query := squirrel.
+			Update("backing_request_stake").
+			Set("accepted", s.Accepted).
+			Where(squirrel.Eq{
+				"backing_request_id": r.ID,
+				"seq":                i,
+			})
+		if _, err := squirrel.ExecWith(e, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BackingRequestExpire marks all pending backing requests of a tournament as
+// expired.
+func BackingRequestExpire(e squirrel.Execer, tournamentID int) error {
+	query := squirrel.
+		Update("backing_request").
+		Set("status", core.BackingExpired).
+		Where(squirrel.Eq{
+			"tournament_id": tournamentID,
+			"status":        core.BackingPending,
+		})
+	_, err := squirrel.ExecWith(e, query)
+	return err
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// dialect is a SQL dialect of the database behind SQL store.
+type dialect string
+
+const (
+	dialectMySQL    dialect = "mysql"
+	dialectPostgres dialect = "postgres"
+	dialectSQLite   dialect = "sqlite"
+)
+
+// DSN prefixes selecting SQL dialect, DSN without a scheme is a MySQL one.
+const (
+	mysqlScheme      = "mysql://"
+	postgresScheme   = "postgres://"
+	postgresqlScheme = "postgresql://"
+	sqliteScheme     = "sqlite://"
+)
+
+// sqliteTimeFormat is the format of SQLite timestamps. SQLite compares
+// timestamps as strings, so all of them are stored in UTC with fixed
+// precision.
+const sqliteTimeFormat = "2006-01-02 15:04:05.000"
+
+// dialectOf returns SQL dialect selected by DSN scheme.
+func dialectOf(dsn string) dialect {
+	switch {
+	case strings.HasPrefix(dsn, postgresScheme), strings.HasPrefix(dsn, postgresqlScheme):
+		return dialectPostgres
+	case strings.HasPrefix(dsn, sqliteScheme):
+		return dialectSQLite
+	default:
+		return dialectMySQL
+	}
+}
+
+// sqlRunner is implemented by both *sql.DB and *sql.Tx.
+type sqlRunner interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// runner executes queries built with question mark placeholders in the SQL
+// dialect of the database. Queries are run with the runner context, so they
+// are cancelled together with the request. It implements squirrel.Queryer and
+// squirrel.Execer as well as their context aware variants, so it can be
+// passed to all query functions.
+type runner struct {
+	ctx     context.Context
+	r       sqlRunner
+	dialect dialect
+}
+
+func newRunner(ctx context.Context, r sqlRunner, d dialect) *runner {
+	return &runner{
+		ctx:     ctx,
+		r:       r,
+		dialect: d,
+	}
+}
+
+// rebind converts query and its arguments to the dialect. SQLite does not
+// support row locks, FOR UPDATE clauses are dropped as its transactions lock
+// the whole database instead.
+func (r *runner) rebind(query string, args []interface{}) (string, []interface{}, error) {
+	switch r.dialect {
+	case dialectPostgres:
+		query, err := squirrel.Dollar.ReplacePlaceholders(query)
+		return query, args, err
+	case dialectSQLite:
+		bound := make([]interface{}, len(args))
+		for i, arg := range args {
+			if t, ok := arg.(time.Time); ok {
+				arg = t.UTC().Format(sqliteTimeFormat)
+			}
+			bound[i] = arg
+		}
+		return strings.TrimSuffix(query, " FOR UPDATE"), bound, nil
+	default:
+		return query, args, nil
+	}
+}
+
+func (r *runner) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return r.ExecContext(r.ctx, query, args...)
+}
+
+func (r *runner) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return r.QueryContext(r.ctx, query, args...)
+}
+
+func (r *runner) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	query, args, err := r.rebind(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return r.r.ExecContext(ctx, query, args...)
+}
+
+func (r *runner) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	query, args, err := r.rebind(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return r.r.QueryContext(ctx, query, args...)
+}
+
+// insertID executes insert query and returns value generated for the auto
+// increment column. PostgreSQL does not report inserted IDs, so the column is
+// returned by the query instead.
+func insertID(e squirrel.Execer, query squirrel.InsertBuilder, column string) (int64, error) {
+	if r, ok := e.(*runner); ok && r.dialect == dialectPostgres {
+		rows, err := squirrel.QueryContextWith(r.ctx, r, query.Suffix("RETURNING "+column))
+		if err != nil {
+			return 0, err
+		}
+		defer rows.Close()
+
+		var id int64
+		if !rows.Next() {
+			if err := rows.Err(); err != nil {
+				return 0, err
+			}
+			return 0, sql.ErrNoRows
+		}
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		return id, rows.Close()
+	}
+
+	res, err := squirrel.ExecWith(e, query)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// isDuplicate checks if error is caused by unique constraint violation.
+func isDuplicate(err error) bool {
+	switch e := err.(type) {
+	case *mysql.MySQLError:
+		return e.Number == 1062
+	case *pq.Error:
+		return e.Code == "23505"
+	case sqlite3.Error:
+		return e.ExtendedCode == sqlite3.ErrConstraintUnique || e.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	default:
+		return false
+	}
+}
+
+// isRetryable checks if error is caused by deadlock, lock wait timeout or
+// serialization failure, after which the whole transaction may succeed when
+// run again.
+func isRetryable(err error) bool {
+	switch e := err.(type) {
+	case *mysql.MySQLError:
+		return e.Number == 1213 || e.Number == 1205
+	case *pq.Error:
+		return e.Code == "40001" || e.Code == "40P01"
+	case sqlite3.Error:
+		return e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked
+	default:
+		return false
+	}
+}
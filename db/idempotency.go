@@ -0,0 +1,69 @@
+package db
+
+import (
+	"github.com/20170819lgg/sts/core"
+	"github.com/Masterminds/squirrel"
+)
+
+func IdempotencyKeyGet(q squirrel.Queryer, client, key string) (*core.IdempotencyKey, error) {
+	query := squirrel.
+		Select("client", "idempotency_key", "fingerprint", "status", "body").
+		From("idempotency_key").
+		Where(squirrel.Eq{
+			"client":          client,
+			"idempotency_key": key,
+		})
+
+	rows, err := squirrel.QueryWith(q, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrNotFound
+	}
+	var k core.IdempotencyKey
+	if err := rows.Scan(&k.Client, &k.Key, &k.Fingerprint, &k.Status, &k.Body); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// IdempotencyKeyInsert reserves idempotency key for the request. Insertion
+// blocks while other transaction holding the same key is in progress.
+func IdempotencyKeyInsert(e squirrel.Execer, k *core.IdempotencyKey) error {
+	query := squirrel.
+		Insert("idempotency_key").
+		SetMap(map[string]interface{}{
+			"client":          k.Client,
+			"idempotency_key": k.Key,
+			"fingerprint":     k.Fingerprint,
+			"status":          k.Status,
+			"body":            k.Body,
+		})
+	_, err := squirrel.ExecWith(e, query)
+	if isDuplicate(err) {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+// IdempotencyKeyUpdate stores response of the request.
+func IdempotencyKeyUpdate(e squirrel.Execer, k *core.IdempotencyKey) error {
+	query := squirrel.
+		Update("idempotency_key").
+		SetMap(map[string]interface{}{
+			"status": k.Status,
+			"body":   k.Body,
+		}).
+		Where(squirrel.Eq{
+			"client":          k.Client,
+			"idempotency_key": k.Key,
+		})
+	_, err := squirrel.ExecWith(e, query)
+	return err
+}
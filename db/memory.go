@@ -0,0 +1,725 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/20170819lgg/sts/core"
+)
+
+// memoryData holds all records of the in-memory store.
+type memoryData struct {
+	players         map[string]core.Player
+	tournaments     map[int]core.Tournament
+	tournPlayers    map[backerKey]core.TournPlayer
+	winners         map[backerKey]core.TournWinner
+	transfers       []core.Transfer // append only, ordered by ID
+	lastEntryID     int64
+	backingRequests map[int64]core.BackingRequest
+	lastRequestID   int64
+	stakeOffers     map[int64]core.StakeOffer
+	lastOfferID     int64
+	idempotencyKeys map[idempotencyKeyID]core.IdempotencyKey
+}
+
+func newMemoryData() *memoryData {
+	return &memoryData{
+		players:         make(map[string]core.Player),
+		tournaments:     make(map[int]core.Tournament),
+		tournPlayers:    make(map[backerKey]core.TournPlayer),
+		winners:         make(map[backerKey]core.TournWinner),
+		backingRequests: make(map[int64]core.BackingRequest),
+		stakeOffers:     make(map[int64]core.StakeOffer),
+		idempotencyKeys: make(map[idempotencyKeyID]core.IdempotencyKey),
+	}
+}
+
+// clone returns copy of the data which can be modified independently. Map
+// values are copied on reads and writes, so copying maps is enough. Transfer
+// log is only appended to and transactions are serialized, therefore its
+// backing array can be shared.
+func (d *memoryData) clone() *memoryData {
+	c := *d
+	c.players = make(map[string]core.Player, len(d.players))
+	for k, v := range d.players {
+		c.players[k] = v
+	}
+	c.tournaments = make(map[int]core.Tournament, len(d.tournaments))
+	for k, v := range d.tournaments {
+		c.tournaments[k] = v
+	}
+	c.tournPlayers = make(map[backerKey]core.TournPlayer, len(d.tournPlayers))
+	for k, v := range d.tournPlayers {
+		c.tournPlayers[k] = v
+	}
+	c.winners = make(map[backerKey]core.TournWinner, len(d.winners))
+	for k, v := range d.winners {
+		c.winners[k] = v
+	}
+	c.backingRequests = make(map[int64]core.BackingRequest, len(d.backingRequests))
+	for k, v := range d.backingRequests {
+		c.backingRequests[k] = v
+	}
+	c.stakeOffers = make(map[int64]core.StakeOffer, len(d.stakeOffers))
+	for k, v := range d.stakeOffers {
+		c.stakeOffers[k] = v
+	}
+	c.idempotencyKeys = make(map[idempotencyKeyID]core.IdempotencyKey, len(d.idempotencyKeys))
+	for k, v := range d.idempotencyKeys {
+		c.idempotencyKeys[k] = v
+	}
+	c.transfers = d.transfers[:len(d.transfers):len(d.transfers)]
+	return &c
+}
+
+func copyBackers(bs []core.Backer) []core.Backer {
+	if bs == nil {
+		return nil
+	}
+	return append([]core.Backer{}, bs...)
+}
+
+func copyTournament(t core.Tournament) core.Tournament {
+	if t.Payout != nil {
+		p := *t.Payout
+		p.Percentages = append([]int64(nil), p.Percentages...)
+		t.Payout = &p
+	}
+	return t
+}
+
+func copyBackingRequest(r core.BackingRequest) core.BackingRequest {
+	r.Stakes = append([]core.BackingStake(nil), r.Stakes...)
+	return r
+}
+
+// MemoryStore is a Store keeping all data in memory. Transactions are
+// serialized, each of them works on its own copy of the data which replaces
+// the stored one on commit.
+type MemoryStore struct {
+	lock chan struct{} // held by the running transaction
+	data *memoryData
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		lock: make(chan struct{}, 1),
+		data: newMemoryData(),
+	}
+}
+
+// acquire waits for the store lock until the context is done.
+func (s *MemoryStore) acquire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case s.lock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *MemoryStore) release() { <-s.lock }
+
+// Begin starts a new transaction, it blocks until the running one ends.
+func (s *MemoryStore) Begin(ctx context.Context) (Tx, error) {
+	if err := s.acquire(ctx); err != nil {
+		return nil, err
+	}
+	return &memoryTx{
+		ctx:   ctx,
+		store: s,
+		data:  s.data.clone(),
+	}, nil
+}
+
+// In-memory store has no schema to migrate.
+func (s *MemoryStore) MigrateUp(ctx context.Context) ([]int, error) { return nil, nil }
+func (s *MemoryStore) MigrateDown(ctx context.Context) (int, error) { return 0, ErrNoMigration }
+func (s *MemoryStore) MigrateStatus(ctx context.Context) ([]MigrationStatus, error) {
+	return nil, nil
+}
+func (s *MemoryStore) Close() error { return nil }
+
+func (s *MemoryStore) Reset(ctx context.Context) error {
+	if err := s.acquire(ctx); err != nil {
+		return err
+	}
+	defer s.release()
+	s.data = newMemoryData()
+	return nil
+}
+
+// memoryTx is a transaction of the in-memory store. Locking methods need no
+// extra locks as the whole store is locked by the transaction. Changes are
+// discarded on commit if the context is already done. Once the context is
+// done or the transaction ended, its methods fail with the errors returned by
+// database/sql transactions.
+type memoryTx struct {
+	ctx   context.Context
+	store *MemoryStore
+	data  *memoryData
+	done  bool
+}
+
+// check returns the context error or sql.ErrTxDone if the transaction can
+// not be used any more.
+func (t *memoryTx) check() error {
+	if err := t.ctx.Err(); err != nil {
+		return err
+	}
+	if t.done {
+		return sql.ErrTxDone
+	}
+	return nil
+}
+
+func (t *memoryTx) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	defer t.store.release()
+	if err := t.ctx.Err(); err != nil {
+		return err
+	}
+	t.store.data = t.data
+	return nil
+}
+
+func (t *memoryTx) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	t.store.release()
+	return nil
+}
+
+func (t *memoryTx) PlayerList() ([]core.Player, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	var ps []core.Player
+	for _, p := range t.data.players {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].PlayerID < ps[j].PlayerID })
+	return ps, nil
+}
+
+func (t *memoryTx) PlayerGet(playerID string) (*core.Player, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	p, ok := t.data.players[playerID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &p, nil
+}
+
+func (t *memoryTx) PlayerGetForUpdate(playerID string) (*core.Player, error) {
+	return t.PlayerGet(playerID)
+}
+
+func (t *memoryTx) PlayerSelectForUpdate(playerIDs []string) (map[string]*core.Player, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	m := make(map[string]*core.Player)
+	for _, id := range playerIDs {
+		if p, ok := t.data.players[id]; ok {
+			m[id] = &p
+		}
+	}
+	return m, nil
+}
+
+func (t *memoryTx) PlayerInsert(player *core.Player) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	if _, ok := t.data.players[player.PlayerID]; ok {
+		return ErrAlreadyExists
+	}
+	t.data.players[player.PlayerID] = *player
+	return nil
+}
+
+func (t *memoryTx) PlayerUpdate(player *core.Player) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	if _, ok := t.data.players[player.PlayerID]; ok {
+		t.data.players[player.PlayerID] = *player
+	}
+	return nil
+}
+
+func (t *memoryTx) TournamentList() ([]core.Tournament, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	var ts []core.Tournament
+	for _, tournament := range t.data.tournaments {
+		ts = append(ts, copyTournament(tournament))
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i].ID < ts[j].ID })
+	return ts, nil
+}
+
+func (t *memoryTx) TournamentGet(tournamentID int) (*core.Tournament, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	tournament, ok := t.data.tournaments[tournamentID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	tournament = copyTournament(tournament)
+	return &tournament, nil
+}
+
+func (t *memoryTx) TournamentGetForUpdate(tournamentID int) (*core.Tournament, error) {
+	return t.TournamentGet(tournamentID)
+}
+
+func (t *memoryTx) TournamentInsert(tournament *core.Tournament) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	if _, ok := t.data.tournaments[tournament.ID]; ok {
+		return ErrAlreadyExists
+	}
+	t.data.tournaments[tournament.ID] = copyTournament(*tournament)
+	return nil
+}
+
+func (t *memoryTx) TournamentUpdate(tournament *core.Tournament) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	if _, ok := t.data.tournaments[tournament.ID]; ok {
+		t.data.tournaments[tournament.ID] = copyTournament(*tournament)
+	}
+	return nil
+}
+
+func (t *memoryTx) TournPlayerGet(tournamentID int, playerID string) (*core.TournPlayer, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	tp, ok := t.data.tournPlayers[backerKey{tournamentID, playerID}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	tp.Backers = copyBackers(tp.Backers)
+	return &tp, nil
+}
+
+func (t *memoryTx) TournPlayerGetForUpdate(tournamentID int, playerID string) (*core.TournPlayer, error) {
+	return t.TournPlayerGet(tournamentID, playerID)
+}
+
+func (t *memoryTx) TournPlayerList(tournamentID int) ([]core.TournPlayer, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	var tps []core.TournPlayer
+	for k, tp := range t.data.tournPlayers {
+		if k.tournamentID == tournamentID {
+			tp.Backers = copyBackers(tp.Backers)
+			tps = append(tps, tp)
+		}
+	}
+	sort.Slice(tps, func(i, j int) bool { return tps[i].PlayerID < tps[j].PlayerID })
+	return tps, nil
+}
+
+func (t *memoryTx) TournPlayerInsert(tp *core.TournPlayer) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	k := backerKey{tp.TournamentID, tp.PlayerID}
+	if _, ok := t.data.tournPlayers[k]; ok {
+		return ErrAlreadyExists
+	}
+	c := *tp
+	c.Backers = copyBackers(tp.Backers)
+	t.data.tournPlayers[k] = c
+	return nil
+}
+
+func (t *memoryTx) TournPlayerUpdate(tp *core.TournPlayer) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	k := backerKey{tp.TournamentID, tp.PlayerID}
+	if _, ok := t.data.tournPlayers[k]; ok {
+		c := *tp
+		c.Backers = copyBackers(tp.Backers)
+		t.data.tournPlayers[k] = c
+	}
+	return nil
+}
+
+// TournPlayerDelete removes tournament player together with its stake offer.
+func (t *memoryTx) TournPlayerDelete(tp *core.TournPlayer) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	delete(t.data.tournPlayers, backerKey{tp.TournamentID, tp.PlayerID})
+	for id, o := range t.data.stakeOffers {
+		if o.TournamentID == tp.TournamentID && o.PlayerID == tp.PlayerID {
+			delete(t.data.stakeOffers, id)
+		}
+	}
+	return nil
+}
+
+func (t *memoryTx) TournamentWinnerList() ([]core.TournWinner, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	var tws []core.TournWinner
+	for _, tw := range t.data.winners {
+		tw.Backers = copyBackers(tw.Backers)
+		tws = append(tws, tw)
+	}
+	sort.Slice(tws, func(i, j int) bool {
+		if tws[i].TournamentID != tws[j].TournamentID {
+			return tws[i].TournamentID < tws[j].TournamentID
+		}
+		return tws[i].PlayerID < tws[j].PlayerID
+	})
+	return tws, nil
+}
+
+func (t *memoryTx) TournamentWinnerInsert(tw *core.TournWinner) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	k := backerKey{tw.TournamentID, tw.PlayerID}
+	if _, ok := t.data.winners[k]; ok {
+		return ErrAlreadyExists
+	}
+	c := *tw
+	c.Backers = copyBackers(tw.Backers)
+	t.data.winners[k] = c
+	return nil
+}
+
+// LedgerEntryInsert validates and stores ledger entry transfers. Entry and
+// transfer IDs are not updated.
+func (t *memoryTx) LedgerEntryInsert(entry *core.LedgerEntry) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	if err := entry.Validate(); err != nil {
+		return err
+	}
+	t.data.lastEntryID++
+	now := time.Now().UTC()
+	for _, tr := range entry.Transfers {
+		tr.ID = int64(len(t.data.transfers)) + 1
+		tr.EntryID = t.data.lastEntryID
+		tr.Time = now
+		t.data.transfers = append(t.data.transfers, tr)
+	}
+	return nil
+}
+
+func (t *memoryTx) LedgerSumsGet() (*core.LedgerSums, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	sums := &core.LedgerSums{
+		Players:    make(map[string]int64),
+		Pots:       make(map[int]int64),
+		Unbalanced: make(map[int64]int64),
+		Prizes:     make(map[core.PrizeKey]int64),
+	}
+	entries := make(map[int64]int64)
+	for _, tr := range t.data.transfers {
+		switch tr.Account {
+		case core.AccountPlayer:
+			sums.Players[tr.PlayerID] += tr.Points
+		case core.AccountPot:
+			sums.Pots[tr.TournamentID] += tr.Points
+		case core.AccountHouse:
+			sums.House += tr.Points
+		default:
+			return nil, core.ErrInvalidAccountKind
+		}
+		entries[tr.EntryID] += tr.Points
+		if tr.Account == core.AccountPlayer && tr.Op == core.TransferPrize {
+			sums.Prizes[core.PrizeKey{
+				TournamentID: tr.TournamentID,
+				PlayerID:     tr.BackedPlayerID,
+				BackerID:     tr.PlayerID,
+			}] += tr.Points
+		}
+	}
+	for entryID, sum := range entries {
+		if sum != 0 {
+			sums.Unbalanced[entryID] = sum
+		}
+	}
+	return sums, nil
+}
+
+func (t *memoryTx) TransferLogSelect(f TransferFilter) ([]core.Transfer, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	ops := make(map[core.TransferOp]bool)
+	for _, op := range f.Ops {
+		ops[op] = true
+	}
+
+	var ts []core.Transfer
+	for i := len(t.data.transfers) - 1; i >= 0; i-- {
+		tr := t.data.transfers[i]
+		switch {
+		case f.Limit != 0 && uint64(len(ts)) >= f.Limit:
+			return ts, nil
+		case f.PlayerID != "" && tr.PlayerID != f.PlayerID,
+			len(ops) > 0 && !ops[tr.Op],
+			f.TournamentID != 0 && tr.TournamentID != f.TournamentID,
+			!f.From.IsZero() && tr.Time.Before(f.From),
+			!f.To.IsZero() && !tr.Time.Before(f.To),
+			f.BeforeID != 0 && tr.ID >= f.BeforeID:
+			continue
+		}
+		ts = append(ts, tr)
+	}
+	return ts, nil
+}
+
+func (t *memoryTx) BackingList(playerID string, from, to time.Time) ([]core.Backing, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	var bs []core.Backing
+	for k, tp := range t.data.tournPlayers {
+		backer := false
+		for _, b := range tp.Backers {
+			backer = backer || b.PlayerID == playerID
+		}
+		if !backer {
+			continue
+		}
+
+		b := core.Backing{
+			TournamentID: k.tournamentID,
+			PlayerID:     k.playerID,
+			State:        t.data.tournaments[k.tournamentID].State,
+		}
+		var entered time.Time
+		for _, tr := range t.data.transfers {
+			if tr.TournamentID != k.tournamentID || tr.BackedPlayerID != k.playerID {
+				continue
+			}
+			// transfers are ordered, so the latest deposit is the entry
+			// time
+			if tr.Op == core.TransferDeposit {
+				entered = tr.Time
+			}
+			switch tr.Op {
+			case core.TransferDeposit, core.TransferRefund, core.TransferStakeSale, core.TransferStakeRefund:
+				if tr.Account == core.AccountPlayer && tr.PlayerID == playerID {
+					b.Stake -= tr.Points
+				}
+			}
+		}
+		if !from.IsZero() && entered.Before(from) || !to.IsZero() && (entered.IsZero() || !entered.Before(to)) {
+			continue
+		}
+		for _, wb := range t.data.winners[k].Backers {
+			if wb.PlayerID == playerID {
+				b.Payout += wb.Points
+			}
+		}
+		b.Net = b.Payout - b.Stake
+		bs = append(bs, b)
+	}
+	sort.Slice(bs, func(i, j int) bool {
+		if bs[i].TournamentID != bs[j].TournamentID {
+			return bs[i].TournamentID < bs[j].TournamentID
+		}
+		return bs[i].PlayerID < bs[j].PlayerID
+	})
+	return bs, nil
+}
+
+func (t *memoryTx) BackingRequestGet(requestID int64) (*core.BackingRequest, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	r, ok := t.data.backingRequests[requestID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	r = copyBackingRequest(r)
+	return &r, nil
+}
+
+func (t *memoryTx) BackingRequestGetForUpdate(requestID int64) (*core.BackingRequest, error) {
+	return t.BackingRequestGet(requestID)
+}
+
+func (t *memoryTx) BackingRequestListPending(playerID string) ([]core.BackingRequest, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	var rs []core.BackingRequest
+	for _, r := range t.data.backingRequests {
+		if r.Status != core.BackingPending {
+			continue
+		}
+		involved := r.PlayerID == playerID
+		for _, s := range r.Stakes {
+			involved = involved || s.PlayerID == playerID
+		}
+		if involved {
+			rs = append(rs, copyBackingRequest(r))
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].ID < rs[j].ID })
+	return rs, nil
+}
+
+func (t *memoryTx) BackingRequestInsert(r *core.BackingRequest) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	t.data.lastRequestID++
+	r.ID = t.data.lastRequestID
+	t.data.backingRequests[r.ID] = copyBackingRequest(*r)
+	return nil
+}
+
+func (t *memoryTx) BackingRequestUpdate(r *core.BackingRequest) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	if _, ok := t.data.backingRequests[r.ID]; ok {
+		t.data.backingRequests[r.ID] = copyBackingRequest(*r)
+	}
+	return nil
+}
+
+func (t *memoryTx) BackingRequestExpire(tournamentID int) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	for id, r := range t.data.backingRequests {
+		if r.TournamentID == tournamentID && r.Status == core.BackingPending {
+			r.Status = core.BackingExpired
+			t.data.backingRequests[id] = r
+		}
+	}
+	return nil
+}
+
+func (t *memoryTx) StakeOfferList(tournamentID int) ([]core.StakeOffer, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	var offers []core.StakeOffer
+	for _, o := range t.data.stakeOffers {
+		if o.TournamentID == tournamentID && o.Available > 0 {
+			offers = append(offers, o)
+		}
+	}
+	sort.Slice(offers, func(i, j int) bool { return offers[i].ID < offers[j].ID })
+	return offers, nil
+}
+
+func (t *memoryTx) StakeOfferGet(offerID int64) (*core.StakeOffer, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	o, ok := t.data.stakeOffers[offerID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &o, nil
+}
+
+func (t *memoryTx) StakeOfferGetForUpdate(offerID int64) (*core.StakeOffer, error) {
+	return t.StakeOfferGet(offerID)
+}
+
+// StakeOfferInsert stores new stake offer and sets its ID. Only one offer per
+// tournament player may exist.
+func (t *memoryTx) StakeOfferInsert(o *core.StakeOffer) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	for _, other := range t.data.stakeOffers {
+		if other.TournamentID == o.TournamentID && other.PlayerID == o.PlayerID {
+			return ErrAlreadyExists
+		}
+	}
+	t.data.lastOfferID++
+	o.ID = t.data.lastOfferID
+	t.data.stakeOffers[o.ID] = *o
+	return nil
+}
+
+func (t *memoryTx) StakeOfferUpdate(o *core.StakeOffer) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	if _, ok := t.data.stakeOffers[o.ID]; ok {
+		t.data.stakeOffers[o.ID] = *o
+	}
+	return nil
+}
+
+// idempotencyKeyID identifies idempotency key of a client.
+type idempotencyKeyID struct {
+	client string
+	key    string
+}
+
+func (t *memoryTx) IdempotencyKeyGet(client, key string) (*core.IdempotencyKey, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	k, ok := t.data.idempotencyKeys[idempotencyKeyID{client, key}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &k, nil
+}
+
+func (t *memoryTx) IdempotencyKeyInsert(k *core.IdempotencyKey) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	id := idempotencyKeyID{k.Client, k.Key}
+	if _, ok := t.data.idempotencyKeys[id]; ok {
+		return ErrAlreadyExists
+	}
+	t.data.idempotencyKeys[id] = *k
+	return nil
+}
+
+func (t *memoryTx) IdempotencyKeyUpdate(k *core.IdempotencyKey) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	id := idempotencyKeyID{k.Client, k.Key}
+	if _, ok := t.data.idempotencyKeys[id]; ok {
+		t.data.idempotencyKeys[id] = *k
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// migrationLock is a name of the advisory lock serializing schema migrations
+// of concurrently starting instances. PostgreSQL advisory locks are
+// identified by numbers, migrationLockID is used there.
+const (
+	migrationLock   = "sts.schema_migrations"
+	migrationLockID = 7400290
+)
+
+// migrationLockTimeout is the number of seconds to wait for the migration
+// lock.
+const migrationLockTimeout = 60
+
+// sqliteMigrationLock serializes migrations of SQLite databases, which have
+// no advisory locks. SQLite database is used by a single process only.
+var sqliteMigrationLock sync.Mutex
+
+var ErrMigrationLocked = errors.New("db: timeout waiting for migration lock")
+var ErrNoMigration = errors.New("db: no migration to revert")
+
+// migration is a single versioned schema change. MySQL commits DDL
+// statements implicitly, therefore migrations are not run in a transaction
+// and should be written so that an interrupted migration may be repeated.
+type migration struct {
+	version int
+	name    string
+	up      migrationFunc
+	down    migrationFunc
+}
+
+// migrationFunc applies or reverts a migration step.
+type migrationFunc func(context.Context, *sql.DB) error
+
+// execStmts returns migration step executing given statements in order.
+func execStmts(stmts ...string) migrationFunc {
+	return func(ctx context.Context, db *sql.DB) error {
+		for _, stmt := range stmts {
+			if _, err := db.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// migrationSteps returns migration step running given steps in order.
+func migrationSteps(steps ...migrationFunc) migrationFunc {
+	return func(ctx context.Context, db *sql.DB) error {
+		for _, step := range steps {
+			if err := step(ctx, db); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// tableExists checks if given table exists in the current database.
+func tableExists(ctx context.Context, db *sql.DB, d dialect, table string) (bool, error) {
+	var query string
+	switch d {
+	case dialectPostgres:
+		query = `SELECT COUNT(*) FROM information_schema.tables
+			WHERE table_schema = current_schema() AND table_name = $1`
+	case dialectSQLite:
+		query = `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
+	default:
+		query = `SELECT COUNT(*) FROM information_schema.TABLES
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?`
+	}
+	var n int
+	err := db.QueryRowContext(ctx, query, table).Scan(&n)
+	return n > 0, err
+}
+
+// columnExists checks if given column exists in the current database table.
+func columnExists(ctx context.Context, db *sql.DB, d dialect, table, column string) (bool, error) {
+	var query string
+	switch d {
+	case dialectPostgres:
+		query = `SELECT COUNT(*) FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2`
+	case dialectSQLite:
+		query = `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`
+	default:
+		query = `SELECT COUNT(*) FROM information_schema.COLUMNS
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`
+	}
+	var n int
+	err := db.QueryRowContext(ctx, query, table, column).Scan(&n)
+	return n > 0, err
+}
+
+// addColumn returns migration step adding a column of given definition
+// unless the table has it already. The column is looked up first as
+// ADD COLUMN IF NOT EXISTS is not supported by MySQL and SQLite.
+func addColumn(d dialect, table, column, definition string) migrationFunc {
+	return func(ctx context.Context, db *sql.DB) error {
+		exists, err := columnExists(ctx, db, d, table, column)
+		if err != nil || exists {
+			return err
+		}
+		_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+		return err
+	}
+}
+
+// dropColumn returns migration step dropping a column if the table has it.
+func dropColumn(d dialect, table, column string) migrationFunc {
+	return func(ctx context.Context, db *sql.DB) error {
+		exists, err := columnExists(ctx, db, d, table, column)
+		if err != nil || !exists {
+			return err
+		}
+		_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column))
+		return err
+	}
+}
+
+// MigrationStatus describes a known schema migration, AppliedAt is nil if
+// the migration is not applied.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt"`
+}
+
+// migrationsOf returns schema migrations of a dialect.
+func migrationsOf(d dialect) []migration {
+	switch d {
+	case dialectPostgres:
+		return postgresMigrations
+	case dialectSQLite:
+		return sqliteMigrations
+	default:
+		return mysqlMigrations
+	}
+}
+
+// lockMigrations acquires the migration lock on a connection, it waits for
+// the lock at most migrationLockTimeout seconds.
+func lockMigrations(ctx context.Context, conn *sql.Conn, d dialect) error {
+	if d == dialectSQLite {
+		sqliteMigrationLock.Lock()
+		return nil
+	}
+	if d == dialectPostgres {
+		for i := 0; i < migrationLockTimeout*10; i++ {
+			var locked bool
+			if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", migrationLockID).Scan(&locked); err != nil {
+				return err
+			}
+			if locked {
+				return nil
+			}
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return ErrMigrationLocked
+	}
+
+	var locked sql.NullInt64
+	err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLock, migrationLockTimeout).Scan(&locked)
+	switch {
+	case err != nil:
+		return err
+	case !locked.Valid || locked.Int64 != 1:
+		return ErrMigrationLocked
+	default:
+		return nil
+	}
+}
+
+// unlockMigrations releases the migration lock held by the connection.
+func unlockMigrations(ctx context.Context, conn *sql.Conn, d dialect) error {
+	var err error
+	switch d {
+	case dialectSQLite:
+		sqliteMigrationLock.Unlock()
+	case dialectPostgres:
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID)
+	default:
+		_, err = conn.ExecContext(ctx, "DO RELEASE_LOCK(?)", migrationLock)
+	}
+	return err
+}
+
+// withMigrationLock runs body while holding the migration lock. Advisory
+// locks belong to a session, so the lock is held on a dedicated connection.
+func withMigrationLock(ctx context.Context, db *sql.DB, d dialect, body func() error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := lockMigrations(ctx, conn, d); err != nil {
+		return err
+	}
+	// lock is released even if the context is cancelled
+	defer unlockMigrations(context.Background(), conn, d)
+
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		tstamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (version)
+	)`); err != nil {
+		return err
+	}
+	return body()
+}
+
+// appliedMigrations returns application times of applied migrations keyed by
+// their versions.
+func appliedMigrations(q squirrel.Queryer) (map[int]time.Time, error) {
+	query := squirrel.
+		Select("version", "tstamp").
+		From("schema_migrations")
+
+	rows, err := squirrel.QueryWith(q, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var tstamp time.Time
+		if err := rows.Scan(&version, &tstamp); err != nil {
+			return nil, err
+		}
+		applied[version] = tstamp
+	}
+	return applied, rows.Err()
+}
+
+// migrateUp applies all pending migrations in version order and returns
+// versions of the applied ones.
+func migrateUp(ctx context.Context, db *sql.DB, d dialect) ([]int, error) {
+	var done []int
+	r := newRunner(ctx, db, d)
+	err := withMigrationLock(ctx, db, d, func() error {
+		applied, err := appliedMigrations(r)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrationsOf(d) {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+			if err := m.up(ctx, db); err != nil {
+				return fmt.Errorf("db: migration %d %s: %v", m.version, m.name, err)
+			}
+			query := squirrel.
+				Insert("schema_migrations").
+				SetMap(map[string]interface{}{
+					"version": m.version,
+					"name":    m.name,
+				})
+			if _, err := squirrel.ExecWith(r, query); err != nil {
+				return err
+			}
+			done = append(done, m.version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// migrateDown reverts the latest applied migration and returns its version.
+// ErrNoMigration is returned if there is nothing to revert.
+func migrateDown(ctx context.Context, db *sql.DB, d dialect) (int, error) {
+	var version int
+	r := newRunner(ctx, db, d)
+	err := withMigrationLock(ctx, db, d, func() error {
+		applied, err := appliedMigrations(r)
+		if err != nil {
+			return err
+		}
+		migrations := migrationsOf(d)
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+			if err := m.down(ctx, db); err != nil {
+				return fmt.Errorf("db: reverting migration %d %s: %v", m.version, m.name, err)
+			}
+			query := squirrel.
+				Delete("schema_migrations").
+				Where("version = ?", m.version)
+			if _, err := squirrel.ExecWith(r, query); err != nil {
+				return err
+			}
+			version = m.version
+			return nil
+		}
+		return ErrNoMigration
+	})
+	return version, err
+}
+
+// migrateStatus lists all known migrations and their application times.
+func migrateStatus(ctx context.Context, db *sql.DB, d dialect) ([]MigrationStatus, error) {
+	var ss []MigrationStatus
+	err := withMigrationLock(ctx, db, d, func() error {
+		applied, err := appliedMigrations(newRunner(ctx, db, d))
+		if err != nil {
+			return err
+		}
+		for _, m := range migrationsOf(d) {
+			s := MigrationStatus{Version: m.version, Name: m.name}
+			if tstamp, ok := applied[m.version]; ok {
+				s.AppliedAt = &tstamp
+			}
+			ss = append(ss, s)
+		}
+		return nil
+	})
+	return ss, err
+}
@@ -0,0 +1,298 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/20170819lgg/sts/core"
+	"github.com/Masterminds/squirrel"
+)
+
+// backerTables lists tables of tournament players and winners which stored
+// their backers as JSON in legacy data columns.
+var backerTables = []string{"tournament_player", "tournament_winner"}
+
+// ledgerOpeningUp opens the ledger of a database created before it. Pots of
+// active tournaments are set to entry deposits of their players, then one
+// entry is posted per non-zero player balance and tournament pot with the
+// house account as the counterparty. Nothing is done if the ledger has any
+// entries, so the migration may be repeated.
+func ledgerOpeningUp(d dialect) migrationFunc {
+	return func(ctx context.Context, db *sql.DB) error {
+		hasActive, err := columnExists(ctx, db, d, "tournament", "active")
+		if err != nil {
+			return err
+		}
+
+		return sqlTransaction(ctx, db, func(tx *sql.Tx) error {
+			var n int
+			if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM ledger_entry`).Scan(&n); err != nil {
+				return err
+			}
+			if n > 0 {
+				return nil
+			}
+			if hasActive {
+				_, err := tx.ExecContext(ctx, `UPDATE tournament SET pot = (
+					SELECT COALESCE(SUM(fee), 0) FROM tournament_player
+					WHERE tournament_player.tournament_id = tournament.tournament_id
+				) WHERE active`)
+				if err != nil {
+					return err
+				}
+			}
+
+			r := newRunner(ctx, tx, d)
+			var entries []*core.LedgerEntry
+			balances := squirrel.
+				Select("player_id", "balance").
+				From("player").
+				Where("balance <> 0").
+				OrderBy("player_id")
+			err := queryEach(r, balances, func(rows *sql.Rows) error {
+				var playerID string
+				var balance int64
+				if err := rows.Scan(&playerID, &balance); err != nil {
+					return err
+				}
+				entry, err := core.NewOpeningBalanceEntry(playerID, balance)
+				entries = append(entries, entry)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			pots := squirrel.
+				Select("tournament_id", "pot").
+				From("tournament").
+				Where("pot <> 0").
+				OrderBy("tournament_id")
+			err = queryEach(r, pots, func(rows *sql.Rows) error {
+				var tournamentID int
+				var pot int64
+				if err := rows.Scan(&tournamentID, &pot); err != nil {
+					return err
+				}
+				entry, err := core.NewOpeningPotEntry(tournamentID, pot)
+				entries = append(entries, entry)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, entry := range entries {
+				if err := LedgerEntryInsert(r, entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// queryEach runs query and calls scan for every returned row. Rows are closed
+// before it returns, so the connection may be used by other queries.
+func queryEach(q squirrel.Queryer, query squirrel.SelectBuilder, scan func(*sql.Rows) error) error {
+	rows, err := squirrel.QueryWith(q, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// tournamentStateUp sets states of tournaments from legacy active and
+// cancelled columns. Active tournaments get registration_open state as both
+// joining and resulting was allowed for them. Nothing is done once the
+// active column is dropped.
+func tournamentStateUp(d dialect) migrationFunc {
+	return func(ctx context.Context, db *sql.DB) error {
+		hasActive, err := columnExists(ctx, db, d, "tournament", "active")
+		if err != nil || !hasActive {
+			return err
+		}
+		_, err = db.ExecContext(ctx, `UPDATE tournament SET state = CASE
+			WHEN cancelled THEN 'cancelled'
+			WHEN active THEN 'registration_open'
+			ELSE 'finished'
+		END`)
+		return err
+	}
+}
+
+// tournamentStateDown sets legacy active and cancelled columns from
+// tournament states. Tournaments which have not ended are active, their
+// exact state is lost.
+func tournamentStateDown(d dialect) migrationFunc {
+	return func(ctx context.Context, db *sql.DB) error {
+		hasState, err := columnExists(ctx, db, d, "tournament", "state")
+		if err != nil || !hasState {
+			return err
+		}
+		_, err = db.ExecContext(ctx, `UPDATE tournament SET
+			active = (state NOT IN ('finished', 'cancelled')),
+			cancelled = (state = 'cancelled')`)
+		return err
+	}
+}
+
+// backersUp moves backers stored as JSON in legacy data columns of
+// tournament players and winners to the backer tables and drops the columns.
+// Backers already moved are replaced so an interrupted migration may be
+// repeated.
+func backersUp(d dialect) migrationFunc {
+	return func(ctx context.Context, db *sql.DB) error {
+		for _, table := range backerTables {
+			hasData, err := columnExists(ctx, db, d, table, "data")
+			if err != nil {
+				return err
+			}
+			if !hasData {
+				continue
+			}
+
+			err = sqlTransaction(ctx, db, func(tx *sql.Tx) error {
+				r := newRunner(ctx, tx, d)
+				query := squirrel.
+					Select("tournament_id", "player_id", "data").
+					From(table)
+
+				rows, err := squirrel.QueryWith(r, query)
+				if err != nil {
+					return err
+				}
+				defer rows.Close()
+
+				backers := make(map[backerKey][]core.Backer)
+				for rows.Next() {
+					var k backerKey
+					var blob []byte
+					if err := rows.Scan(&k.tournamentID, &k.playerID, &blob); err != nil {
+						return err
+					}
+					var bs []core.Backer
+					if blob != nil {
+						if err := json.Unmarshal(blob, &bs); err != nil {
+							return fmt.Errorf("db: invalid %s backers of tournament %d player %s: %v", table, k.tournamentID, k.playerID, err)
+						}
+					}
+					backers[k] = bs
+				}
+				if err := rows.Err(); err != nil {
+					return err
+				}
+				rows.Close()
+
+				for k, bs := range backers {
+					if err := backersDelete(r, table+"_backer", k.tournamentID, k.playerID); err != nil {
+						return err
+					}
+					if err := backersInsert(r, table+"_backer", k.tournamentID, k.playerID, bs); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if err := dropColumn(d, table, "data")(ctx, db); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// backersDown stores backers of tournament players and winners as JSON in
+// legacy data columns. Nothing is done once the backer tables are dropped.
+func backersDown(d dialect) migrationFunc {
+	return func(ctx context.Context, db *sql.DB) error {
+		for _, table := range backerTables {
+			exists, err := tableExists(ctx, db, d, table+"_backer")
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+
+			err = sqlTransaction(ctx, db, func(tx *sql.Tx) error {
+				r := newRunner(ctx, tx, d)
+				query := squirrel.
+					Select("tournament_id", "player_id").
+					From(table)
+
+				rows, err := squirrel.QueryWith(r, query)
+				if err != nil {
+					return err
+				}
+				defer rows.Close()
+
+				var keys []backerKey
+				for rows.Next() {
+					var k backerKey
+					if err := rows.Scan(&k.tournamentID, &k.playerID); err != nil {
+						return err
+					}
+					keys = append(keys, k)
+				}
+				if err := rows.Err(); err != nil {
+					return err
+				}
+				rows.Close()
+
+				backers, err := backersSelect(r, table+"_backer", keys, false)
+				if err != nil {
+					return err
+				}
+				for _, k := range keys {
+					blob, err := json.Marshal(backers[k])
+					if err != nil {
+						return err
+					}
+					query := squirrel.
+						Update(table).
+						Set("data", blob).
+						Where(squirrel.Eq{
+							"tournament_id": k.tournamentID,
+							"player_id":     k.playerID,
+						})
+					if _, err := squirrel.ExecWith(r, query); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// idempotencyKeyClientsDown drops idempotency keys of authenticated clients,
+// keys of different clients may collide once they are not scoped by clients.
+func idempotencyKeyClientsDown(d dialect) migrationFunc {
+	return func(ctx context.Context, db *sql.DB) error {
+		hasClient, err := columnExists(ctx, db, d, "idempotency_key", "client")
+		if err != nil || !hasClient {
+			return err
+		}
+		_, err = db.ExecContext(ctx, `DELETE FROM idempotency_key WHERE client <> ''`)
+		return err
+	}
+}
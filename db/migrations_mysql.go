@@ -0,0 +1,258 @@
+package db
+
+// mysqlMigrations lists all MySQL schema migrations ordered by version.
+// Applied migrations must never be changed, schema changes are added as new
+// migrations to all dialects. Databases created before versioned migrations
+// are adopted as tables are created only if they do not exist and columns
+// are added or dropped only if they are missing or present.
+var mysqlMigrations = []migration{
+	{
+		version: 1,
+		name:    "baseline",
+		up: execStmts(
+			`CREATE TABLE IF NOT EXISTS player (
+				player_id VARCHAR(64) NOT NULL,
+				balance BIGINT UNSIGNED NOT NULL DEFAULT 0,
+				PRIMARY KEY (player_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS tournament (
+				tournament_id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+				entry_deposit BIGINT UNSIGNED NOT NULL DEFAULT 0,
+				active BOOL NOT NULL DEFAULT 1,
+				PRIMARY KEY (tournament_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS tournament_player (
+				tournament_id INT UNSIGNED NOT NULL,
+				player_id VARCHAR(64) NOT NULL,
+				fee BIGINT NOT NULL DEFAULT 0,
+				data BLOB NULL,
+				PRIMARY KEY (tournament_id, player_id),
+				KEY player_id (player_id),
+				FOREIGN KEY tournament_player_fk_tournament_id (tournament_id) REFERENCES tournament (tournament_id),
+				FOREIGN KEY tournament_player_fk_player_id (player_id) REFERENCES player (player_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS tournament_winner (
+				tournament_id INT UNSIGNED NOT NULL,
+				player_id VARCHAR(64) NOT NULL,
+				prize BIGINT NOT NULL DEFAULT 0,
+				data BLOB NULL,
+				PRIMARY KEY (tournament_id, player_id),
+				KEY player_id (player_id),
+				FOREIGN KEY tournament_winner_fk_tournament_id_player_id (tournament_id, player_id) REFERENCES tournament_player (tournament_id, player_id)
+			)`,
+		),
+		down: execStmts(
+			`DROP TABLE IF EXISTS tournament_winner`,
+			`DROP TABLE IF EXISTS tournament_player`,
+			`DROP TABLE IF EXISTS tournament`,
+			`DROP TABLE IF EXISTS player`,
+		),
+	},
+	{
+		version: 2,
+		name:    "ledger",
+		up: migrationSteps(
+			execStmts(
+				`CREATE TABLE IF NOT EXISTS ledger_entry (
+					ledger_entry_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+					tstamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					op BINARY(1) NOT NULL,
+					PRIMARY KEY (ledger_entry_id),
+					KEY (tstamp)
+				)`,
+				`CREATE TABLE IF NOT EXISTS transfer_log (
+					transfer_log_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+					ledger_entry_id BIGINT UNSIGNED NOT NULL,
+					tstamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					account BINARY(1) NOT NULL,
+					player_id VARCHAR(64) NULL,
+					points BIGINT NOT NULL,
+					op BINARY(1) NOT NULL,
+					tournament_id INT UNSIGNED NULL,
+					backed_player_id VARCHAR(64) NULL,
+					PRIMARY KEY (transfer_log_id),
+					KEY (tstamp),
+					KEY (player_id),
+					KEY (tournament_id),
+					FOREIGN KEY transfer_log_fk_ledger_entry_id (ledger_entry_id) REFERENCES ledger_entry (ledger_entry_id),
+					FOREIGN KEY transfer_log_fk_player_id (player_id) REFERENCES player (player_id),
+					FOREIGN KEY transfer_log_fk_tournament_id (tournament_id) REFERENCES tournament (tournament_id) ON DELETE SET NULL
+				)`,
+			),
+			addColumn(dialectMySQL, "tournament", "pot", "BIGINT NOT NULL DEFAULT 0"),
+			ledgerOpeningUp(dialectMySQL),
+		),
+		// opening entries and pots are dropped together with the ledger
+		down: migrationSteps(
+			dropColumn(dialectMySQL, "tournament", "pot"),
+			execStmts(
+				`DROP TABLE IF EXISTS transfer_log`,
+				`DROP TABLE IF EXISTS ledger_entry`,
+			),
+		),
+	},
+	{
+		version: 3,
+		name:    "idempotency_keys",
+		up: execStmts(
+			`CREATE TABLE IF NOT EXISTS idempotency_key (
+				idempotency_key VARCHAR(255) NOT NULL,
+				tstamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				fingerprint CHAR(64) NOT NULL,
+				status SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+				body BLOB NOT NULL,
+				PRIMARY KEY (idempotency_key),
+				KEY (tstamp)
+			)`,
+		),
+		down: execStmts(
+			`DROP TABLE IF EXISTS idempotency_key`,
+		),
+	},
+	{
+		version: 4,
+		name:    "tournament_cancellation",
+		up:      addColumn(dialectMySQL, "tournament", "cancelled", "BOOL NOT NULL DEFAULT 0"),
+		down:    dropColumn(dialectMySQL, "tournament", "cancelled"),
+	},
+	{
+		version: 5,
+		name:    "tournament_state",
+		up: migrationSteps(
+			addColumn(dialectMySQL, "tournament", "state", "VARCHAR(32) NOT NULL DEFAULT 'announced'"),
+			tournamentStateUp(dialectMySQL),
+			dropColumn(dialectMySQL, "tournament", "active"),
+			dropColumn(dialectMySQL, "tournament", "cancelled"),
+		),
+		down: migrationSteps(
+			addColumn(dialectMySQL, "tournament", "active", "BOOL NOT NULL DEFAULT 1"),
+			addColumn(dialectMySQL, "tournament", "cancelled", "BOOL NOT NULL DEFAULT 0"),
+			tournamentStateDown(dialectMySQL),
+			dropColumn(dialectMySQL, "tournament", "state"),
+		),
+	},
+	{
+		version: 6,
+		name:    "tournament_rake_payout",
+		up: migrationSteps(
+			addColumn(dialectMySQL, "tournament", "rake_percent", "TINYINT UNSIGNED NOT NULL DEFAULT 0"),
+			addColumn(dialectMySQL, "tournament", "house_fee", "BIGINT NOT NULL DEFAULT 0"),
+			addColumn(dialectMySQL, "tournament", "payout", "TEXT NULL"),
+		),
+		down: migrationSteps(
+			dropColumn(dialectMySQL, "tournament", "payout"),
+			dropColumn(dialectMySQL, "tournament", "house_fee"),
+			dropColumn(dialectMySQL, "tournament", "rake_percent"),
+		),
+	},
+	{
+		version: 7,
+		name:    "backing_requests",
+		up: execStmts(
+			`CREATE TABLE IF NOT EXISTS backing_request (
+				backing_request_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+				tstamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				tournament_id INT UNSIGNED NOT NULL,
+				player_id VARCHAR(64) NOT NULL,
+				status VARCHAR(16) NOT NULL DEFAULT 'pending',
+				PRIMARY KEY (backing_request_id),
+				KEY tournament_id_status (tournament_id, status),
+				KEY player_id (player_id),
+				FOREIGN KEY backing_request_fk_tournament_id (tournament_id) REFERENCES tournament (tournament_id),
+				FOREIGN KEY backing_request_fk_player_id (player_id) REFERENCES player (player_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS backing_request_stake (
+				backing_request_id BIGINT UNSIGNED NOT NULL,
+				seq SMALLINT UNSIGNED NOT NULL,
+				player_id VARCHAR(64) NOT NULL,
+				points BIGINT UNSIGNED NOT NULL,
+				accepted BOOL NOT NULL DEFAULT FALSE,
+				PRIMARY KEY (backing_request_id, seq),
+				KEY player_id (player_id),
+				FOREIGN KEY backing_request_stake_fk_backing_request_id (backing_request_id) REFERENCES backing_request (backing_request_id),
+				FOREIGN KEY backing_request_stake_fk_player_id (player_id) REFERENCES player (player_id)
+			)`,
+		),
+		down: execStmts(
+			`DROP TABLE IF EXISTS backing_request_stake`,
+			`DROP TABLE IF EXISTS backing_request`,
+		),
+	},
+	{
+		version: 8,
+		name:    "stake_offers",
+		up: execStmts(
+			`CREATE TABLE IF NOT EXISTS stake_offer (
+				stake_offer_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+				tstamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				tournament_id INT UNSIGNED NOT NULL,
+				player_id VARCHAR(64) NOT NULL,
+				available INT UNSIGNED NOT NULL,
+				markup INT UNSIGNED NOT NULL,
+				PRIMARY KEY (stake_offer_id),
+				UNIQUE KEY tournament_id_player_id (tournament_id, player_id),
+				FOREIGN KEY stake_offer_fk_tournament_id_player_id (tournament_id, player_id) REFERENCES tournament_player (tournament_id, player_id) ON DELETE CASCADE
+			)`,
+		),
+		down: execStmts(
+			`DROP TABLE IF EXISTS stake_offer`,
+		),
+	},
+	{
+		version: 9,
+		name:    "backer_tables",
+		up: migrationSteps(
+			execStmts(
+				`CREATE TABLE IF NOT EXISTS tournament_player_backer (
+					tournament_id INT UNSIGNED NOT NULL,
+					player_id VARCHAR(64) NOT NULL,
+					seq SMALLINT UNSIGNED NOT NULL,
+					backer_id VARCHAR(64) NOT NULL,
+					points BIGINT NOT NULL,
+					PRIMARY KEY (tournament_id, player_id, seq),
+					KEY backer_id (backer_id),
+					FOREIGN KEY tournament_player_backer_fk_tournament_id_player_id (tournament_id, player_id) REFERENCES tournament_player (tournament_id, player_id),
+					FOREIGN KEY tournament_player_backer_fk_backer_id (backer_id) REFERENCES player (player_id)
+				)`,
+				`CREATE TABLE IF NOT EXISTS tournament_winner_backer (
+					tournament_id INT UNSIGNED NOT NULL,
+					player_id VARCHAR(64) NOT NULL,
+					seq SMALLINT UNSIGNED NOT NULL,
+					backer_id VARCHAR(64) NOT NULL,
+					points BIGINT NOT NULL,
+					PRIMARY KEY (tournament_id, player_id, seq),
+					KEY backer_id (backer_id),
+					FOREIGN KEY tournament_winner_backer_fk_tournament_id_player_id (tournament_id, player_id) REFERENCES tournament_winner (tournament_id, player_id),
+					FOREIGN KEY tournament_winner_backer_fk_backer_id (backer_id) REFERENCES player (player_id)
+				)`,
+			),
+			backersUp(dialectMySQL),
+		),
+		down: migrationSteps(
+			addColumn(dialectMySQL, "tournament_player", "data", "BLOB NULL"),
+			addColumn(dialectMySQL, "tournament_winner", "data", "BLOB NULL"),
+			backersDown(dialectMySQL),
+			execStmts(
+				`DROP TABLE IF EXISTS tournament_winner_backer`,
+				`DROP TABLE IF EXISTS tournament_player_backer`,
+			),
+		),
+	},
+	{
+		version: 10,
+		name:    "idempotency_key_clients",
+		up: migrationSteps(
+			addColumn(dialectMySQL, "idempotency_key", "client", "VARCHAR(128) NOT NULL DEFAULT ''"),
+			execStmts(
+				`ALTER TABLE idempotency_key DROP PRIMARY KEY, ADD PRIMARY KEY (client, idempotency_key)`,
+			),
+		),
+		down: migrationSteps(
+			idempotencyKeyClientsDown(dialectMySQL),
+			execStmts(
+				`ALTER TABLE idempotency_key DROP PRIMARY KEY, ADD PRIMARY KEY (idempotency_key)`,
+			),
+			dropColumn(dialectMySQL, "idempotency_key", "client"),
+		),
+	},
+}
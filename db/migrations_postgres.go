@@ -0,0 +1,257 @@
+package db
+
+// postgresMigrations lists all PostgreSQL schema migrations ordered by
+// version. Migrations follow the MySQL ones, so versions have the same
+// meaning in all dialects. Unsigned MySQL columns are emulated with check
+// constraints.
+var postgresMigrations = []migration{
+	{
+		version: 1,
+		name:    "baseline",
+		up: execStmts(
+			`CREATE TABLE IF NOT EXISTS player (
+				player_id VARCHAR(64) NOT NULL,
+				balance BIGINT NOT NULL DEFAULT 0 CHECK (balance >= 0),
+				PRIMARY KEY (player_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS tournament (
+				tournament_id INTEGER NOT NULL CHECK (tournament_id >= 0),
+				entry_deposit BIGINT NOT NULL DEFAULT 0 CHECK (entry_deposit >= 0),
+				active BOOLEAN NOT NULL DEFAULT TRUE,
+				PRIMARY KEY (tournament_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS tournament_player (
+				tournament_id INTEGER NOT NULL,
+				player_id VARCHAR(64) NOT NULL,
+				fee BIGINT NOT NULL DEFAULT 0,
+				data BYTEA NULL,
+				PRIMARY KEY (tournament_id, player_id),
+				CONSTRAINT tournament_player_fk_tournament_id FOREIGN KEY (tournament_id) REFERENCES tournament (tournament_id),
+				CONSTRAINT tournament_player_fk_player_id FOREIGN KEY (player_id) REFERENCES player (player_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS tournament_player_player_id ON tournament_player (player_id)`,
+			`CREATE TABLE IF NOT EXISTS tournament_winner (
+				tournament_id INTEGER NOT NULL,
+				player_id VARCHAR(64) NOT NULL,
+				prize BIGINT NOT NULL DEFAULT 0,
+				data BYTEA NULL,
+				PRIMARY KEY (tournament_id, player_id),
+				CONSTRAINT tournament_winner_fk_tournament_id_player_id FOREIGN KEY (tournament_id, player_id) REFERENCES tournament_player (tournament_id, player_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS tournament_winner_player_id ON tournament_winner (player_id)`,
+		),
+		down: execStmts(
+			`DROP TABLE IF EXISTS tournament_winner`,
+			`DROP TABLE IF EXISTS tournament_player`,
+			`DROP TABLE IF EXISTS tournament`,
+			`DROP TABLE IF EXISTS player`,
+		),
+	},
+	{
+		version: 2,
+		name:    "ledger",
+		up: migrationSteps(
+			execStmts(
+				`CREATE TABLE IF NOT EXISTS ledger_entry (
+					ledger_entry_id BIGSERIAL NOT NULL,
+					tstamp TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					op BYTEA NOT NULL,
+					PRIMARY KEY (ledger_entry_id)
+				)`,
+				`CREATE INDEX IF NOT EXISTS ledger_entry_tstamp ON ledger_entry (tstamp)`,
+				`CREATE TABLE IF NOT EXISTS transfer_log (
+					transfer_log_id BIGSERIAL NOT NULL,
+					ledger_entry_id BIGINT NOT NULL,
+					tstamp TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					account BYTEA NOT NULL,
+					player_id VARCHAR(64) NULL,
+					points BIGINT NOT NULL,
+					op BYTEA NOT NULL,
+					tournament_id INTEGER NULL,
+					backed_player_id VARCHAR(64) NULL,
+					PRIMARY KEY (transfer_log_id),
+					CONSTRAINT transfer_log_fk_ledger_entry_id FOREIGN KEY (ledger_entry_id) REFERENCES ledger_entry (ledger_entry_id),
+					CONSTRAINT transfer_log_fk_player_id FOREIGN KEY (player_id) REFERENCES player (player_id),
+					CONSTRAINT transfer_log_fk_tournament_id FOREIGN KEY (tournament_id) REFERENCES tournament (tournament_id) ON DELETE SET NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS transfer_log_tstamp ON transfer_log (tstamp)`,
+				`CREATE INDEX IF NOT EXISTS transfer_log_player_id ON transfer_log (player_id)`,
+				`CREATE INDEX IF NOT EXISTS transfer_log_tournament_id ON transfer_log (tournament_id)`,
+			),
+			addColumn(dialectPostgres, "tournament", "pot", "BIGINT NOT NULL DEFAULT 0"),
+			ledgerOpeningUp(dialectPostgres),
+		),
+		// opening entries and pots are dropped together with the ledger
+		down: migrationSteps(
+			dropColumn(dialectPostgres, "tournament", "pot"),
+			execStmts(
+				`DROP TABLE IF EXISTS transfer_log`,
+				`DROP TABLE IF EXISTS ledger_entry`,
+			),
+		),
+	},
+	{
+		version: 3,
+		name:    "idempotency_keys",
+		up: execStmts(
+			`CREATE TABLE IF NOT EXISTS idempotency_key (
+				idempotency_key VARCHAR(255) NOT NULL,
+				tstamp TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				fingerprint CHAR(64) NOT NULL,
+				status SMALLINT NOT NULL DEFAULT 0 CHECK (status >= 0),
+				body TEXT NOT NULL,
+				PRIMARY KEY (idempotency_key)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idempotency_key_tstamp ON idempotency_key (tstamp)`,
+		),
+		down: execStmts(
+			`DROP TABLE IF EXISTS idempotency_key`,
+		),
+	},
+	{
+		version: 4,
+		name:    "tournament_cancellation",
+		up:      addColumn(dialectPostgres, "tournament", "cancelled", "BOOLEAN NOT NULL DEFAULT FALSE"),
+		down:    dropColumn(dialectPostgres, "tournament", "cancelled"),
+	},
+	{
+		version: 5,
+		name:    "tournament_state",
+		up: migrationSteps(
+			addColumn(dialectPostgres, "tournament", "state", "VARCHAR(32) NOT NULL DEFAULT 'announced'"),
+			tournamentStateUp(dialectPostgres),
+			dropColumn(dialectPostgres, "tournament", "active"),
+			dropColumn(dialectPostgres, "tournament", "cancelled"),
+		),
+		down: migrationSteps(
+			addColumn(dialectPostgres, "tournament", "active", "BOOLEAN NOT NULL DEFAULT TRUE"),
+			addColumn(dialectPostgres, "tournament", "cancelled", "BOOLEAN NOT NULL DEFAULT FALSE"),
+			tournamentStateDown(dialectPostgres),
+			dropColumn(dialectPostgres, "tournament", "state"),
+		),
+	},
+	{
+		version: 6,
+		name:    "tournament_rake_payout",
+		up: migrationSteps(
+			addColumn(dialectPostgres, "tournament", "rake_percent", "SMALLINT NOT NULL DEFAULT 0 CHECK (rake_percent >= 0)"),
+			addColumn(dialectPostgres, "tournament", "house_fee", "BIGINT NOT NULL DEFAULT 0"),
+			addColumn(dialectPostgres, "tournament", "payout", "TEXT NULL"),
+		),
+		down: migrationSteps(
+			dropColumn(dialectPostgres, "tournament", "payout"),
+			dropColumn(dialectPostgres, "tournament", "house_fee"),
+			dropColumn(dialectPostgres, "tournament", "rake_percent"),
+		),
+	},
+	{
+		version: 7,
+		name:    "backing_requests",
+		up: execStmts(
+			`CREATE TABLE IF NOT EXISTS backing_request (
+				backing_request_id BIGSERIAL NOT NULL,
+				tstamp TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				tournament_id INTEGER NOT NULL,
+				player_id VARCHAR(64) NOT NULL,
+				status VARCHAR(16) NOT NULL DEFAULT 'pending',
+				PRIMARY KEY (backing_request_id),
+				CONSTRAINT backing_request_fk_tournament_id FOREIGN KEY (tournament_id) REFERENCES tournament (tournament_id),
+				CONSTRAINT backing_request_fk_player_id FOREIGN KEY (player_id) REFERENCES player (player_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS backing_request_tournament_id_status ON backing_request (tournament_id, status)`,
+			`CREATE INDEX IF NOT EXISTS backing_request_player_id ON backing_request (player_id)`,
+			`CREATE TABLE IF NOT EXISTS backing_request_stake (
+				backing_request_id BIGINT NOT NULL,
+				seq SMALLINT NOT NULL CHECK (seq >= 0),
+				player_id VARCHAR(64) NOT NULL,
+				points BIGINT NOT NULL CHECK (points >= 0),
+				accepted BOOLEAN NOT NULL DEFAULT FALSE,
+				PRIMARY KEY (backing_request_id, seq),
+				CONSTRAINT backing_request_stake_fk_backing_request_id FOREIGN KEY (backing_request_id) REFERENCES backing_request (backing_request_id),
+				CONSTRAINT backing_request_stake_fk_player_id FOREIGN KEY (player_id) REFERENCES player (player_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS backing_request_stake_player_id ON backing_request_stake (player_id)`,
+		),
+		down: execStmts(
+			`DROP TABLE IF EXISTS backing_request_stake`,
+			`DROP TABLE IF EXISTS backing_request`,
+		),
+	},
+	{
+		version: 8,
+		name:    "stake_offers",
+		up: execStmts(
+			`CREATE TABLE IF NOT EXISTS stake_offer (
+				stake_offer_id BIGSERIAL NOT NULL,
+				tstamp TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				tournament_id INTEGER NOT NULL,
+				player_id VARCHAR(64) NOT NULL,
+				available INTEGER NOT NULL CHECK (available >= 0),
+				markup INTEGER NOT NULL CHECK (markup >= 0),
+				PRIMARY KEY (stake_offer_id),
+				CONSTRAINT stake_offer_tournament_id_player_id UNIQUE (tournament_id, player_id),
+				CONSTRAINT stake_offer_fk_tournament_id_player_id FOREIGN KEY (tournament_id, player_id) REFERENCES tournament_player (tournament_id, player_id) ON DELETE CASCADE
+			)`,
+		),
+		down: execStmts(
+			`DROP TABLE IF EXISTS stake_offer`,
+		),
+	},
+	{
+		version: 9,
+		name:    "backer_tables",
+		up: migrationSteps(
+			execStmts(
+				`CREATE TABLE IF NOT EXISTS tournament_player_backer (
+					tournament_id INTEGER NOT NULL,
+					player_id VARCHAR(64) NOT NULL,
+					seq SMALLINT NOT NULL CHECK (seq >= 0),
+					backer_id VARCHAR(64) NOT NULL,
+					points BIGINT NOT NULL,
+					PRIMARY KEY (tournament_id, player_id, seq),
+					CONSTRAINT tournament_player_backer_fk_tournament_id_player_id FOREIGN KEY (tournament_id, player_id) REFERENCES tournament_player (tournament_id, player_id),
+					CONSTRAINT tournament_player_backer_fk_backer_id FOREIGN KEY (backer_id) REFERENCES player (player_id)
+				)`,
+				`CREATE INDEX IF NOT EXISTS tournament_player_backer_backer_id ON tournament_player_backer (backer_id)`,
+				`CREATE TABLE IF NOT EXISTS tournament_winner_backer (
+					tournament_id INTEGER NOT NULL,
+					player_id VARCHAR(64) NOT NULL,
+					seq SMALLINT NOT NULL CHECK (seq >= 0),
+					backer_id VARCHAR(64) NOT NULL,
+					points BIGINT NOT NULL,
+					PRIMARY KEY (tournament_id, player_id, seq),
+					CONSTRAINT tournament_winner_backer_fk_tournament_id_player_id FOREIGN KEY (tournament_id, player_id) REFERENCES tournament_winner (tournament_id, player_id),
+					CONSTRAINT tournament_winner_backer_fk_backer_id FOREIGN KEY (backer_id) REFERENCES player (player_id)
+				)`,
+				`CREATE INDEX IF NOT EXISTS tournament_winner_backer_backer_id ON tournament_winner_backer (backer_id)`,
+			),
+			backersUp(dialectPostgres),
+		),
+		down: migrationSteps(
+			addColumn(dialectPostgres, "tournament_player", "data", "BYTEA NULL"),
+			addColumn(dialectPostgres, "tournament_winner", "data", "BYTEA NULL"),
+			backersDown(dialectPostgres),
+			execStmts(
+				`DROP TABLE IF EXISTS tournament_winner_backer`,
+				`DROP TABLE IF EXISTS tournament_player_backer`,
+			),
+		),
+	},
+	{
+		version: 10,
+		name:    "idempotency_key_clients",
+		up: migrationSteps(
+			addColumn(dialectPostgres, "idempotency_key", "client", "VARCHAR(128) NOT NULL DEFAULT ''"),
+			execStmts(
+				`ALTER TABLE idempotency_key DROP CONSTRAINT idempotency_key_pkey, ADD PRIMARY KEY (client, idempotency_key)`,
+			),
+		),
+		down: migrationSteps(
+			idempotencyKeyClientsDown(dialectPostgres),
+			execStmts(
+				`ALTER TABLE idempotency_key DROP CONSTRAINT idempotency_key_pkey, ADD PRIMARY KEY (idempotency_key)`,
+			),
+			dropColumn(dialectPostgres, "idempotency_key", "client"),
+		),
+	},
+}
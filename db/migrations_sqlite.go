@@ -0,0 +1,291 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// sqliteMigrations lists all SQLite schema migrations ordered by version.
+// Timestamps default to the current UTC time in sqliteTimeFormat, so they
+// compare correctly with bound time arguments.
+var sqliteMigrations = []migration{
+	{
+		version: 1,
+		name:    "baseline",
+		up: execStmts(
+			`CREATE TABLE IF NOT EXISTS player (
+				player_id VARCHAR(64) NOT NULL,
+				balance BIGINT NOT NULL DEFAULT 0 CHECK (balance >= 0),
+				PRIMARY KEY (player_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS tournament (
+				tournament_id INTEGER NOT NULL CHECK (tournament_id >= 0),
+				entry_deposit BIGINT NOT NULL DEFAULT 0 CHECK (entry_deposit >= 0),
+				active BOOLEAN NOT NULL DEFAULT 1,
+				PRIMARY KEY (tournament_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS tournament_player (
+				tournament_id INTEGER NOT NULL,
+				player_id VARCHAR(64) NOT NULL,
+				fee BIGINT NOT NULL DEFAULT 0,
+				data BLOB NULL,
+				PRIMARY KEY (tournament_id, player_id),
+				CONSTRAINT tournament_player_fk_tournament_id FOREIGN KEY (tournament_id) REFERENCES tournament (tournament_id),
+				CONSTRAINT tournament_player_fk_player_id FOREIGN KEY (player_id) REFERENCES player (player_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS tournament_player_player_id ON tournament_player (player_id)`,
+			`CREATE TABLE IF NOT EXISTS tournament_winner (
+				tournament_id INTEGER NOT NULL,
+				player_id VARCHAR(64) NOT NULL,
+				prize BIGINT NOT NULL DEFAULT 0,
+				data BLOB NULL,
+				PRIMARY KEY (tournament_id, player_id),
+				CONSTRAINT tournament_winner_fk_tournament_id_player_id FOREIGN KEY (tournament_id, player_id) REFERENCES tournament_player (tournament_id, player_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS tournament_winner_player_id ON tournament_winner (player_id)`,
+		),
+		down: execStmts(
+			`DROP TABLE IF EXISTS tournament_winner`,
+			`DROP TABLE IF EXISTS tournament_player`,
+			`DROP TABLE IF EXISTS tournament`,
+			`DROP TABLE IF EXISTS player`,
+		),
+	},
+	{
+		version: 2,
+		name:    "ledger",
+		up: migrationSteps(
+			execStmts(
+				`CREATE TABLE IF NOT EXISTS ledger_entry (
+					ledger_entry_id INTEGER PRIMARY KEY AUTOINCREMENT,
+					tstamp TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+					op BLOB NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS ledger_entry_tstamp ON ledger_entry (tstamp)`,
+				`CREATE TABLE IF NOT EXISTS transfer_log (
+					transfer_log_id INTEGER PRIMARY KEY AUTOINCREMENT,
+					ledger_entry_id BIGINT NOT NULL,
+					tstamp TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+					account BLOB NOT NULL,
+					player_id VARCHAR(64) NULL,
+					points BIGINT NOT NULL,
+					op BLOB NOT NULL,
+					tournament_id INTEGER NULL,
+					backed_player_id VARCHAR(64) NULL,
+					CONSTRAINT transfer_log_fk_ledger_entry_id FOREIGN KEY (ledger_entry_id) REFERENCES ledger_entry (ledger_entry_id),
+					CONSTRAINT transfer_log_fk_player_id FOREIGN KEY (player_id) REFERENCES player (player_id),
+					CONSTRAINT transfer_log_fk_tournament_id FOREIGN KEY (tournament_id) REFERENCES tournament (tournament_id) ON DELETE SET NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS transfer_log_tstamp ON transfer_log (tstamp)`,
+				`CREATE INDEX IF NOT EXISTS transfer_log_player_id ON transfer_log (player_id)`,
+				`CREATE INDEX IF NOT EXISTS transfer_log_tournament_id ON transfer_log (tournament_id)`,
+			),
+			addColumn(dialectSQLite, "tournament", "pot", "BIGINT NOT NULL DEFAULT 0"),
+			ledgerOpeningUp(dialectSQLite),
+		),
+		// opening entries and pots are dropped together with the ledger
+		down: migrationSteps(
+			dropColumn(dialectSQLite, "tournament", "pot"),
+			execStmts(
+				`DROP TABLE IF EXISTS transfer_log`,
+				`DROP TABLE IF EXISTS ledger_entry`,
+			),
+		),
+	},
+	{
+		version: 3,
+		name:    "idempotency_keys",
+		up: execStmts(
+			`CREATE TABLE IF NOT EXISTS idempotency_key (
+				idempotency_key VARCHAR(255) NOT NULL,
+				tstamp TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+				fingerprint CHAR(64) NOT NULL,
+				status SMALLINT NOT NULL DEFAULT 0 CHECK (status >= 0),
+				body TEXT NOT NULL,
+				PRIMARY KEY (idempotency_key)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idempotency_key_tstamp ON idempotency_key (tstamp)`,
+		),
+		down: execStmts(
+			`DROP TABLE IF EXISTS idempotency_key`,
+		),
+	},
+	{
+		version: 4,
+		name:    "tournament_cancellation",
+		up:      addColumn(dialectSQLite, "tournament", "cancelled", "BOOLEAN NOT NULL DEFAULT 0"),
+		down:    dropColumn(dialectSQLite, "tournament", "cancelled"),
+	},
+	{
+		version: 5,
+		name:    "tournament_state",
+		up: migrationSteps(
+			addColumn(dialectSQLite, "tournament", "state", "VARCHAR(32) NOT NULL DEFAULT 'announced'"),
+			tournamentStateUp(dialectSQLite),
+			dropColumn(dialectSQLite, "tournament", "active"),
+			dropColumn(dialectSQLite, "tournament", "cancelled"),
+		),
+		down: migrationSteps(
+			addColumn(dialectSQLite, "tournament", "active", "BOOLEAN NOT NULL DEFAULT 1"),
+			addColumn(dialectSQLite, "tournament", "cancelled", "BOOLEAN NOT NULL DEFAULT 0"),
+			tournamentStateDown(dialectSQLite),
+			dropColumn(dialectSQLite, "tournament", "state"),
+		),
+	},
+	{
+		version: 6,
+		name:    "tournament_rake_payout",
+		up: migrationSteps(
+			addColumn(dialectSQLite, "tournament", "rake_percent", "SMALLINT NOT NULL DEFAULT 0 CHECK (rake_percent >= 0)"),
+			addColumn(dialectSQLite, "tournament", "house_fee", "BIGINT NOT NULL DEFAULT 0"),
+			addColumn(dialectSQLite, "tournament", "payout", "TEXT NULL"),
+		),
+		down: migrationSteps(
+			dropColumn(dialectSQLite, "tournament", "payout"),
+			dropColumn(dialectSQLite, "tournament", "house_fee"),
+			dropColumn(dialectSQLite, "tournament", "rake_percent"),
+		),
+	},
+	{
+		version: 7,
+		name:    "backing_requests",
+		up: execStmts(
+			`CREATE TABLE IF NOT EXISTS backing_request (
+				backing_request_id INTEGER PRIMARY KEY AUTOINCREMENT,
+				tstamp TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+				tournament_id INTEGER NOT NULL,
+				player_id VARCHAR(64) NOT NULL,
+				status VARCHAR(16) NOT NULL DEFAULT 'pending',
+				CONSTRAINT backing_request_fk_tournament_id FOREIGN KEY (tournament_id) REFERENCES tournament (tournament_id),
+				CONSTRAINT backing_request_fk_player_id FOREIGN KEY (player_id) REFERENCES player (player_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS backing_request_tournament_id_status ON backing_request (tournament_id, status)`,
+			`CREATE INDEX IF NOT EXISTS backing_request_player_id ON backing_request (player_id)`,
+			`CREATE TABLE IF NOT EXISTS backing_request_stake (
+				backing_request_id BIGINT NOT NULL,
+				seq SMALLINT NOT NULL CHECK (seq >= 0),
+				player_id VARCHAR(64) NOT NULL,
+				points BIGINT NOT NULL CHECK (points >= 0),
+				accepted BOOLEAN NOT NULL DEFAULT FALSE,
+				PRIMARY KEY (backing_request_id, seq),
+				CONSTRAINT backing_request_stake_fk_backing_request_id FOREIGN KEY (backing_request_id) REFERENCES backing_request (backing_request_id),
+				CONSTRAINT backing_request_stake_fk_player_id FOREIGN KEY (player_id) REFERENCES player (player_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS backing_request_stake_player_id ON backing_request_stake (player_id)`,
+		),
+		down: execStmts(
+			`DROP TABLE IF EXISTS backing_request_stake`,
+			`DROP TABLE IF EXISTS backing_request`,
+		),
+	},
+	{
+		version: 8,
+		name:    "stake_offers",
+		up: execStmts(
+			`CREATE TABLE IF NOT EXISTS stake_offer (
+				stake_offer_id INTEGER PRIMARY KEY AUTOINCREMENT,
+				tstamp TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+				tournament_id INTEGER NOT NULL,
+				player_id VARCHAR(64) NOT NULL,
+				available INTEGER NOT NULL CHECK (available >= 0),
+				markup INTEGER NOT NULL CHECK (markup >= 0),
+				CONSTRAINT stake_offer_tournament_id_player_id UNIQUE (tournament_id, player_id),
+				CONSTRAINT stake_offer_fk_tournament_id_player_id FOREIGN KEY (tournament_id, player_id) REFERENCES tournament_player (tournament_id, player_id) ON DELETE CASCADE
+			)`,
+		),
+		down: execStmts(
+			`DROP TABLE IF EXISTS stake_offer`,
+		),
+	},
+	{
+		version: 9,
+		name:    "backer_tables",
+		up: migrationSteps(
+			execStmts(
+				`CREATE TABLE IF NOT EXISTS tournament_player_backer (
+					tournament_id INTEGER NOT NULL,
+					player_id VARCHAR(64) NOT NULL,
+					seq SMALLINT NOT NULL CHECK (seq >= 0),
+					backer_id VARCHAR(64) NOT NULL,
+					points BIGINT NOT NULL,
+					PRIMARY KEY (tournament_id, player_id, seq),
+					CONSTRAINT tournament_player_backer_fk_tournament_id_player_id FOREIGN KEY (tournament_id, player_id) REFERENCES tournament_player (tournament_id, player_id),
+					CONSTRAINT tournament_player_backer_fk_backer_id FOREIGN KEY (backer_id) REFERENCES player (player_id)
+				)`,
+				`CREATE INDEX IF NOT EXISTS tournament_player_backer_backer_id ON tournament_player_backer (backer_id)`,
+				`CREATE TABLE IF NOT EXISTS tournament_winner_backer (
+					tournament_id INTEGER NOT NULL,
+					player_id VARCHAR(64) NOT NULL,
+					seq SMALLINT NOT NULL CHECK (seq >= 0),
+					backer_id VARCHAR(64) NOT NULL,
+					points BIGINT NOT NULL,
+					PRIMARY KEY (tournament_id, player_id, seq),
+					CONSTRAINT tournament_winner_backer_fk_tournament_id_player_id FOREIGN KEY (tournament_id, player_id) REFERENCES tournament_winner (tournament_id, player_id),
+					CONSTRAINT tournament_winner_backer_fk_backer_id FOREIGN KEY (backer_id) REFERENCES player (player_id)
+				)`,
+				`CREATE INDEX IF NOT EXISTS tournament_winner_backer_backer_id ON tournament_winner_backer (backer_id)`,
+			),
+			backersUp(dialectSQLite),
+		),
+		down: migrationSteps(
+			addColumn(dialectSQLite, "tournament_player", "data", "BLOB NULL"),
+			addColumn(dialectSQLite, "tournament_winner", "data", "BLOB NULL"),
+			backersDown(dialectSQLite),
+			execStmts(
+				`DROP TABLE IF EXISTS tournament_winner_backer`,
+				`DROP TABLE IF EXISTS tournament_player_backer`,
+			),
+		),
+	},
+	{
+		version: 10,
+		name:    "idempotency_key_clients",
+		up:      sqliteIdempotencyKeyTable(true),
+		down: migrationSteps(
+			idempotencyKeyClientsDown(dialectSQLite),
+			sqliteIdempotencyKeyTable(false),
+		),
+	},
+}
+
+// sqliteIdempotencyKeyTable returns migration step rebuilding idempotency key
+// table with or without client column, as SQLite can not alter primary keys.
+// The table is rebuilt in a transaction unless it has the column already.
+func sqliteIdempotencyKeyTable(clients bool) migrationFunc {
+	return func(ctx context.Context, db *sql.DB) error {
+		hasClient, err := columnExists(ctx, db, dialectSQLite, "idempotency_key", "client")
+		if err != nil || hasClient == clients {
+			return err
+		}
+
+		client, key := "", "idempotency_key"
+		if clients {
+			client = "client VARCHAR(128) NOT NULL DEFAULT '',"
+			key = "client, idempotency_key"
+		}
+		stmts := []string{
+			`CREATE TABLE idempotency_key_new (
+				` + client + `
+				idempotency_key VARCHAR(255) NOT NULL,
+				tstamp TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+				fingerprint CHAR(64) NOT NULL,
+				status SMALLINT NOT NULL DEFAULT 0 CHECK (status >= 0),
+				body TEXT NOT NULL,
+				PRIMARY KEY (` + key + `)
+			)`,
+			`INSERT INTO idempotency_key_new (idempotency_key, tstamp, fingerprint, status, body)
+				SELECT idempotency_key, tstamp, fingerprint, status, body FROM idempotency_key`,
+			`DROP TABLE idempotency_key`,
+			`ALTER TABLE idempotency_key_new RENAME TO idempotency_key`,
+			`CREATE INDEX IF NOT EXISTS idempotency_key_tstamp ON idempotency_key (tstamp)`,
+		}
+		return sqlTransaction(ctx, db, func(tx *sql.Tx) error {
+			for _, stmt := range stmts {
+				if _, err := tx.ExecContext(ctx, stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
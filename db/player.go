@@ -3,7 +3,6 @@ package db
 import (
 	"github.com/20170819lgg/sts/core"
 	"github.com/Masterminds/squirrel"
-	"github.com/go-sql-driver/mysql"
 )
 
 // playerSelect is generic funcion for querying player table.
@@ -29,6 +28,12 @@ func playerSelect(q squirrel.Queryer, d queryDecorator) ([]core.Player, error) {
 	return ps, nil
 }
 
+func PlayerList(q squirrel.Queryer) ([]core.Player, error) {
+	return playerSelect(q, func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
+		return b.OrderBy("player_id")
+	})
+}
+
 func PlayerSelectForUpdate(q squirrel.Queryer, playerIDs []string) (map[string]*core.Player, error) {
 	ps, err := playerSelect(q, func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
 		return b.
@@ -80,7 +85,7 @@ func PlayerInsert(e squirrel.Execer, player *core.Player) error {
 			"balance":   player.Balance,
 		})
 	_, err := squirrel.ExecWith(e, query)
-	if me, ok := err.(*mysql.MySQLError); ok && me.Number == 1062 {
+	if isDuplicate(err) {
 		return ErrAlreadyExists
 	}
 	return err
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/20170819lgg/sts/core"
+)
+
+// SQLStore is a Store backed by MySQL/MariaDB, PostgreSQL or SQLite database.
+type SQLStore struct {
+	db      *sql.DB
+	dsn     string
+	dialect dialect
+	// txLock serializes SQLite transactions of the process. SQLite waits
+	// for its database lock regardless of the context, so transactions
+	// wait for this lock instead.
+	txLock chan struct{}
+}
+
+// NewSQLStore creates store using a connected database, DSN selects the SQL
+// dialect and it is used to recreate the database on reset.
+func NewSQLStore(dbh *sql.DB, dsn string) *SQLStore {
+	s := &SQLStore{
+		db:      dbh,
+		dsn:     dsn,
+		dialect: dialectOf(dsn),
+	}
+	if s.dialect == dialectSQLite {
+		s.txLock = make(chan struct{}, 1)
+	}
+	return s
+}
+
+// DB returns underlying database handle.
+func (s *SQLStore) DB() *sql.DB {
+	return s.db
+}
+
+func (s *SQLStore) Begin(ctx context.Context) (Tx, error) {
+	unlock := func() {}
+	if s.txLock != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		select {
+		case s.txLock <- struct{}{}:
+			var once sync.Once
+			unlock = func() { once.Do(func() { <-s.txLock }) }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return &sqlTx{
+		tx:     tx,
+		run:    newRunner(ctx, tx, s.dialect),
+		unlock: unlock,
+	}, nil
+}
+
+func (s *SQLStore) MigrateUp(ctx context.Context) ([]int, error) {
+	return migrateUp(ctx, s.db, s.dialect)
+}
+func (s *SQLStore) MigrateDown(ctx context.Context) (int, error) {
+	return migrateDown(ctx, s.db, s.dialect)
+}
+func (s *SQLStore) MigrateStatus(ctx context.Context) ([]MigrationStatus, error) {
+	return migrateStatus(ctx, s.db, s.dialect)
+}
+func (s *SQLStore) Close() error { return s.db.Close() }
+
+// Reset recreates the database. PostgreSQL database can not be dropped while
+// connected to it, so its public schema is recreated instead. SQLite
+// database file is kept and all migrations are reverted.
+func (s *SQLStore) Reset(ctx context.Context) error {
+	switch s.dialect {
+	case dialectPostgres:
+		err := execStmts(
+			`DROP SCHEMA IF EXISTS public CASCADE`,
+			`CREATE SCHEMA public`,
+		)(ctx, s.db)
+		if err != nil {
+			return err
+		}
+	case dialectSQLite:
+		for {
+			_, err := s.MigrateDown(ctx)
+			if err == ErrNoMigration {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		if err := RecreateDB(ctx, s.dsn); err != nil {
+			return err
+		}
+	}
+	_, err := s.MigrateUp(ctx)
+	return err
+}
+
+// sqlTx implements Tx using package query functions within a database
+// transaction. Queries are run through runner translating them to the store
+// dialect.
+type sqlTx struct {
+	tx     *sql.Tx
+	run    *runner
+	unlock func()
+}
+
+func (t *sqlTx) Commit() error {
+	defer t.unlock()
+	return t.tx.Commit()
+}
+
+func (t *sqlTx) Rollback() error {
+	defer t.unlock()
+	if err := t.tx.Rollback(); err != sql.ErrTxDone {
+		return err
+	}
+	return nil
+}
+
+func (t *sqlTx) PlayerList() ([]core.Player, error) { return PlayerList(t.run) }
+func (t *sqlTx) PlayerGet(playerID string) (*core.Player, error) {
+	return PlayerGet(t.run, playerID)
+}
+func (t *sqlTx) PlayerGetForUpdate(playerID string) (*core.Player, error) {
+	return PlayerGetForUpdate(t.run, playerID)
+}
+func (t *sqlTx) PlayerSelectForUpdate(playerIDs []string) (map[string]*core.Player, error) {
+	return PlayerSelectForUpdate(t.run, playerIDs)
+}
+func (t *sqlTx) PlayerInsert(player *core.Player) error { return PlayerInsert(t.run, player) }
+func (t *sqlTx) PlayerUpdate(player *core.Player) error { return PlayerUpdate(t.run, player) }
+
+func (t *sqlTx) TournamentList() ([]core.Tournament, error) { return TournamentList(t.run) }
+func (t *sqlTx) TournamentGet(tournamentID int) (*core.Tournament, error) {
+	return TournamentGet(t.run, tournamentID)
+}
+func (t *sqlTx) TournamentGetForUpdate(tournamentID int) (*core.Tournament, error) {
+	return TournamentGetForUpdate(t.run, tournamentID)
+}
+func (t *sqlTx) TournamentInsert(tournament *core.Tournament) error {
+	return TournamentInsert(t.run, tournament)
+}
+func (t *sqlTx) TournamentUpdate(tournament *core.Tournament) error {
+	return TournamentUpdate(t.run, tournament)
+}
+
+func (t *sqlTx) TournPlayerGet(tournamentID int, playerID string) (*core.TournPlayer, error) {
+	return TournPlayerGet(t.run, tournamentID, playerID)
+}
+func (t *sqlTx) TournPlayerGetForUpdate(tournamentID int, playerID string) (*core.TournPlayer, error) {
+	return TournPlayerGetForUpdate(t.run, tournamentID, playerID)
+}
+func (t *sqlTx) TournPlayerList(tournamentID int) ([]core.TournPlayer, error) {
+	return TournPlayerList(t.run, tournamentID)
+}
+func (t *sqlTx) TournPlayerInsert(tp *core.TournPlayer) error { return TournPlayerInsert(t.run, tp) }
+func (t *sqlTx) TournPlayerUpdate(tp *core.TournPlayer) error { return TournPlayerUpdate(t.run, tp) }
+func (t *sqlTx) TournPlayerDelete(tp *core.TournPlayer) error { return TournPlayerDelete(t.run, tp) }
+
+func (t *sqlTx) TournamentWinnerList() ([]core.TournWinner, error) {
+	return TournamentWinnerList(t.run)
+}
+func (t *sqlTx) TournamentWinnerInsert(tw *core.TournWinner) error {
+	return TournamentWinnerInsert(t.run, tw)
+}
+
+func (t *sqlTx) LedgerEntryInsert(entry *core.LedgerEntry) error {
+	return LedgerEntryInsert(t.run, entry)
+}
+func (t *sqlTx) LedgerSumsGet() (*core.LedgerSums, error) { return LedgerSumsGet(t.run) }
+func (t *sqlTx) TransferLogSelect(f TransferFilter) ([]core.Transfer, error) {
+	return TransferLogSelect(t.run, f)
+}
+func (t *sqlTx) BackingList(playerID string, from, to time.Time) ([]core.Backing, error) {
+	return BackingList(t.run, playerID, from, to)
+}
+
+func (t *sqlTx) BackingRequestGet(requestID int64) (*core.BackingRequest, error) {
+	return BackingRequestGet(t.run, requestID)
+}
+func (t *sqlTx) BackingRequestGetForUpdate(requestID int64) (*core.BackingRequest, error) {
+	return BackingRequestGetForUpdate(t.run, requestID)
+}
+func (t *sqlTx) BackingRequestListPending(playerID string) ([]core.BackingRequest, error) {
+	return BackingRequestListPending(t.run, playerID)
+}
+func (t *sqlTx) BackingRequestInsert(r *core.BackingRequest) error {
+	return BackingRequestInsert(t.run, r)
+}
+func (t *sqlTx) BackingRequestUpdate(r *core.BackingRequest) error {
+	return BackingRequestUpdate(t.run, r)
+}
+func (t *sqlTx) BackingRequestExpire(tournamentID int) error {
+	return BackingRequestExpire(t.run, tournamentID)
+}
+
+func (t *sqlTx) StakeOfferList(tournamentID int) ([]core.StakeOffer, error) {
+	return StakeOfferList(t.run, tournamentID)
+}
+func (t *sqlTx) StakeOfferGet(offerID int64) (*core.StakeOffer, error) {
+	return StakeOfferGet(t.run, offerID)
+}
+func (t *sqlTx) StakeOfferGetForUpdate(offerID int64) (*core.StakeOffer, error) {
+	return StakeOfferGetForUpdate(t.run, offerID)
+}
+func (t *sqlTx) StakeOfferInsert(o *core.StakeOffer) error { return StakeOfferInsert(t.run, o) }
+func (t *sqlTx) StakeOfferUpdate(o *core.StakeOffer) error { return StakeOfferUpdate(t.run, o) }
+
+func (t *sqlTx) IdempotencyKeyGet(client, key string) (*core.IdempotencyKey, error) {
+	return IdempotencyKeyGet(t.run, client, key)
+}
+func (t *sqlTx) IdempotencyKeyInsert(k *core.IdempotencyKey) error {
+	return IdempotencyKeyInsert(t.run, k)
+}
+func (t *sqlTx) IdempotencyKeyUpdate(k *core.IdempotencyKey) error {
+	return IdempotencyKeyUpdate(t.run, k)
+}
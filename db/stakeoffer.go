@@ -0,0 +1,97 @@
+package db
+
+import (
+	"github.com/20170819lgg/sts/core"
+	"github.com/Masterminds/squirrel"
+)
+
+func stakeOfferSelect(q squirrel.Queryer, d queryDecorator) ([]core.StakeOffer, error) {
+	query := d(squirrel.
+		Select("stake_offer_id", "tournament_id", "player_id", "available", "markup").
+		From("stake_offer"))
+
+	rows, err := squirrel.QueryWith(q, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var offers []core.StakeOffer
+	for rows.Next() {
+		var o core.StakeOffer
+		if err := rows.Scan(&o.ID, &o.TournamentID, &o.PlayerID, &o.Available, &o.Markup); err != nil {
+			return nil, err
+		}
+		offers = append(offers, o)
+	}
+	return offers, nil
+}
+
+// StakeOfferList returns stake offers of a tournament which are still
+// available.
+func StakeOfferList(q squirrel.Queryer, tournamentID int) ([]core.StakeOffer, error) {
+	return stakeOfferSelect(q, func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
+		return b.
+			Where("tournament_id = ? AND available > 0", tournamentID).
+			OrderBy("stake_offer_id")
+	})
+}
+
+func StakeOfferGet(q squirrel.Queryer, offerID int64) (*core.StakeOffer, error) {
+	return stakeOfferGet(q, func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
+		return b.Where("stake_offer_id = ?", offerID)
+	})
+}
+
+func StakeOfferGetForUpdate(q squirrel.Queryer, offerID int64) (*core.StakeOffer, error) {
+	return stakeOfferGet(q, func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
+		return b.Where("stake_offer_id = ?", offerID).Suffix("FOR UPDATE")
+	})
+}
+
+func stakeOfferGet(q squirrel.Queryer, d queryDecorator) (*core.StakeOffer, error) {
+	offers, err := stakeOfferSelect(q, d)
+	switch {
+	case err != nil:
+		return nil, err
+	case len(offers) == 0:
+		return nil, ErrNotFound
+	default:
+		return &offers[0], nil
+	}
+}
+
+// StakeOfferInsert stores new stake offer and sets its ID. Only one offer per
+// tournament player may exist.
+func StakeOfferInsert(e squirrel.Execer, o *core.StakeOffer) error {
+	query := squirrel.
+		Insert("stake_offer").
+		SetMap(map[string]interface{}{
+			"tournament_id": o.TournamentID,
+			"player_id":     o.PlayerID,
+			"available":     o.Available,
+			"markup":        o.Markup,
+		})
+	id, err := insertID(e, query, "stake_offer_id")
+	if isDuplicate(err) {
+		return ErrAlreadyExists
+	}
+	if err != nil {
+		return err
+	}
+	o.ID = id
+	return nil
+}
+
+func StakeOfferUpdate(e squirrel.Execer, o *core.StakeOffer) error {
+	query := squirrel.
+		Update("stake_offer").
+		SetMap(map[string]interface{}{
+			"available": o.Available,
+			"markup":    o.Markup,
+		}).
+		Where("stake_offer_id = ?", o.ID)
+
+	_, err := squirrel.ExecWith(e, query)
+	return err
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/20170819lgg/sts/core"
+)
+
+// Store is a transactional storage of the application data.
+type Store interface {
+	// Begin starts a new unit of work bound to the context.
+	Begin(ctx context.Context) (Tx, error)
+
+	// MigrateUp applies all pending schema migrations and returns their
+	// versions.
+	MigrateUp(ctx context.Context) ([]int, error)
+	// MigrateDown reverts the latest applied schema migration and returns
+	// its version.
+	MigrateDown(ctx context.Context) (int, error)
+	// MigrateStatus lists all known schema migrations.
+	MigrateStatus(ctx context.Context) ([]MigrationStatus, error)
+
+	// Reset drops all stored data and recreates the schema.
+	Reset(ctx context.Context) error
+	Close() error
+}
+
+// Tx is a unit of work. Changes made in a transaction are visible to other
+// transactions only after it is committed. Records returned by ForUpdate
+// methods are locked until the transaction ends. Rollback of already
+// committed transaction does nothing, so it can be always deferred.
+// Transaction is rolled back when its context is done, its methods then fail
+// with the context error.
+type Tx interface {
+	Commit() error
+	Rollback() error
+
+	PlayerRepository
+	TournamentRepository
+	TournPlayerRepository
+	TournamentWinnerRepository
+	LedgerRepository
+	BackingRequestRepository
+	StakeOfferRepository
+	IdempotencyKeyRepository
+}
+
+type PlayerRepository interface {
+	PlayerList() ([]core.Player, error)
+	PlayerGet(playerID string) (*core.Player, error)
+	PlayerGetForUpdate(playerID string) (*core.Player, error)
+	// PlayerSelectForUpdate locks all given players at once, missing
+	// players are not included in the result.
+	PlayerSelectForUpdate(playerIDs []string) (map[string]*core.Player, error)
+	PlayerInsert(player *core.Player) error
+	PlayerUpdate(player *core.Player) error
+}
+
+type TournamentRepository interface {
+	TournamentList() ([]core.Tournament, error)
+	TournamentGet(tournamentID int) (*core.Tournament, error)
+	TournamentGetForUpdate(tournamentID int) (*core.Tournament, error)
+	TournamentInsert(t *core.Tournament) error
+	TournamentUpdate(t *core.Tournament) error
+}
+
+type TournPlayerRepository interface {
+	TournPlayerGet(tournamentID int, playerID string) (*core.TournPlayer, error)
+	TournPlayerGetForUpdate(tournamentID int, playerID string) (*core.TournPlayer, error)
+	TournPlayerList(tournamentID int) ([]core.TournPlayer, error)
+	TournPlayerInsert(tp *core.TournPlayer) error
+	TournPlayerUpdate(tp *core.TournPlayer) error
+	TournPlayerDelete(tp *core.TournPlayer) error
+}
+
+type TournamentWinnerRepository interface {
+	TournamentWinnerList() ([]core.TournWinner, error)
+	TournamentWinnerInsert(tw *core.TournWinner) error
+}
+
+type LedgerRepository interface {
+	LedgerEntryInsert(entry *core.LedgerEntry) error
+	LedgerSumsGet() (*core.LedgerSums, error)
+	TransferLogSelect(f TransferFilter) ([]core.Transfer, error)
+	BackingList(playerID string, from, to time.Time) ([]core.Backing, error)
+}
+
+type BackingRequestRepository interface {
+	BackingRequestGet(requestID int64) (*core.BackingRequest, error)
+	BackingRequestGetForUpdate(requestID int64) (*core.BackingRequest, error)
+	BackingRequestListPending(playerID string) ([]core.BackingRequest, error)
+	BackingRequestInsert(r *core.BackingRequest) error
+	BackingRequestUpdate(r *core.BackingRequest) error
+	BackingRequestExpire(tournamentID int) error
+}
+
+type StakeOfferRepository interface {
+	StakeOfferList(tournamentID int) ([]core.StakeOffer, error)
+	StakeOfferGet(offerID int64) (*core.StakeOffer, error)
+	StakeOfferGetForUpdate(offerID int64) (*core.StakeOffer, error)
+	StakeOfferInsert(o *core.StakeOffer) error
+	StakeOfferUpdate(o *core.StakeOffer) error
+}
+
+type IdempotencyKeyRepository interface {
+	IdempotencyKeyGet(client, key string) (*core.IdempotencyKey, error)
+	// IdempotencyKeyInsert reserves the key, it blocks while other
+	// transaction holding the same key is in progress.
+	IdempotencyKeyInsert(k *core.IdempotencyKey) error
+	IdempotencyKeyUpdate(k *core.IdempotencyKey) error
+}
+
+// memoryScheme is DSN prefix selecting the in-memory store.
+const memoryScheme = "memory://"
+
+// Open connects to the store given by DSN. DSN starting with memory://
+// selects a new empty in-memory store, postgres:// or postgresql:// selects
+// PostgreSQL, sqlite:// selects SQLite database file and any other DSN is a
+// MySQL one.
+func Open(dsn string) (Store, error) {
+	if strings.HasPrefix(dsn, memoryScheme) {
+		return NewMemoryStore(), nil
+	}
+	dbh, err := Connect(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return NewSQLStore(dbh, dsn), nil
+}
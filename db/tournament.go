@@ -1,14 +1,16 @@
 package db
 
 import (
+	"database/sql"
+	"encoding/json"
+
 	"github.com/20170819lgg/sts/core"
 	"github.com/Masterminds/squirrel"
-	"github.com/go-sql-driver/mysql"
 )
 
 func tournamentSelect(q squirrel.Queryer, d queryDecorator) ([]core.Tournament, error) {
 	query := d(squirrel.
-		Select("tournament_id", "entry_deposit", "active").
+		Select("tournament_id", "entry_deposit", "state", "pot", "rake_percent", "house_fee", "payout").
 		From("tournament"))
 
 	rows, err := squirrel.QueryWith(q, query)
@@ -20,14 +22,27 @@ func tournamentSelect(q squirrel.Queryer, d queryDecorator) ([]core.Tournament,
 	var ts []core.Tournament
 	for rows.Next() {
 		var t core.Tournament
-		if err := rows.Scan(&t.ID, &t.EntryDeposit, &t.Active); err != nil {
+		var payout sql.NullString
+		if err := rows.Scan(&t.ID, &t.EntryDeposit, &t.State, &t.Pot, &t.RakePercent, &t.HouseFee, &payout); err != nil {
 			return nil, err
 		}
+		if payout.Valid {
+			t.Payout = &core.PayoutStructure{}
+			if err := json.Unmarshal([]byte(payout.String), t.Payout); err != nil {
+				return nil, err
+			}
+		}
 		ts = append(ts, t)
 	}
 	return ts, nil
 }
 
+func TournamentList(q squirrel.Queryer) ([]core.Tournament, error) {
+	return tournamentSelect(q, func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
+		return b.OrderBy("tournament_id")
+	})
+}
+
 func TournamentGet(q squirrel.Queryer, tournamentID int) (*core.Tournament, error) {
 	ts, err := tournamentSelect(q, func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
 		return b.Where("tournament_id = ?", tournamentID)
@@ -56,29 +71,58 @@ func TournamentGetForUpdate(q squirrel.Queryer, tournamentID int) (*core.Tournam
 	}
 }
 
+// payoutValue encodes tournament payout structure as JSON, nil is returned
+// for tournaments without payout structure.
+func payoutValue(p *core.PayoutStructure) (interface{}, error) {
+	if p == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
 func TournamentUpdate(e squirrel.Execer, t *core.Tournament) error {
+	payout, err := payoutValue(t.Payout)
+	if err != nil {
+		return err
+	}
 	query := squirrel.
 		Update("tournament").
 		SetMap(map[string]interface{}{
 			"entry_deposit": t.EntryDeposit,
-			"active":        t.Active,
+			"state":         t.State,
+			"pot":           t.Pot,
+			"rake_percent":  t.RakePercent,
+			"house_fee":     t.HouseFee,
+			"payout":        payout,
 		}).
 		Where("tournament_id = ?", t.ID)
 
-	_, err := squirrel.ExecWith(e, query)
+	_, err = squirrel.ExecWith(e, query)
 	return err
 }
 
 func TournamentInsert(e squirrel.Execer, t *core.Tournament) error {
+	payout, err := payoutValue(t.Payout)
+	if err != nil {
+		return err
+	}
 	query := squirrel.
 		Insert("tournament").
 		SetMap(map[string]interface{}{
 			"tournament_id": t.ID,
 			"entry_deposit": t.EntryDeposit,
-			"active":        t.Active,
+			"state":         t.State,
+			"pot":           t.Pot,
+			"rake_percent":  t.RakePercent,
+			"house_fee":     t.HouseFee,
+			"payout":        payout,
 		})
-	_, err := squirrel.ExecWith(e, query)
-	if me, ok := err.(*mysql.MySQLError); ok && me.Number == 1062 {
+	_, err = squirrel.ExecWith(e, query)
+	if isDuplicate(err) {
 		return ErrAlreadyExists
 	}
 	return err
@@ -1,17 +1,19 @@
 package db
 
 import (
-	"encoding/json"
-	"errors"
-
 	"github.com/20170819lgg/sts/core"
 	"github.com/Masterminds/squirrel"
-	"github.com/go-sql-driver/mysql"
 )
 
 func TournPlayerSelect(q squirrel.Queryer, d queryDecorator) ([]core.TournPlayer, error) {
+	return tournPlayerSelect(q, d, false)
+}
+
+// tournPlayerSelect selects tournament players and their backers, both are
+// locked if forUpdate is set.
+func tournPlayerSelect(q squirrel.Queryer, d queryDecorator, forUpdate bool) ([]core.TournPlayer, error) {
 	query := d(squirrel.
-		Select("tournament_id", "player_id", "fee", "data").
+		Select("tournament_id", "player_id", "fee").
 		From("tournament_player"))
 
 	rows, err := squirrel.QueryWith(q, query)
@@ -21,27 +23,51 @@ func TournPlayerSelect(q squirrel.Queryer, d queryDecorator) ([]core.TournPlayer
 	defer rows.Close()
 
 	var tps []core.TournPlayer
+	var keys []backerKey
 	for rows.Next() {
 		var tp core.TournPlayer
-		var blob []byte
-		if err := rows.Scan(&tp.TournamentID, &tp.PlayerID, &tp.Fee, &blob); err != nil {
-			return nil, err
-		}
-		if err := json.Unmarshal(blob, &tp.Backers); err != nil {
+		if err := rows.Scan(&tp.TournamentID, &tp.PlayerID, &tp.Fee); err != nil {
 			return nil, err
 		}
 		tps = append(tps, tp)
+		keys = append(keys, backerKey{tp.TournamentID, tp.PlayerID})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	backers, err := backersSelect(q, "tournament_player_backer", keys, forUpdate)
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		tps[i].Backers = backers[k]
 	}
 	return tps, nil
 }
 
 func TournPlayerGet(q squirrel.Queryer, tournamentID int, playerID string) (*core.TournPlayer, error) {
-	tps, err := TournPlayerSelect(q, func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
-		return b.Where(squirrel.Eq{
+	return tournPlayerGet(q, tournamentID, playerID, false)
+}
+
+// TournPlayerGetForUpdate returns tournament player and locks it together
+// with its backers until the end of transaction.
+func TournPlayerGetForUpdate(q squirrel.Queryer, tournamentID int, playerID string) (*core.TournPlayer, error) {
+	return tournPlayerGet(q, tournamentID, playerID, true)
+}
+
+func tournPlayerGet(q squirrel.Queryer, tournamentID int, playerID string, forUpdate bool) (*core.TournPlayer, error) {
+	tps, err := tournPlayerSelect(q, func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
+		b = b.Where(squirrel.Eq{
 			"tournament_id": tournamentID,
 			"player_id":     playerID,
 		})
-	})
+		if forUpdate {
+			b = b.Suffix("FOR UPDATE")
+		}
+		return b
+	}, forUpdate)
 
 	switch {
 	case err != nil:
@@ -53,27 +79,59 @@ func TournPlayerGet(q squirrel.Queryer, tournamentID int, playerID string) (*cor
 	}
 }
 
+func TournPlayerList(q squirrel.Queryer, tournamentID int) ([]core.TournPlayer, error) {
+	return TournPlayerSelect(q, func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
+		return b.
+			Where("tournament_id = ?", tournamentID).
+			OrderBy("player_id")
+	})
+}
+
 func TournPlayerInsert(e squirrel.Execer, tp *core.TournPlayer) error {
-	blob, err := json.Marshal(&tp.Backers)
+	query := squirrel.
+		Insert("tournament_player").
+		SetMap(map[string]interface{}{
+			"tournament_id": tp.TournamentID,
+			"player_id":     tp.PlayerID,
+			"fee":           tp.Fee,
+		})
+	_, err := squirrel.ExecWith(e, query)
+	if isDuplicate(err) {
+		return ErrAlreadyExists
+	}
 	if err != nil {
 		return err
 	}
+	return backersInsert(e, "tournament_player_backer", tp.TournamentID, tp.PlayerID, tp.Backers)
+}
 
-	if len(blob) > TextMaxLength {
-		return errors.New("db: backers slice is too big")
+func TournPlayerUpdate(e squirrel.Execer, tp *core.TournPlayer) error {
+	query := squirrel.
+		Update("tournament_player").
+		Set("fee", tp.Fee).
+		Where(squirrel.Eq{
+			"tournament_id": tp.TournamentID,
+			"player_id":     tp.PlayerID,
+		})
+	if _, err := squirrel.ExecWith(e, query); err != nil {
+		return err
+	}
+	if err := backersDelete(e, "tournament_player_backer", tp.TournamentID, tp.PlayerID); err != nil {
+		return err
 	}
+	return backersInsert(e, "tournament_player_backer", tp.TournamentID, tp.PlayerID, tp.Backers)
+}
 
+func TournPlayerDelete(e squirrel.Execer, tp *core.TournPlayer) error {
+	if err := backersDelete(e, "tournament_player_backer", tp.TournamentID, tp.PlayerID); err != nil {
+		return err
+	}
 	query := squirrel.
-		Insert("tournament_player").
-		SetMap(map[string]interface{}{
+		Delete("tournament_player").
+		Where(squirrel.Eq{
 			"tournament_id": tp.TournamentID,
 			"player_id":     tp.PlayerID,
-			"fee":           tp.Fee,
-			"data":          blob,
 		})
-	_, err = squirrel.ExecWith(e, query)
-	if me, ok := err.(*mysql.MySQLError); ok && me.Number == 1062 {
-		return ErrAlreadyExists
-	}
+	_, err := squirrel.ExecWith(e, query)
 	return err
 }
@@ -1,35 +1,66 @@
 package db
 
 import (
-	"encoding/json"
-	"errors"
-
 	"github.com/20170819lgg/sts/core"
 	"github.com/Masterminds/squirrel"
-	"github.com/go-sql-driver/mysql"
 )
 
-func TournamentWinnerInsert(e squirrel.Execer, tp *core.TournWinner) error {
-	blob, err := json.Marshal(&tp.Backers)
+func TournamentWinnerSelect(q squirrel.Queryer, d queryDecorator) ([]core.TournWinner, error) {
+	query := d(squirrel.
+		Select("tournament_id", "player_id", "prize").
+		From("tournament_winner"))
+
+	rows, err := squirrel.QueryWith(q, query)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	if len(blob) > TextMaxLength {
-		return errors.New("db: backers slice is too big")
+	var tws []core.TournWinner
+	var keys []backerKey
+	for rows.Next() {
+		var tw core.TournWinner
+		if err := rows.Scan(&tw.TournamentID, &tw.PlayerID, &tw.Prize); err != nil {
+			return nil, err
+		}
+		tws = append(tws, tw)
+		keys = append(keys, backerKey{tw.TournamentID, tw.PlayerID})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	backers, err := backersSelect(q, "tournament_winner_backer", keys, false)
+	if err != nil {
+		return nil, err
 	}
+	for i, k := range keys {
+		tws[i].Backers = backers[k]
+	}
+	return tws, nil
+}
+
+func TournamentWinnerList(q squirrel.Queryer) ([]core.TournWinner, error) {
+	return TournamentWinnerSelect(q, func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
+		return b.OrderBy("tournament_id", "player_id")
+	})
+}
 
+func TournamentWinnerInsert(e squirrel.Execer, tp *core.TournWinner) error {
 	query := squirrel.
 		Insert("tournament_winner").
 		SetMap(map[string]interface{}{
 			"tournament_id": tp.TournamentID,
 			"player_id":     tp.PlayerID,
 			"prize":         tp.Prize,
-			"data":          blob,
 		})
-	_, err = squirrel.ExecWith(e, query)
-	if me, ok := err.(*mysql.MySQLError); ok && me.Number == 1062 {
+	_, err := squirrel.ExecWith(e, query)
+	if isDuplicate(err) {
 		return ErrAlreadyExists
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	return backersInsert(e, "tournament_winner_backer", tp.TournamentID, tp.PlayerID, tp.Backers)
 }
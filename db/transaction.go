@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"math/rand"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// ErrRollback is returned by transaction body to roll back the transaction
+// without failing it.
+var ErrRollback = errors.New("db: rollback")
+
+// Transactions failed by deadlock or serialization failure are retried at
+// most maxTransactionAttempts times in total. Retries wait for a random time
+// up to exponentially growing backoff, so concurrent transactions conflicting
+// with each other do not collide again.
+const (
+	maxTransactionAttempts = 5
+	retryBackoffBase       = 10 * time.Millisecond
+	retryBackoffMax        = time.Second
+)
+
+// TransactionMetrics counts retried transactions. It is published by expvar
+// as db.transactions with these counters:
+//
+//	retries    transaction attempts repeated after retryable error
+//	recovered  transactions succeeded after being retried
+//	exhausted  transactions failed after maxTransactionAttempts attempts
+var TransactionMetrics = expvar.NewMap("db.transactions")
+
+// Transaction runs body in a store transaction and commits it if body
+// succeeds. The whole transaction is run again if it fails with retryable
+// error, so body must not have other side effects than changes made through
+// the transaction. Transaction is rolled back and not retried once the
+// context is done.
+func Transaction(ctx context.Context, store Store, body func(Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		err := runTransaction(ctx, store, body)
+		switch {
+		case err == nil:
+			if attempt > 1 {
+				TransactionMetrics.Add("recovered", 1)
+			}
+			return nil
+		case ctx.Err() != nil, !isRetryable(pkgerrors.Cause(err)):
+			return err
+		case attempt == maxTransactionAttempts:
+			TransactionMetrics.Add("exhausted", 1)
+			return err
+		}
+		TransactionMetrics.Add("retries", 1)
+		select {
+		case <-time.After(retryBackoff(attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// runTransaction runs a single attempt of the transaction.
+func runTransaction(ctx context.Context, store Store, body func(Tx) error) error {
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		return pkgerrors.WithMessage(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	switch err := body(tx); err {
+	case nil:
+		// OK
+	case ErrRollback:
+		return nil
+	default:
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return pkgerrors.WithMessage(err, "committing transaction")
+	}
+	return nil
+}
+
+// retryBackoff returns random time to wait before the next attempt.
+func retryBackoff(attempt int) time.Duration {
+	backoff := retryBackoffBase << uint(attempt-1)
+	if backoff > retryBackoffMax {
+		backoff = retryBackoffMax
+	}
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+// sqlTransaction runs body in a database transaction, it is used by schema
+// migrations working with the database directly.
+func sqlTransaction(ctx context.Context, db *sql.DB, body func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := body(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/20170819lgg/sts/core"
+	"github.com/Masterminds/squirrel"
+)
+
+// transferLogSelect is generic function for querying transfer_log table.
+func transferLogSelect(q squirrel.Queryer, d queryDecorator) ([]core.Transfer, error) {
+	query := d(squirrel.
+		Select("transfer_log_id", "ledger_entry_id", "tstamp", "op", "account", "player_id", "points", "tournament_id", "backed_player_id").
+		From("transfer_log"))
+
+	rows, err := squirrel.QueryWith(q, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ts []core.Transfer
+	for rows.Next() {
+		var t core.Transfer
+		var op, account []byte
+		var playerID, backedPlayerID sql.NullString
+		var tournamentID sql.NullInt64
+		if err := rows.Scan(&t.ID, &t.EntryID, &t.Time, &op, &account, &playerID, &t.Points, &tournamentID, &backedPlayerID); err != nil {
+			return nil, err
+		}
+		if len(op) != 1 {
+			return nil, core.ErrInvalidTransferOp
+		}
+		if len(account) != 1 {
+			return nil, core.ErrInvalidAccountKind
+		}
+		t.Op = core.TransferOp(op[0])
+		t.Account = core.AccountKind(account[0])
+		t.PlayerID = playerID.String
+		t.TournamentID = int(tournamentID.Int64)
+		t.BackedPlayerID = backedPlayerID.String
+		ts = append(ts, t)
+	}
+	return ts, rows.Err()
+}
+
+// TransferFilter describes which transfer log records should be returned by
+// TransferLogSelect. Zero valued fields do not restrict the result. Records
+// are returned ordered from the newest to the oldest one.
+type TransferFilter struct {
+	PlayerID     string
+	Ops          []core.TransferOp
+	TournamentID int
+	From         time.Time // inclusive
+	To           time.Time // exclusive
+	BeforeID     int64     // only records older than the given one
+	Limit        uint64
+}
+
+func TransferLogSelect(q squirrel.Queryer, f TransferFilter) ([]core.Transfer, error) {
+	return transferLogSelect(q, func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
+		if f.PlayerID != "" {
+			b = b.Where("player_id = ?", f.PlayerID)
+		}
+		if len(f.Ops) > 0 {
+			ops := make([][]byte, len(f.Ops))
+			for i, op := range f.Ops {
+				ops[i] = []byte{byte(op)}
+			}
+			b = b.Where(squirrel.Eq{"op": ops})
+		}
+		if f.TournamentID != 0 {
+			b = b.Where("tournament_id = ?", f.TournamentID)
+		}
+		if !f.From.IsZero() {
+			b = b.Where("tstamp >= ?", f.From)
+		}
+		if !f.To.IsZero() {
+			b = b.Where("tstamp < ?", f.To)
+		}
+		if f.BeforeID != 0 {
+			b = b.Where("transfer_log_id < ?", f.BeforeID)
+		}
+		if f.Limit != 0 {
+			b = b.Limit(f.Limit)
+		}
+		return b.OrderBy("transfer_log_id DESC")
+	})
+}
+
+// stakeOps are operations of transfers paying for stakes of tournament
+// players.
+var stakeOps = [][]byte{
+	{byte(core.TransferDeposit)},
+	{byte(core.TransferRefund)},
+	{byte(core.TransferStakeSale)},
+	{byte(core.TransferStakeRefund)},
+}
+
+// BackingList lists tournament players backed by the player, including its
+// own tournament entries, from tournament backer tables. Stake is the net
+// amount the player paid for its stake by deposits, refunds and stake trades,
+// payout is its share of the tournament player's prize. Only entries made
+// within the optional from (inclusive) and to (exclusive) range are included,
+// entries are always included as a whole.
+func BackingList(q squirrel.Queryer, playerID string, from, to time.Time) ([]core.Backing, error) {
+	stake, stakeArgs, err := squirrel.
+		Select("COALESCE(-SUM(tl.points), 0)").
+		From("transfer_log tl").
+		Where("tl.tournament_id = tpb.tournament_id AND tl.backed_player_id = tpb.player_id AND tl.player_id = tpb.backer_id").
+		Where(squirrel.Eq{"tl.account": []byte{byte(core.AccountPlayer)}}).
+		Where(squirrel.Eq{"tl.op": stakeOps}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	// entry time is the time of the latest deposit, players rejoining
+	// after withdrawal make a new entry
+	entered := func(cmp string, t time.Time) squirrel.Sqlizer {
+		return squirrel.Expr("(SELECT MAX(tl.tstamp) FROM transfer_log tl "+
+			"WHERE tl.tournament_id = tpb.tournament_id AND tl.backed_player_id = tpb.player_id AND tl.op = ?) "+cmp+" ?",
+			[]byte{byte(core.TransferDeposit)}, t)
+	}
+
+	query := squirrel.
+		Select("tpb.tournament_id", "tpb.player_id", "t.state").
+		Column(squirrel.Expr("("+stake+")", stakeArgs...)).
+		Column("COALESCE((SELECT SUM(twb.points) FROM tournament_winner_backer twb "+
+			"WHERE twb.tournament_id = tpb.tournament_id AND twb.player_id = tpb.player_id AND twb.backer_id = tpb.backer_id), 0)").
+		From("tournament_player_backer tpb").
+		Join("tournament t ON t.tournament_id = tpb.tournament_id").
+		Where("tpb.backer_id = ?", playerID).
+		OrderBy("tpb.tournament_id", "tpb.player_id")
+	if !from.IsZero() {
+		query = query.Where(entered(">=", from))
+	}
+	if !to.IsZero() {
+		query = query.Where(entered("<", to))
+	}
+
+	rows, err := squirrel.QueryWith(q, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bs []core.Backing
+	for rows.Next() {
+		var b core.Backing
+		if err := rows.Scan(&b.TournamentID, &b.PlayerID, &b.State, &b.Stake, &b.Payout); err != nil {
+			return nil, err
+		}
+		b.Net = b.Payout - b.Stake
+		bs = append(bs, b)
+	}
+	return bs, rows.Err()
+}
+
+// LedgerEntryInsert validates and stores ledger entry together with all its
+// transfers. Entry and transfer IDs are not updated.
+func LedgerEntryInsert(e squirrel.Execer, entry *core.LedgerEntry) error {
+	if err := entry.Validate(); err != nil {
+		return err
+	}
+
+	entryID, err := insertID(e, squirrel.
+		Insert("ledger_entry").
+		SetMap(map[string]interface{}{
+			"op": []byte{byte(entry.Op)},
+		}), "ledger_entry_id")
+	if err != nil {
+		return err
+	}
+	if len(entry.Transfers) == 0 {
+		return nil
+	}
+
+	query := squirrel.
+		Insert("transfer_log").
+		Columns("ledger_entry_id", "op", "account", "player_id", "points", "tournament_id", "backed_player_id")
+	for _, t := range entry.Transfers {
+		playerID := sql.NullString{
+			String: t.PlayerID,
+			Valid:  t.PlayerID != "",
+		}
+		tournamentID := sql.NullInt64{
+			Int64: int64(t.TournamentID),
+			Valid: t.TournamentID != 0,
+		}
+		backedPlayerID := sql.NullString{
+			String: t.BackedPlayerID,
+			Valid:  t.BackedPlayerID != "",
+		}
+		query = query.Values(entryID, []byte{byte(t.Op)}, []byte{byte(t.Account)}, playerID, t.Points, tournamentID, backedPlayerID)
+	}
+	_, err = squirrel.ExecWith(e, query)
+	return err
+}
+
+// LedgerSumsGet aggregates all transfer log records per account.
+func LedgerSumsGet(q squirrel.Queryer) (*core.LedgerSums, error) {
+	sums := &core.LedgerSums{
+		Players:    make(map[string]int64),
+		Pots:       make(map[int]int64),
+		Unbalanced: make(map[int64]int64),
+		Prizes:     make(map[core.PrizeKey]int64),
+	}
+
+	rows, err := squirrel.QueryWith(q, squirrel.
+		Select("account", "COALESCE(player_id, '')", "COALESCE(tournament_id, 0)", "SUM(points)").
+		From("transfer_log").
+		GroupBy("account", "player_id", "tournament_id"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var account []byte
+		var playerID string
+		var tournamentID int
+		var sum int64
+		if err := rows.Scan(&account, &playerID, &tournamentID, &sum); err != nil {
+			return nil, err
+		}
+		if len(account) != 1 {
+			return nil, core.ErrInvalidAccountKind
+		}
+		switch core.AccountKind(account[0]) {
+		case core.AccountPlayer:
+			sums.Players[playerID] += sum
+		case core.AccountPot:
+			sums.Pots[tournamentID] += sum
+		case core.AccountHouse:
+			sums.House += sum
+		default:
+			return nil, core.ErrInvalidAccountKind
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = squirrel.QueryWith(q, squirrel.
+		Select("ledger_entry_id", "SUM(points)").
+		From("transfer_log").
+		GroupBy("ledger_entry_id").
+		Having("SUM(points) <> 0"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entryID, sum int64
+		if err := rows.Scan(&entryID, &sum); err != nil {
+			return nil, err
+		}
+		sums.Unbalanced[entryID] = sum
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = squirrel.QueryWith(q, squirrel.
+		Select("tournament_id", "backed_player_id", "player_id", "SUM(points)").
+		From("transfer_log").
+		Where(squirrel.Eq{
+			"account": []byte{byte(core.AccountPlayer)},
+			"op":      []byte{byte(core.TransferPrize)},
+		}).
+		GroupBy("tournament_id", "backed_player_id", "player_id"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k core.PrizeKey
+		var sum int64
+		if err := rows.Scan(&k.TournamentID, &k.PlayerID, &k.BackerID, &sum); err != nil {
+			return nil, err
+		}
+		sums.Prizes[k] = sum
+	}
+	return sums, rows.Err()
+}
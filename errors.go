@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/20170819lgg/sts/core"
+	"github.com/20170819lgg/sts/db"
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+)
+
+const maxRequestIDLength = 64
+
+// apiError is the JSON body of error responses. Code is a stable machine
+// readable error code, Message describes the error to humans and may change.
+type apiError struct {
+	Code      string `json:"code"`
+	Status    int    `json:"status"`
+	Message   string `json:"message"`
+	Details   string `json:"details,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+func (e *apiError) Error() string { return e.Message }
+
+// errorKind is a code and HTTP status of an error.
+type errorKind struct {
+	code   string
+	status int
+}
+
+// errorKinds maps core and db sentinel errors to error codes. Missing
+// resources get 404, requests conflicting with the current state of
+// resources get 409 and requests which can never succeed get 422.
+var errorKinds = map[error]errorKind{
+	core.ErrPlayerNotFound:           {"PLAYER_NOT_FOUND", http.StatusNotFound},
+	core.ErrTournamentNotFound:       {"TOURNAMENT_NOT_FOUND", http.StatusNotFound},
+	core.ErrTournPlayerNotFound:      {"TOURNAMENT_PLAYER_NOT_FOUND", http.StatusNotFound},
+	core.ErrNegativePlayerBalance:    {"NEGATIVE_BALANCE", http.StatusConflict},
+	core.ErrDuplicateTournament:      {"DUPLICATE_TOURNAMENT", http.StatusConflict},
+	core.ErrDuplicateTournPlayer:     {"DUPLICATE_TOURNAMENT_PLAYER", http.StatusConflict},
+	core.ErrTournamentFinished:       {"TOURNAMENT_FINISHED", http.StatusConflict},
+	core.ErrTournamentCancelled:      {"TOURNAMENT_CANCELLED", http.StatusConflict},
+	core.ErrRegistrationClosed:       {"REGISTRATION_CLOSED", http.StatusConflict},
+	core.ErrInvalidTournamentState:   {"INVALID_TOURNAMENT_STATE", http.StatusConflict},
+	core.ErrInvalidTournamentDeposit: {"INVALID_TOURNAMENT_DEPOSIT", http.StatusUnprocessableEntity},
+	core.ErrInvalidTournamentPrize:   {"INVALID_TOURNAMENT_PRIZE", http.StatusUnprocessableEntity},
+	core.ErrTooManyBackers:           {"TOO_MANY_BACKERS", http.StatusUnprocessableEntity},
+	core.ErrInvalidRake:              {"INVALID_RAKE", http.StatusUnprocessableEntity},
+	core.ErrPrizesExceedPool:         {"PRIZES_EXCEED_POOL", http.StatusConflict},
+	core.ErrInvalidPayoutStructure:   {"INVALID_PAYOUT_STRUCTURE", http.StatusUnprocessableEntity},
+	core.ErrUnknownPayoutTemplate:    {"UNKNOWN_PAYOUT_TEMPLATE", http.StatusUnprocessableEntity},
+	core.ErrNoPayoutStructure:        {"NO_PAYOUT_STRUCTURE", http.StatusConflict},
+	core.ErrMissingPlaces:            {"MISSING_PLACES", http.StatusUnprocessableEntity},
+	core.ErrDuplicatePlaces:          {"DUPLICATE_PLACES", http.StatusUnprocessableEntity},
+	core.ErrEmptyPlace:               {"EMPTY_PLACE", http.StatusUnprocessableEntity},
+	core.ErrDuplicateBackers:         {"DUPLICATE_BACKERS", http.StatusUnprocessableEntity},
+	core.ErrBackingRequestNotFound:   {"BACKING_REQUEST_NOT_FOUND", http.StatusNotFound},
+	core.ErrBackingRequestClosed:     {"BACKING_REQUEST_CLOSED", http.StatusConflict},
+	core.ErrBackingRequestExpired:    {"BACKING_REQUEST_EXPIRED", http.StatusConflict},
+	core.ErrBackerNotFound:           {"BACKER_NOT_FOUND", http.StatusUnprocessableEntity},
+	core.ErrBackingAlreadyAccepted:   {"BACKING_ALREADY_ACCEPTED", http.StatusConflict},
+	core.ErrStakingClosed:            {"STAKING_CLOSED", http.StatusConflict},
+	core.ErrInvalidStakeOffer:        {"INVALID_STAKE_OFFER", http.StatusUnprocessableEntity},
+	core.ErrInvalidStakePurchase:     {"INVALID_STAKE_PURCHASE", http.StatusUnprocessableEntity},
+	core.ErrStakeOfferExceeded:       {"STAKE_OFFER_EXCEEDED", http.StatusConflict},
+	core.ErrStakeOfferNotFound:       {"STAKE_OFFER_NOT_FOUND", http.StatusNotFound},
+	core.ErrDuplicateStakeOffer:      {"DUPLICATE_STAKE_OFFER", http.StatusConflict},
+	core.ErrInvalidStakes:            {"INVALID_STAKES", http.StatusUnprocessableEntity},
+	core.ErrInvalidTransferOp:        {"INVALID_TRANSFER_OP", http.StatusUnprocessableEntity},
+	core.ErrInvalidAccountKind:       {"INVALID_ACCOUNT_KIND", http.StatusUnprocessableEntity},
+	core.ErrUnbalancedLedgerEntry:    {"UNBALANCED_LEDGER_ENTRY", http.StatusInternalServerError},
+	core.ErrNegativePotBalance:       {"NEGATIVE_POT_BALANCE", http.StatusConflict},
+	core.ErrIdempotencyKeyReused:     {"IDEMPOTENCY_KEY_REUSED", http.StatusConflict},
+	db.ErrNotFound:                   {"NOT_FOUND", http.StatusNotFound},
+	db.ErrAlreadyExists:              {"ALREADY_EXISTS", http.StatusConflict},
+	db.ErrMigrationLocked:            {"MIGRATION_LOCKED", http.StatusServiceUnavailable},
+	db.ErrNoMigration:                {"NO_MIGRATION", http.StatusConflict},
+	db.ErrRollback:                   {"TRANSACTION_ROLLED_BACK", http.StatusInternalServerError},
+}
+
+// Error codes not caused by core and db errors.
+const (
+	codeInvalidRequest = "INVALID_REQUEST"
+	codeInternal       = "INTERNAL_ERROR"
+	codeTimeout        = "REQUEST_TIMEOUT"
+)
+
+// newAPIError converts err to API error. Errors without known cause,
+// including nil error, are internal errors and their messages are not
+// revealed to clients.
+func newAPIError(err error) *apiError {
+	switch e := errors.Cause(err).(type) {
+	case *apiError:
+		return e
+	case *core.TransitionError:
+		return &apiError{
+			Code:    "ILLEGAL_STATE_TRANSITION",
+			Status:  http.StatusConflict,
+			Message: e.Error(),
+		}
+	}
+	kind, ok := errorKinds[errors.Cause(err)]
+	if !ok {
+		return &apiError{
+			Code:    codeInternal,
+			Status:  http.StatusInternalServerError,
+			Message: "unexpected error",
+		}
+	}
+	return &apiError{
+		Code:    kind.code,
+		Status:  kind.status,
+		Message: errors.Cause(err).Error(),
+	}
+}
+
+// errorStatus returns HTTP status of err.
+func errorStatus(err error) int {
+	return newAPIError(err).Status
+}
+
+// badRequest returns error of malformed request, optional details describe
+// the problem further, e.g. JSON decoding error.
+func badRequest(msg string, details ...string) *apiError {
+	e := &apiError{
+		Code:    codeInvalidRequest,
+		Status:  http.StatusBadRequest,
+		Message: msg,
+	}
+	if len(details) > 0 {
+		e.Details = details[0]
+	}
+	return e
+}
+
+// respondError responds with JSON body of the error.
+func respondError(w http.ResponseWriter, r *http.Request, err error) {
+	e := *newAPIError(err)
+	e.RequestID = requestID(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	if err := json.NewEncoder(w).Encode(e); err != nil {
+		logrus.WithError(err).Error("marshaling JSON error response")
+	}
+}
+
+type requestIDKey struct{}
+
+// requestID returns ID of the request the context belongs to.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID assigns every request an ID returned in X-Request-Id header.
+// ID given by the client in the same header is kept.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if id == "" || len(id) > maxRequestIDLength {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				logrus.WithError(err).Error("generating request ID")
+			}
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-Id", id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
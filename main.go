@@ -1,27 +1,114 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/20170819lgg/sts/core"
 	"github.com/20170819lgg/sts/db"
 	"github.com/Sirupsen/logrus"
 	"github.com/fln/pcors"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/go-zoo/bone"
+	"github.com/pkg/errors"
 	"github.com/vrischmann/envconfig"
 )
 
+const (
+	defaultPageLimit        = 50
+	maxPageLimit            = 500
+	maxIdempotencyKeyLength = 255
+)
+
 // conf strores application configuration, it is controlled via environment
 // variables on application startup.
 var conf struct {
 	Listen string `envconfig:"default=:8080"`
 	DSN    string
+	// RequestTimeout limits processing time of a request, zero disables
+	// the limit. It is overridden for paths starting with prefixes given by
+	// EndpointTimeouts as comma separated prefix=duration pairs, e.g.
+	// /resultTournament=30s,/players=5s.
+	RequestTimeout   time.Duration `envconfig:"default=10s"`
+	EndpointTimeouts []string      `envconfig:"optional"`
+	// ShutdownTimeout limits waiting for running requests on shutdown,
+	// requests still running after it are cancelled.
+	ShutdownTimeout time.Duration `envconfig:"default=30s"`
+	// LegacyAPI enables the query string API preceding /v2 routes.
+	LegacyAPI bool `envconfig:"default=true"`
+	// Auth enables authentication of requests by API keys given as comma
+	// separated key=role or key=player:playerId pairs and by JWTs signed
+	// with JWTSecret.
+	Auth      bool     `envconfig:"default=true"`
+	APIKeys   []string `envconfig:"optional"`
+	JWTSecret string   `envconfig:"optional"`
+	// AutoMigrate applies pending schema migrations on startup, otherwise
+	// startup fails until they are applied by migrate command.
+	AutoMigrate bool `envconfig:"default=false"`
+}
+
+// requestTimeouts limits processing time of requests by their URL path.
+type requestTimeouts struct {
+	def      time.Duration
+	prefixes map[string]time.Duration
+}
+
+// parseRequestTimeouts creates request timeouts from default timeout and
+// prefix=duration pairs.
+func parseRequestTimeouts(def time.Duration, pairs []string) (*requestTimeouts, error) {
+	t := &requestTimeouts{
+		def:      def,
+		prefixes: make(map[string]time.Duration),
+	}
+	for _, pair := range pairs {
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 {
+			return nil, errors.Errorf("invalid endpoint timeout %q", pair)
+		}
+		d, err := time.ParseDuration(pair[i+1:])
+		if err != nil || d < 0 {
+			return nil, errors.Errorf("invalid endpoint timeout %q", pair)
+		}
+		t.prefixes[pair[:i]] = d
+	}
+	return t, nil
+}
+
+// timeout returns timeout of the longest matching path prefix.
+func (t *requestTimeouts) timeout(path string) time.Duration {
+	d, n := t.def, 0
+	for prefix, pd := range t.prefixes {
+		if len(prefix) > n && strings.HasPrefix(path, prefix) {
+			d, n = pd, len(prefix)
+		}
+	}
+	return d
+}
+
+// handler runs h with request context cancelled after the request timeout.
+func (t *requestTimeouts) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d := t.timeout(r.URL.Path); d > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		h.ServeHTTP(w, r)
+	})
 }
 
 func respondJSON(w http.ResponseWriter, data interface{}) {
@@ -31,146 +118,636 @@ func respondJSON(w http.ResponseWriter, data interface{}) {
 	}
 }
 
-func respondStatus(w http.ResponseWriter, r apiResponse) {
-	switch r.status {
+func respondStatus(w http.ResponseWriter, r *http.Request, resp apiResponse) {
+	if resp.replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
+	switch resp.status {
 	case http.StatusNoContent:
-		w.WriteHeader(r.status)
+		w.WriteHeader(resp.status)
+	case http.StatusOK, http.StatusCreated, http.StatusAccepted:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.status)
+		fmt.Fprintln(w, resp.msg)
 	default:
-		http.Error(w, r.msg, r.status)
+		respondError(w, r, resp.err)
+	}
+}
+
+// respondUnexpected responds to request failed by unexpected error. Errors of
+// requests which ran out of time or were cancelled by the client are reported
+// as unavailable service.
+func respondUnexpected(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Err() != nil {
+		respondError(w, r, &apiError{
+			Code:    codeTimeout,
+			Status:  http.StatusServiceUnavailable,
+			Message: "request timed out",
+		})
+		return
+	}
+	respondError(w, r, nil)
+}
+
+// idempotencyKey extracts Idempotency-Key header of the request and computes
+// request fingerprint from its method, path, query and body. Keys of
+// authenticated requests are scoped by role and player ID of the client, so
+// they are never replayed to other clients. Nil key is returned if the header
+// is not set.
+func idempotencyKey(r *http.Request) (*core.IdempotencyKey, error) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		return nil, nil
 	}
+	if len(key) > maxIdempotencyKeyLength {
+		return nil, errors.New("idempotency key is too long")
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var client string
+	if p := principalOf(r.Context()); p != nil {
+		client = fmt.Sprintf("%s:%s", p.role, p.playerID)
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", r.Method, r.URL.Path, r.URL.Query().Encode())
+	h.Write(body)
+	return &core.IdempotencyKey{
+		Client:      client,
+		Key:         key,
+		Fingerprint: hex.EncodeToString(h.Sum(nil)),
+	}, nil
 }
 
-func mainRouter(app *application) http.Handler {
+// mainRouter routes the v2 API and, if legacy is set, the legacy query
+// string API kept for compatibility with existing clients. Requests are
+// authenticated unless auth is nil.
+func mainRouter(app *application, legacy bool, auth *authenticator) http.Handler {
 	mux := bone.New()
+	v2Routes(mux, app)
+	if legacy {
+		legacyRoutes(mux, app)
+	}
+
+	// expvar metrics, e.g. db.transactions retry counters
+	mux.Get("/debug/vars", expvar.Handler())
+
+	var h http.Handler = mux
+	if auth != nil {
+		h = auth.handler(mux)
+	}
+	return withRequestID(h)
+}
 
+// playerTransactionsHandler serves a page of player transaction history.
+func playerTransactionsHandler(app *application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f := db.TransferFilter{
+			PlayerID: bone.GetValue(r, "playerId"),
+			Limit:    defaultPageLimit,
+		}
+		q := r.URL.Query()
+		for _, name := range q["op"] {
+			op, err := core.ParseTransferOp(name)
+			if err != nil {
+				respondError(w, r, badRequest("invalid op parameter"))
+				return
+			}
+			f.Ops = append(f.Ops, op)
+		}
+		if v := q.Get("tournamentId"); v != "" {
+			tournamentID, err := strconv.Atoi(v)
+			if err != nil {
+				respondError(w, r, badRequest("invalid tournamentId parameter"))
+				return
+			}
+			f.TournamentID = tournamentID
+		}
+		if v := q.Get("from"); v != "" {
+			from, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				respondError(w, r, badRequest("invalid from parameter"))
+				return
+			}
+			f.From = from
+		}
+		if v := q.Get("to"); v != "" {
+			to, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				respondError(w, r, badRequest("invalid to parameter"))
+				return
+			}
+			f.To = to
+		}
+		if v := q.Get("limit"); v != "" {
+			limit, err := strconv.ParseUint(v, 10, 64)
+			if err != nil || limit == 0 || limit > maxPageLimit {
+				respondError(w, r, badRequest("invalid limit parameter"))
+				return
+			}
+			f.Limit = limit
+		}
+		if v := q.Get("cursor"); v != "" {
+			beforeID, err := decodeCursor(v)
+			if err != nil {
+				respondError(w, r, badRequest("invalid cursor parameter"))
+				return
+			}
+			f.BeforeID = beforeID
+		}
+
+		page, err := app.playerTransactions(r.Context(), f)
+		if err != nil {
+			logrus.WithField("playerID", f.PlayerID).WithError(err).Error("getting player transactions")
+			respondUnexpected(w, r)
+			return
+		}
+		if page == nil {
+			respondError(w, r, core.ErrPlayerNotFound)
+			return
+		}
+		respondJSON(w, page)
+	}
+}
+
+// playerBackingsHandler serves portfolio of players backed by the player.
+func playerBackingsHandler(app *application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		playerID := bone.GetValue(r, "playerId")
+		q := r.URL.Query()
+		var from, to time.Time
+		if v := q.Get("from"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				respondError(w, r, badRequest("invalid from parameter"))
+				return
+			}
+			from = t
+		}
+		if v := q.Get("to"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				respondError(w, r, badRequest("invalid to parameter"))
+				return
+			}
+			to = t
+		}
+
+		portfolio, err := app.playerBackings(r.Context(), playerID, from, to)
+		if err != nil {
+			logrus.WithField("playerID", playerID).WithError(err).Error("getting player backings")
+			respondUnexpected(w, r)
+			return
+		}
+		if portfolio == nil {
+			respondError(w, r, core.ErrPlayerNotFound)
+			return
+		}
+		respondJSON(w, portfolio)
+	}
+}
+
+// playerBackingRequestsHandler serves pending backing requests of the player.
+func playerBackingRequestsHandler(app *application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		playerID := bone.GetValue(r, "playerId")
+		reqs, err := app.playerBackingRequests(r.Context(), playerID)
+		if err != nil {
+			logrus.WithField("playerID", playerID).WithError(err).Error("getting player backing requests")
+			respondUnexpected(w, r)
+			return
+		}
+		respondJSON(w, reqs)
+	}
+}
+
+// legacyRoutes routes the legacy API, which passes parameters in query string
+// of GET requests.
+func legacyRoutes(mux *bone.Mux, app *application) {
 	mux.GetFunc("/take", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
 		playerID := r.URL.Query().Get("playerId")
 		if playerID == "" {
-			http.Error(w, "missing playerId parameter", http.StatusBadRequest)
+			respondError(w, r, badRequest("missing playerId parameter"))
 			return
 		}
 		points, err := strconv.ParseInt(r.URL.Query().Get("points"), 10, 64)
 		if err != nil || points < 0 {
-			http.Error(w, "invalid points parameter", http.StatusBadRequest)
+			respondError(w, r, badRequest("invalid points parameter"))
 			return
 		}
 
-		resp, err := app.addPoints(playerID, -points)
+		resp, err := app.addPoints(r.Context(), key, nil, playerID, -points)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
 				"playerID": playerID,
 				"points":   points,
 			}).WithError(err).Error("reducing player account balance")
-			http.Error(w, "unexpected error", http.StatusInternalServerError)
+			respondUnexpected(w, r)
 			return
 		}
-		respondStatus(w, *resp)
+		respondStatus(w, r, *resp)
 	})
 
 	mux.GetFunc("/fund", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
 		playerID := r.URL.Query().Get("playerId")
 		if playerID == "" {
-			http.Error(w, "missing playerId parameter", http.StatusBadRequest)
+			respondError(w, r, badRequest("missing playerId parameter"))
 			return
 		}
 		points, err := strconv.ParseInt(r.URL.Query().Get("points"), 10, 64)
 		if err != nil || points < 0 {
-			http.Error(w, "invalid points parameter", http.StatusBadRequest)
+			respondError(w, r, badRequest("invalid points parameter"))
 			return
 		}
 
-		resp, err := app.addPoints(playerID, points)
+		resp, err := app.addPoints(r.Context(), key, nil, playerID, points)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
 				"playerID": playerID,
 				"points":   points,
 			}).WithError(err).Error("increasing player account balance")
-			http.Error(w, "unexpected error", http.StatusInternalServerError)
+			respondUnexpected(w, r)
 			return
 		}
-		respondStatus(w, *resp)
+		respondStatus(w, r, *resp)
 
 	})
 
 	mux.GetFunc("/balance", func(w http.ResponseWriter, r *http.Request) {
 		playerID := r.URL.Query().Get("playerId")
 		if playerID == "" {
-			http.Error(w, "missing playerId parameter", http.StatusBadRequest)
+			respondError(w, r, badRequest("missing playerId parameter"))
 			return
 		}
-		player, err := app.balance(playerID)
+		player, err := app.balance(r.Context(), playerID)
 		if err != nil {
 			logrus.WithField("playerID", playerID).WithError(err).Error("getting player balance")
-			http.Error(w, "unexpected error", http.StatusInternalServerError)
+			respondUnexpected(w, r)
 			return
 		}
 		if player == nil {
-			http.Error(w, "player account not found", http.StatusNotFound)
+			respondError(w, r, core.ErrPlayerNotFound)
 			return
 		}
 		respondJSON(w, player)
 	})
 
+	mux.GetFunc("/players/:playerId/transactions", playerTransactionsHandler(app))
+	mux.GetFunc("/players/:playerId/backings", playerBackingsHandler(app))
+	mux.GetFunc("/players/:playerId/backingRequests", playerBackingRequestsHandler(app))
+
 	mux.GetFunc("/announceTournament", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
 		tournamentID, err := strconv.Atoi(r.URL.Query().Get("tournamentId"))
 		if err != nil {
-			http.Error(w, "invalid tournamentId parameter", http.StatusBadRequest)
+			respondError(w, r, badRequest("invalid tournamentId parameter"))
 			return
 		}
 		deposit, err := strconv.ParseInt(r.URL.Query().Get("deposit"), 10, 64)
 		if err != nil || deposit <= 0 {
-			http.Error(w, "invalid deposit parameter", http.StatusBadRequest)
+			respondError(w, r, badRequest("invalid deposit parameter"))
 			return
 		}
+		opts := announceOptions{OpenRegistration: true}
+		if v := r.URL.Query().Get("rakePercent"); v != "" {
+			if opts.RakePercent, err = strconv.Atoi(v); err != nil {
+				respondError(w, r, badRequest("invalid rakePercent parameter"))
+				return
+			}
+		}
+		if v := r.URL.Query().Get("houseFee"); v != "" {
+			if opts.HouseFee, err = strconv.ParseInt(v, 10, 64); err != nil {
+				respondError(w, r, badRequest("invalid houseFee parameter"))
+				return
+			}
+		}
+		if v := r.URL.Query().Get("payout"); v != "" {
+			opts.Payout = &core.PayoutStructure{}
+			for _, pct := range strings.Split(v, ",") {
+				p, err := strconv.ParseInt(pct, 10, 64)
+				if err != nil {
+					respondError(w, r, badRequest("invalid payout parameter"))
+					return
+				}
+				opts.Payout.Percentages = append(opts.Payout.Percentages, p)
+			}
+		}
+		if v := r.URL.Query().Get("payoutTemplate"); v != "" {
+			if opts.Payout != nil {
+				respondError(w, r, badRequest("payout and payoutTemplate parameters are exclusive"))
+				return
+			}
+			opts.Payout = &core.PayoutStructure{Template: v}
+		}
+		if v := r.URL.Query().Get("openRegistration"); v != "" {
+			if opts.OpenRegistration, err = strconv.ParseBool(v); err != nil {
+				respondError(w, r, badRequest("invalid openRegistration parameter"))
+				return
+			}
+		}
 
-		resp, err := app.announceTournament(tournamentID, deposit)
+		resp, err := app.announceTournament(r.Context(), key, nil, tournamentID, deposit, opts)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
 				"tournamentID": tournamentID,
 				"deposit":      deposit,
+				"rakePercent":  opts.RakePercent,
+				"houseFee":     opts.HouseFee,
+				"payout":       opts.Payout,
 			}).WithError(err).Error("creating tournament")
-			http.Error(w, "unexpected error", http.StatusInternalServerError)
+			respondUnexpected(w, r)
 			return
 		}
-		respondStatus(w, *resp)
+		respondStatus(w, r, *resp)
 	})
 
+	// tournament lifecycle transitions
+	for path, state := range map[string]core.TournamentState{
+		"/openRegistration":  core.TournamentRegistrationOpen,
+		"/closeRegistration": core.TournamentRegistrationClosed,
+		"/startTournament":   core.TournamentRunning,
+	} {
+		state := state
+		mux.GetFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			key, err := idempotencyKey(r)
+			if err != nil {
+				respondError(w, r, badRequest("invalid Idempotency-Key header"))
+				return
+			}
+			tournamentID, err := strconv.Atoi(r.URL.Query().Get("tournamentId"))
+			if err != nil {
+				respondError(w, r, badRequest("invalid tournamentId parameter"))
+				return
+			}
+
+			resp, err := app.transitionTournament(r.Context(), key, nil, tournamentID, state)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"tournamentID": tournamentID,
+					"state":        state,
+				}).WithError(err).Error("changing tournament state")
+				respondUnexpected(w, r)
+				return
+			}
+			respondStatus(w, r, *resp)
+		})
+	}
+
 	mux.GetFunc("/joinTournament", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
 		tournamentID, err := strconv.Atoi(r.URL.Query().Get("tournamentId"))
 		if err != nil {
-			http.Error(w, "invalid tournamentId parameter", http.StatusBadRequest)
+			respondError(w, r, badRequest("invalid tournamentId parameter"))
 			return
 		}
 		playerID := r.URL.Query().Get("playerId")
 		if playerID == "" {
-			http.Error(w, "missing playerId parameter", http.StatusBadRequest)
+			respondError(w, r, badRequest("missing playerId parameter"))
 			return
 		}
 		backerIDs := r.URL.Query()["backerId"]
+		var stakes []int64
+		for _, v := range r.URL.Query()["stake"] {
+			stake, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				respondError(w, r, badRequest("invalid stake parameter"))
+				return
+			}
+			stakes = append(stakes, stake)
+		}
+		if len(stakes) > 0 && len(stakes) != len(backerIDs)+1 {
+			respondError(w, r, badRequest("stake parameter must be given for the player and every backer"))
+			return
+		}
+		unit := stakePoints
+		if v := r.URL.Query().Get("stakeUnit"); v != "" {
+			unit = stakeUnit(v)
+			if unit != stakePoints && unit != stakeBasisPoints {
+				respondError(w, r, badRequest("invalid stakeUnit parameter"))
+				return
+			}
+		}
 
-		resp, err := app.joinTournament(tournamentID, playerID, backerIDs)
+		resp, err := app.joinTournament(r.Context(), key, nil, tournamentID, playerID, backerIDs, stakes, unit)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
 				"tournamentID": tournamentID,
 				"playerID":     playerID,
 				"backedIDs":    backerIDs,
+				"stakes":       stakes,
+				"stakeUnit":    unit,
 			}).WithError(err).Error("joining player to tournament")
-			http.Error(w, "unexpected error", http.StatusInternalServerError)
+			respondUnexpected(w, r)
 			return
 		}
-		respondStatus(w, *resp)
+		respondStatus(w, r, *resp)
 	})
 
+	mux.GetFunc("/leaveTournament", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
+		tournamentID, err := strconv.Atoi(r.URL.Query().Get("tournamentId"))
+		if err != nil {
+			respondError(w, r, badRequest("invalid tournamentId parameter"))
+			return
+		}
+		playerID := r.URL.Query().Get("playerId")
+		if playerID == "" {
+			respondError(w, r, badRequest("missing playerId parameter"))
+			return
+		}
+
+		resp, err := app.leaveTournament(r.Context(), key, tournamentID, playerID)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"tournamentID": tournamentID,
+				"playerID":     playerID,
+			}).WithError(err).Error("withdrawing player from tournament")
+			respondUnexpected(w, r)
+			return
+		}
+		respondStatus(w, r, *resp)
+	})
+
+	mux.GetFunc("/offerStake", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
+		tournamentID, err := strconv.Atoi(r.URL.Query().Get("tournamentId"))
+		if err != nil {
+			respondError(w, r, badRequest("invalid tournamentId parameter"))
+			return
+		}
+		playerID := r.URL.Query().Get("playerId")
+		if playerID == "" {
+			respondError(w, r, badRequest("missing playerId parameter"))
+			return
+		}
+		available, err := strconv.ParseInt(r.URL.Query().Get("available"), 10, 64)
+		if err != nil {
+			respondError(w, r, badRequest("invalid available parameter"))
+			return
+		}
+		markup := int64(10000)
+		if v := r.URL.Query().Get("markup"); v != "" {
+			if markup, err = strconv.ParseInt(v, 10, 64); err != nil {
+				respondError(w, r, badRequest("invalid markup parameter"))
+				return
+			}
+		}
+
+		resp, err := app.offerStake(r.Context(), key, tournamentID, playerID, available, markup)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"tournamentID": tournamentID,
+				"playerID":     playerID,
+				"available":    available,
+				"markup":       markup,
+			}).WithError(err).Error("offering stake")
+			respondUnexpected(w, r)
+			return
+		}
+		respondStatus(w, r, *resp)
+	})
+
+	mux.GetFunc("/stakeOffers", func(w http.ResponseWriter, r *http.Request) {
+		tournamentID, err := strconv.Atoi(r.URL.Query().Get("tournamentId"))
+		if err != nil {
+			respondError(w, r, badRequest("invalid tournamentId parameter"))
+			return
+		}
+
+		offers, err := app.stakeOffers(r.Context(), tournamentID)
+		if err != nil {
+			logrus.WithField("tournamentID", tournamentID).WithError(err).Error("listing stake offers")
+			respondUnexpected(w, r)
+			return
+		}
+		respondJSON(w, offers)
+	})
+
+	mux.GetFunc("/buyStake", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
+		offerID, err := strconv.ParseInt(r.URL.Query().Get("offerId"), 10, 64)
+		if err != nil {
+			respondError(w, r, badRequest("invalid offerId parameter"))
+			return
+		}
+		buyerID := r.URL.Query().Get("buyerId")
+		if buyerID == "" {
+			respondError(w, r, badRequest("missing buyerId parameter"))
+			return
+		}
+		bps, err := strconv.ParseInt(r.URL.Query().Get("bps"), 10, 64)
+		if err != nil || bps <= 0 {
+			respondError(w, r, badRequest("invalid bps parameter"))
+			return
+		}
+
+		resp, err := app.buyStake(r.Context(), key, nil, offerID, buyerID, bps)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"offerID": offerID,
+				"buyerID": buyerID,
+				"bps":     bps,
+			}).WithError(err).Error("buying stake")
+			respondUnexpected(w, r)
+			return
+		}
+		respondStatus(w, r, *resp)
+	})
+
+	// backer responses to backing requests
+	for path, accept := range map[string]bool{
+		"/acceptBacking":  true,
+		"/declineBacking": false,
+	} {
+		accept := accept
+		mux.GetFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			key, err := idempotencyKey(r)
+			if err != nil {
+				respondError(w, r, badRequest("invalid Idempotency-Key header"))
+				return
+			}
+			requestID, err := strconv.ParseInt(r.URL.Query().Get("requestId"), 10, 64)
+			if err != nil {
+				respondError(w, r, badRequest("invalid requestId parameter"))
+				return
+			}
+			backerID := r.URL.Query().Get("backerId")
+			if backerID == "" {
+				respondError(w, r, badRequest("missing backerId parameter"))
+				return
+			}
+
+			resp, err := app.respondBacking(r.Context(), key, nil, requestID, backerID, accept)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"requestID": requestID,
+					"backerID":  backerID,
+					"accept":    accept,
+				}).WithError(err).Error("responding to backing request")
+				respondUnexpected(w, r)
+				return
+			}
+			respondStatus(w, r, *resp)
+		})
+	}
+
 	mux.PostFunc("/resultTournament", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
 		var data struct {
 			ID      int `json:"tournamentId"`
 			Winners []struct {
 				PlayerID string `json:"playerId"`
 				Prize    int64  `json:"prize"`
 			} `json:"winners"`
+			Places  []core.Place `json:"places"`
+			Overlay bool         `json:"overlay"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			respondError(w, r, badRequest("invalid JSON body", err.Error()))
+			return
+		}
+
+		if len(data.Winners) > 0 && len(data.Places) > 0 {
+			respondError(w, r, badRequest("winners and places are exclusive"))
 			return
 		}
 
@@ -179,46 +756,113 @@ func mainRouter(app *application) http.Handler {
 			winners[wn.PlayerID] = wn.Prize
 		}
 
-		resp, err := app.resultTroutnament(data.ID, winners)
+		resp, err := app.resultTroutnament(r.Context(), key, nil, data.ID, winners, data.Places, data.Overlay)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
 				"tournamentID": data.ID,
 				"winners":      winners,
+				"places":       data.Places,
+				"overlay":      data.Overlay,
 			}).WithError(err).Error("resulting tournament")
-			http.Error(w, "unexpected error", http.StatusInternalServerError)
+			respondUnexpected(w, r)
+			return
+		}
+		respondStatus(w, r, *resp)
+	})
+
+	mux.GetFunc("/cancelTournament", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
+		tournamentID, err := strconv.Atoi(r.URL.Query().Get("tournamentId"))
+		if err != nil {
+			respondError(w, r, badRequest("invalid tournamentId parameter"))
+			return
+		}
+
+		resp, err := app.cancelTournament(r.Context(), key, nil, tournamentID)
+		if err != nil {
+			logrus.WithField("tournamentID", tournamentID).WithError(err).Error("cancelling tournament")
+			respondUnexpected(w, r)
 			return
 		}
-		respondStatus(w, *resp)
+		respondStatus(w, r, *resp)
 	})
 
 	mux.GetFunc("/reset", func(w http.ResponseWriter, r *http.Request) {
-		if err := app.reset(conf.DSN); err != nil {
+		if err := app.reset(r.Context()); err != nil {
 			logrus.WithError(err).Error("resetting database")
-			http.Error(w, "unexpected error", http.StatusInternalServerError)
+			respondUnexpected(w, r)
 			return
 		}
-		respondStatus(w, *respOK())
+		respondStatus(w, r, *respOK())
 	})
-	return mux
 }
 
 func main() {
 	if err := envconfig.InitWithPrefix(&conf, "STS"); err != nil {
 		logrus.WithError(err).Fatal("parsing environment variables")
 	}
+	timeouts, err := parseRequestTimeouts(conf.RequestTimeout, conf.EndpointTimeouts)
+	if err != nil {
+		logrus.WithError(err).Fatal("parsing endpoint timeouts")
+	}
+	var auth *authenticator
+	if conf.Auth {
+		if auth, err = newAuthenticator(conf.APIKeys, conf.JWTSecret); err != nil {
+			logrus.WithError(err).Fatal("configuring authentication")
+		}
+	} else {
+		logrus.Warn("authentication is disabled")
+	}
 
 	// Establish main database connection
-	dbh, err := db.Connect(conf.DSN)
+	store, err := db.Open(conf.DSN)
 	if err != nil {
 		logrus.WithField("error", err).Fatal("connecting to DB")
 	}
-	defer dbh.Close()
+	defer store.Close()
+
+	app := newApplication(store)
+
+	// Schema must be up to date unless it is being migrated
+	if len(os.Args) < 2 || os.Args[1] != "migrate" {
+		if conf.AutoMigrate {
+			versions, err := store.MigrateUp(context.Background())
+			if err != nil {
+				logrus.WithError(err).Fatal("migrating DB schema")
+			}
+			for _, v := range versions {
+				logrus.WithField("version", v).Info("applied DB schema migration")
+			}
+		} else {
+			status, err := store.MigrateStatus(context.Background())
+			if err != nil {
+				logrus.WithError(err).Fatal("checking DB schema")
+			}
+			for _, s := range status {
+				if s.AppliedAt == nil {
+					logrus.WithField("version", s.Version).Fatal("DB schema migration is pending, run migrate up or set STS_AUTOMIGRATE=true")
+				}
+			}
+		}
+	}
 
-	app := newApplication(dbh)
+	if len(os.Args) > 1 {
+		code := runCommand(context.Background(), app, os.Args[1:])
+		store.Close()
+		os.Exit(code)
+	}
 
+	// requests are cancelled if they do not finish in time on shutdown
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
 	server := &http.Server{
-		Addr:    conf.Listen,
-		Handler: pcors.Default(mainRouter(app)),
+		Addr:        conf.Listen,
+		Handler:     pcors.Default(timeouts.handler(mainRouter(app, conf.LegacyAPI, auth))),
+		BaseContext: func(net.Listener) context.Context { return requestCtx },
 	}
 	stopped := make(chan struct{})
 	go func() {
@@ -231,8 +875,12 @@ func main() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 	<-c
-	if err := server.Shutdown(context.TODO()); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
 		logrus.WithError(err).Error("calling shutdown on http server")
+		cancelRequests()
+		server.Close()
 	}
 	<-stopped
 	logrus.Info("graceful shutdown complete")
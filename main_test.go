@@ -2,18 +2,31 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"expvar"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/20170819lgg/sts/core"
 	"github.com/20170819lgg/sts/db"
+	"github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/assert"
 
 	dockertest "gopkg.in/ory-am/dockertest.v3"
@@ -25,57 +38,119 @@ const (
 	dbPass = ":secret"
 )
 
+var storeKind = flag.String("store", "mysql", "store used by tests: mysql, postgres, sqlite or memory")
+
 var dbDSN string
 
-func TestMain(m *testing.M) {
-	pool, err := dockertest.NewPool("")
-	if err != nil {
-		log.Fatalf("Could not connect to docker: %s", err)
+// ctx is the context of store and application calls made directly by tests.
+var ctx = context.Background()
+
+// startDB starts database container of the tested store and returns its DSN.
+func startDB(pool *dockertest.Pool) (*dockertest.Resource, string, error) {
+	if *storeKind == "postgres" {
+		resource, err := pool.Run("postgres", "latest", []string{
+			fmt.Sprintf("POSTGRES_PASSWORD=%s", dbPass),
+			fmt.Sprintf("POSTGRES_DB=%s", dbName),
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		dsn := fmt.Sprintf("postgres://postgres:%s@localhost:%s/%s?sslmode=disable",
+			url.QueryEscape(dbPass), resource.GetPort("5432/tcp"), dbName)
+		return resource, dsn, nil
 	}
+
 	resource, err := pool.Run("mariadb", "latest", []string{
 		fmt.Sprintf("MYSQL_ROOT_PASSWORD=%s", dbPass),
 		fmt.Sprintf("MYSQL_DATABASE=%s", dbName),
 	})
 	if err != nil {
-		log.Fatalf("Could not start MariaDB: %s", err)
+		return nil, "", err
+	}
+	dsn := fmt.Sprintf("%s:%s@(localhost:%s)/%s", dbUser, dbPass, resource.GetPort("3306/tcp"), dbName)
+	return resource, dsn, nil
+}
+
+func TestMain(m *testing.M) {
+	// short tests run against in-memory store without Docker
+	flag.Parse()
+	if testing.Short() || *storeKind == "memory" {
+		os.Exit(m.Run())
+	}
+	// SQLite database file does not need Docker either
+	if *storeKind == "sqlite" {
+		dir, err := ioutil.TempDir("", "sts")
+		if err != nil {
+			log.Fatalf("Could not create SQLite directory: %s", err)
+		}
+		dbDSN = "sqlite://" + filepath.Join(dir, "sts.db")
+		conf.DSN = dbDSN
+		code := m.Run()
+		os.RemoveAll(dir)
+		os.Exit(code)
+	}
+
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		log.Fatalf("Could not connect to docker: %s", err)
+	}
+	resource, dsn, err := startDB(pool)
+	if err != nil {
+		log.Fatalf("Could not start %s: %s", *storeKind, err)
 	}
 
-	dbDSN = fmt.Sprintf("%s:%s@(localhost:%s)/%s", dbUser, dbPass, resource.GetPort("3306/tcp"), dbName)
+	dbDSN = dsn
 	conf.DSN = dbDSN
 	if err = pool.Retry(func() error {
-		db, err := sql.Open("mysql", dbDSN)
+		dbh, err := db.Connect(dbDSN)
 		if err != nil {
 			return err
 		}
-		defer db.Close()
-		return db.Ping()
+		defer dbh.Close()
+		return dbh.Ping()
 	}); err != nil {
-		log.Fatalf("Waiting for MariaDB to boot-up: %s", err)
+		log.Fatalf("Waiting for %s to boot-up: %s", *storeKind, err)
 	}
 
 	code := m.Run()
 	if err := pool.Purge(resource); err != nil {
-		log.Fatalf("Cleaning up MariaDB: %s", err)
+		log.Fatalf("Cleaning up %s: %s", *storeKind, err)
 	}
 	os.Exit(code)
 }
 
-func newServer(t *testing.T) (*sql.DB, string, func()) {
-	if err := db.RecreateDB(dbDSN); err != nil {
-		t.Fatal(err)
-	}
-	dbh, err := db.Connect(dbDSN)
-	if err != nil {
-		t.Fatal("connecting to DB")
+func newServer(t *testing.T) (db.Store, string, func()) {
+	var store db.Store
+	if dbDSN == "" {
+		store = db.NewMemoryStore()
+	} else {
+		var err error
+		store, err = db.Open(dbDSN)
+		if err != nil {
+			t.Fatal("connecting to DB")
+		}
+		if err := store.Reset(ctx); err != nil {
+			t.Fatal(err)
+		}
 	}
-	server := httptest.NewServer(mainRouter(newApplication(dbh)))
+	server := httptest.NewServer(mainRouter(newApplication(store), true, nil))
 
-	return dbh, server.URL, func() {
+	return store, server.URL, func() {
 		server.Close()
-		dbh.Close()
+		store.Close()
 	}
 }
 
+// sqlDB returns database handle of SQL store, tests using it are skipped
+// with other stores.
+func sqlDB(t *testing.T, store db.Store) *sql.DB {
+	s, ok := store.(*db.SQLStore)
+	if !ok {
+		t.Skip("SQL store is required")
+	}
+	return s.DB()
+}
+
 func get(url string) (string, int, error) {
 	resp, err := http.Get(url)
 	if err != nil {
@@ -102,6 +177,25 @@ func post(url string, data string) (string, int, error) {
 	return string(body), resp.StatusCode, nil
 }
 
+// joinBacked proposes tournament backing request and accepts it by all
+// backers.
+func joinBacked(t *testing.T, url, path string) {
+	body, status, err := get(url + path)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, status, body)
+
+	var req core.BackingRequest
+	assert.NoError(t, json.Unmarshal([]byte(body), &req))
+	for _, s := range req.Stakes {
+		if s.Accepted {
+			continue
+		}
+		body, status, err := get(fmt.Sprintf("%s/acceptBacking?requestId=%d&backerId=%s", url, req.ID, s.PlayerID))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	}
+}
+
 func TestFundTakeBalanceReset(t *testing.T) {
 	_, url, cleanup := newServer(t)
 	defer cleanup()
@@ -189,7 +283,10 @@ func TestFullUseCase(t *testing.T) {
 		assert.Equal(t, http.StatusNoContent, status, body)
 	})
 	t.Run("join P1 with P2, P3, P4", func(t *testing.T) {
-		body, status, err := get(fmt.Sprintf("%s/joinTournament?tournamentId=1&playerId=P1&backerId=P2&backerId=P3&backerId=P4", url))
+		joinBacked(t, url, "/joinTournament?tournamentId=1&playerId=P1&backerId=P2&backerId=P3&backerId=P4")
+	})
+	t.Run("start T1", func(t *testing.T) {
+		body, status, err := get(fmt.Sprintf("%s/startTournament?tournamentId=1", url))
 		assert.NoError(t, err)
 		assert.Empty(t, body)
 		assert.Equal(t, http.StatusNoContent, status, body)
@@ -303,6 +400,10 @@ func TestResultConcurrency(t *testing.T) {
 			assert.Empty(t, body)
 			assert.Equal(t, http.StatusNoContent, status, body)
 		}
+		body, status, err := get(fmt.Sprintf("%s/startTournament?tournamentId=%d", url, i))
+		assert.NoError(t, err)
+		assert.Empty(t, body)
+		assert.Equal(t, http.StatusNoContent, status, body)
 	}
 
 	var winnersAsc []string
@@ -343,3 +444,1940 @@ func TestResultConcurrency(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestPlayerTransactions(t *testing.T) {
+	_, url, cleanup := newServer(t)
+	defer cleanup()
+
+	for _, path := range []string{
+		"/fund?playerId=P1&points=100",
+		"/take?playerId=P1&points=20",
+		"/fund?playerId=P2&points=100",
+		"/announceTournament?tournamentId=1&deposit=50",
+	} {
+		body, status, err := get(url + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	}
+	joinBacked(t, url, "/joinTournament?tournamentId=1&playerId=P1&backerId=P2")
+
+	type page struct {
+		PlayerID     string `json:"playerId"`
+		Transactions []struct {
+			Op             string `json:"op"`
+			Points         int64  `json:"points"`
+			TournamentID   int    `json:"tournamentId"`
+			BackedPlayerID string `json:"backedPlayerId"`
+		} `json:"transactions"`
+		NextCursor string `json:"nextCursor"`
+	}
+	getPage := func(t *testing.T, path string) page {
+		var p page
+		body, status, err := get(url + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status, body)
+		assert.NoError(t, json.Unmarshal([]byte(body), &p))
+		return p
+	}
+
+	t.Run("unknown player", func(t *testing.T) {
+		body, status, err := get(fmt.Sprintf("%s/players/P9/transactions", url))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, status, body)
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		body, status, err := get(fmt.Sprintf("%s/players/P1/transactions?cursor=xyz", url))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, status, body)
+	})
+
+	t.Run("all P1 transactions", func(t *testing.T) {
+		p := getPage(t, "/players/P1/transactions")
+		assert.Equal(t, "P1", p.PlayerID)
+		assert.Empty(t, p.NextCursor)
+		if assert.Len(t, p.Transactions, 3) {
+			assert.Equal(t, "deposit", p.Transactions[0].Op)
+			assert.Equal(t, int64(-25), p.Transactions[0].Points)
+			assert.Equal(t, 1, p.Transactions[0].TournamentID)
+			assert.Equal(t, "P1", p.Transactions[0].BackedPlayerID)
+			assert.Equal(t, "take", p.Transactions[1].Op)
+			assert.Equal(t, int64(-20), p.Transactions[1].Points)
+			assert.Equal(t, "fund", p.Transactions[2].Op)
+			assert.Equal(t, int64(100), p.Transactions[2].Points)
+		}
+	})
+
+	t.Run("paginated P1 transactions", func(t *testing.T) {
+		p := getPage(t, "/players/P1/transactions?limit=2")
+		assert.Len(t, p.Transactions, 2)
+		if assert.NotEmpty(t, p.NextCursor) {
+			p = getPage(t, "/players/P1/transactions?limit=2&cursor="+p.NextCursor)
+			assert.Empty(t, p.NextCursor)
+			if assert.Len(t, p.Transactions, 1) {
+				assert.Equal(t, "fund", p.Transactions[0].Op)
+			}
+		}
+	})
+
+	t.Run("filtered P2 transactions", func(t *testing.T) {
+		p := getPage(t, "/players/P2/transactions?op=deposit&tournamentId=1")
+		if assert.Len(t, p.Transactions, 1) {
+			assert.Equal(t, int64(-25), p.Transactions[0].Points)
+			assert.Equal(t, "P1", p.Transactions[0].BackedPlayerID)
+		}
+	})
+}
+
+func TestPlayerBackings(t *testing.T) {
+	_, url, cleanup := newServer(t)
+	defer cleanup()
+
+	for _, path := range []string{
+		"/fund?playerId=P1&points=100",
+		"/fund?playerId=P2&points=100",
+		"/fund?playerId=P3&points=200",
+		"/announceTournament?tournamentId=1&deposit=100",
+		"/announceTournament?tournamentId=2&deposit=40",
+	} {
+		body, status, err := get(url + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	}
+	joinBacked(t, url, "/joinTournament?tournamentId=1&playerId=P1&backerId=P2")
+	joinBacked(t, url, "/joinTournament?tournamentId=2&playerId=P3&backerId=P2")
+	for _, path := range []string{
+		"/joinTournament?tournamentId=1&playerId=P3",
+		"/startTournament?tournamentId=1",
+	} {
+		body, status, err := get(url + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	}
+	// prizes are paid after the cut, timestamps may be stored with
+	// precision of seconds
+	cut := time.Now().Truncate(time.Second).Add(time.Second)
+	time.Sleep(time.Until(cut))
+	body, status, err := post(url+"/resultTournament", `{"tournamentId": 1, "winners": [{"playerId": "P1", "prize": 200}]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status, body)
+
+	t.Run("unknown player", func(t *testing.T) {
+		body, status, err := get(url + "/players/P9/backings")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, status, body)
+	})
+
+	t.Run("invalid range", func(t *testing.T) {
+		body, status, err := get(url + "/players/P2/backings?from=yesterday")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, status, body)
+	})
+
+	t.Run("P2 backings", func(t *testing.T) {
+		body, status, err := get(url + "/players/P2/backings")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		// running tournament T2 is listed but does not count into ROI
+		assert.JSONEq(t, `{
+			"playerId": "P2",
+			"backings": [
+				{"tournamentId": 1, "playerId": "P1", "state": "finished", "stake": 50, "payout": 100, "net": 50},
+				{"tournamentId": 2, "playerId": "P3", "state": "registration_open", "stake": 20, "payout": 0, "net": -20}
+			],
+			"stake": 50,
+			"payout": 100,
+			"net": 50,
+			"roi": 1
+		}`, body)
+	})
+
+	t.Run("P3 own entries", func(t *testing.T) {
+		body, status, err := get(url + "/players/P3/backings")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `{
+			"playerId": "P3",
+			"backings": [
+				{"tournamentId": 1, "playerId": "P3", "state": "finished", "stake": 100, "payout": 0, "net": -100},
+				{"tournamentId": 2, "playerId": "P3", "state": "registration_open", "stake": 20, "payout": 0, "net": -20}
+			],
+			"stake": 100,
+			"payout": 0,
+			"net": -100,
+			"roi": -1
+		}`, body)
+	})
+
+	t.Run("time range", func(t *testing.T) {
+		body, status, err := get(url + "/players/P2/backings?to=2000-01-01T00:00:00Z")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `{"playerId": "P2", "backings": [], "stake": 0, "payout": 0, "net": 0, "roi": 0}`, body)
+	})
+
+	t.Run("range between deposit and payout", func(t *testing.T) {
+		// entries made before the cut are included with prizes paid
+		// after it
+		body, status, err := get(url + "/players/P2/backings?to=" + cut.UTC().Format(time.RFC3339))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `{
+			"playerId": "P2",
+			"backings": [
+				{"tournamentId": 1, "playerId": "P1", "state": "finished", "stake": 50, "payout": 100, "net": 50},
+				{"tournamentId": 2, "playerId": "P3", "state": "registration_open", "stake": 20, "payout": 0, "net": -20}
+			],
+			"stake": 50,
+			"payout": 100,
+			"net": 50,
+			"roi": 1
+		}`, body)
+
+		body, status, err = get(url + "/players/P2/backings?from=" + cut.UTC().Format(time.RFC3339))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `{"playerId": "P2", "backings": [], "stake": 0, "payout": 0, "net": 0, "roi": 0}`, body)
+	})
+}
+
+func TestMigrations(t *testing.T) {
+	store, _, cleanup := newServer(t)
+	defer cleanup()
+	sqlDB(t, store)
+
+	playerGet := func(playerID string) (*core.Player, error) {
+		tx, err := store.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		return tx.PlayerGet(playerID)
+	}
+
+	status, err := store.MigrateStatus(ctx)
+	assert.NoError(t, err)
+	for _, s := range status {
+		assert.NotNil(t, s.AppliedAt, s.Name)
+	}
+
+	for range status {
+		_, err := store.MigrateDown(ctx)
+		assert.NoError(t, err)
+	}
+	_, err = store.MigrateDown(ctx)
+	assert.Equal(t, db.ErrNoMigration, err)
+	_, err = playerGet("P1")
+	assert.Error(t, err, "tables are dropped")
+
+	status, err = store.MigrateStatus(ctx)
+	assert.NoError(t, err)
+	for _, s := range status {
+		assert.Nil(t, s.AppliedAt, s.Name)
+	}
+
+	// concurrent instances apply every migration exactly once
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	applied := make(map[int]int)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			versions, err := store.MigrateUp(ctx)
+			assert.NoError(t, err)
+			mu.Lock()
+			for _, v := range versions {
+				applied[v]++
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, applied, len(status))
+	for v, n := range applied {
+		assert.Equal(t, 1, n, "version %d", v)
+	}
+	_, err = playerGet("P1")
+	assert.Equal(t, db.ErrNotFound, err)
+}
+
+func TestMigrateDownUp(t *testing.T) {
+	store, url, cleanup := newServer(t)
+	defer cleanup()
+	dbh := sqlDB(t, store)
+
+	for _, path := range []string{
+		"/fund?playerId=P1&points=300",
+		"/fund?playerId=P2&points=300",
+		"/announceTournament?tournamentId=1&deposit=100",
+		"/announceTournament?tournamentId=2&deposit=100",
+		"/announceTournament?tournamentId=3&deposit=100",
+		"/joinTournament?tournamentId=2&playerId=P2",
+		"/cancelTournament?tournamentId=2",
+	} {
+		body, status, err := get(url + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	}
+	joinBacked(t, url, "/joinTournament?tournamentId=1&playerId=P1&backerId=P2")
+	body, status, err := get(url + "/startTournament?tournamentId=1")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status, body)
+	body, status, err = post(url+"/resultTournament", `{"tournamentId": 1, "winners": [{"playerId": "P1", "prize": 100}]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status, body)
+
+	type snapshot struct {
+		tournaments []core.Tournament
+		players     []core.TournPlayer
+		winners     []core.TournWinner
+	}
+	load := func() snapshot {
+		var s snapshot
+		tx, err := store.Begin(ctx)
+		if !assert.NoError(t, err) {
+			return s
+		}
+		defer tx.Rollback()
+		s.tournaments, err = tx.TournamentList()
+		assert.NoError(t, err)
+		s.players, err = tx.TournPlayerList(1)
+		assert.NoError(t, err)
+		s.winners, err = tx.TournamentWinnerList()
+		assert.NoError(t, err)
+		return s
+	}
+	before := load()
+	assert.Len(t, before.players[0].Backers, 2)
+
+	t.Run("backer tables", func(t *testing.T) {
+		status, err := store.MigrateStatus(ctx)
+		assert.NoError(t, err)
+		var backerVersion int
+		for _, s := range status {
+			if s.Name == "backer_tables" {
+				backerVersion = s.Version
+			}
+		}
+		for {
+			version, err := store.MigrateDown(ctx)
+			if !assert.NoError(t, err) || version == backerVersion {
+				break
+			}
+		}
+
+		var blob []byte
+		err = dbh.QueryRow(`SELECT data FROM tournament_player WHERE tournament_id = 1 AND player_id = 'P1'`).Scan(&blob)
+		assert.NoError(t, err)
+		var backers []core.Backer
+		assert.NoError(t, json.Unmarshal(blob, &backers))
+		assert.Equal(t, before.players[0].Backers, backers)
+
+		versions, err := store.MigrateUp(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, versions, len(status)-backerVersion+1)
+		assert.Equal(t, before, load())
+	})
+
+	t.Run("tournament state", func(t *testing.T) {
+		status, err := store.MigrateStatus(ctx)
+		assert.NoError(t, err)
+		var stateVersion int
+		for _, s := range status {
+			if s.Name == "tournament_state" {
+				stateVersion = s.Version
+			}
+		}
+		for {
+			version, err := store.MigrateDown(ctx)
+			if !assert.NoError(t, err) || version == stateVersion {
+				break
+			}
+		}
+
+		rows, err := dbh.Query(`SELECT active, cancelled FROM tournament ORDER BY tournament_id`)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer rows.Close()
+		var flags [][2]bool
+		for rows.Next() {
+			var f [2]bool
+			assert.NoError(t, rows.Scan(&f[0], &f[1]))
+			flags = append(flags, f)
+		}
+		assert.NoError(t, rows.Err())
+		assert.Equal(t, [][2]bool{{false, false}, {false, true}, {true, false}}, flags)
+
+		versions, err := store.MigrateUp(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, versions, len(status)-stateVersion+1)
+		assert.Equal(t, before, load())
+	})
+}
+
+func TestMigrateLedgerOpening(t *testing.T) {
+	store, url, cleanup := newServer(t)
+	defer cleanup()
+	sqlDB(t, store)
+	app := newApplication(store)
+
+	for _, path := range []string{
+		"/fund?playerId=P1&points=300",
+		"/fund?playerId=P2&points=200",
+		"/fund?playerId=P3&points=100",
+		"/take?playerId=P3&points=100",
+		"/announceTournament?tournamentId=1&deposit=100",
+		"/joinTournament?tournamentId=1&playerId=P1",
+		"/joinTournament?tournamentId=1&playerId=P2",
+	} {
+		body, status, err := get(url + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	}
+
+	// balances and pots of a database created before the ledger are opened
+	status, err := store.MigrateStatus(ctx)
+	assert.NoError(t, err)
+	var ledgerVersion int
+	for _, s := range status {
+		if s.Name == "ledger" {
+			ledgerVersion = s.Version
+		}
+	}
+	for {
+		version, err := store.MigrateDown(ctx)
+		if !assert.NoError(t, err) || version == ledgerVersion {
+			break
+		}
+	}
+	versions, err := store.MigrateUp(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, versions, len(status)-ledgerVersion+1)
+
+	report, err := app.reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, report.Drifts)
+	assert.Equal(t, int64(-500), report.HouseBalance)
+
+	tournament, err := app.tournament(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(200), tournament.Pot)
+
+	page, err := app.playerTransactions(ctx, db.TransferFilter{PlayerID: "P1", Limit: 10})
+	assert.NoError(t, err)
+	if assert.Len(t, page.Transactions, 1) {
+		assert.Equal(t, core.TransferOpening, page.Transactions[0].Op)
+		assert.Equal(t, int64(200), page.Transactions[0].Points)
+	}
+}
+
+func TestMigrateBackers(t *testing.T) {
+	if *storeKind != "mysql" {
+		t.Skip("legacy schema exists only in MySQL")
+	}
+	store, url, cleanup := newServer(t)
+	defer cleanup()
+	dbh := sqlDB(t, store)
+
+	for _, path := range []string{
+		"/fund?playerId=P1&points=100",
+		"/fund?playerId=P2&points=100",
+		"/announceTournament?tournamentId=1&deposit=100",
+	} {
+		body, status, err := get(url + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	}
+
+	// legacy schema stored backers as JSON blobs
+	for _, stmt := range []string{
+		`ALTER TABLE tournament_player ADD COLUMN data BLOB NOT NULL DEFAULT "{}"`,
+		`ALTER TABLE tournament_winner ADD COLUMN data BLOB NOT NULL DEFAULT "{}"`,
+		`INSERT INTO tournament_player (tournament_id, player_id, fee, data) VALUES
+			(1, 'P1', 100, '[{"PlayerID": "P1", "Points": 40}, {"PlayerID": "P2", "Points": 60}]')`,
+		`INSERT INTO tournament_winner (tournament_id, player_id, prize, data) VALUES
+			(1, 'P1', 50, '[{"PlayerID": "P1", "Points": 20}, {"PlayerID": "P2", "Points": 30}]')`,
+	} {
+		_, err := dbh.Exec(stmt)
+		if !assert.NoError(t, err, stmt) {
+			return
+		}
+	}
+
+	// database created before versioned migrations is adopted
+	status, err := store.MigrateStatus(ctx)
+	assert.NoError(t, err)
+	_, err = dbh.Exec(`DROP TABLE schema_migrations`)
+	assert.NoError(t, err)
+	versions, err := store.MigrateUp(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, versions, len(status))
+
+	tp, err := db.TournPlayerGet(dbh, 1, "P1")
+	assert.NoError(t, err)
+	assert.Equal(t, &core.TournPlayer{
+		TournamentID: 1,
+		PlayerID:     "P1",
+		Fee:          100,
+		Backers:      []core.Backer{{PlayerID: "P1", Points: 40}, {PlayerID: "P2", Points: 60}},
+	}, tp)
+
+	winners, err := db.TournamentWinnerList(dbh)
+	assert.NoError(t, err)
+	assert.Equal(t, []core.TournWinner{{
+		TournamentID: 1,
+		PlayerID:     "P1",
+		Prize:        50,
+		Backers:      []core.Backer{{PlayerID: "P1", Points: 20}, {PlayerID: "P2", Points: 30}},
+	}}, winners)
+
+	// migration is not repeated once applied
+	versions, err = store.MigrateUp(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
+	tp, err = db.TournPlayerGet(dbh, 1, "P1")
+	assert.NoError(t, err)
+	assert.Len(t, tp.Backers, 2)
+}
+
+func TestReconcile(t *testing.T) {
+	store, url, cleanup := newServer(t)
+	defer cleanup()
+	app := newApplication(store)
+
+	for _, path := range []string{
+		"/fund?playerId=P1&points=300",
+		"/fund?playerId=P2&points=300",
+		"/take?playerId=P2&points=100",
+		"/announceTournament?tournamentId=1&deposit=200",
+		"/announceTournament?tournamentId=2&deposit=100",
+		"/joinTournament?tournamentId=2&playerId=P2",
+	} {
+		body, status, err := get(url + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	}
+	joinBacked(t, url, "/joinTournament?tournamentId=1&playerId=P1&backerId=P2")
+	body, status, err := get(url + "/startTournament?tournamentId=1")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status, body)
+	body, status, err = post(url+"/resultTournament", `{"tournamentId": 1, "winners": [{"playerId": "P1", "prize": 200}]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status, body)
+
+	t.Run("consistent ledger", func(t *testing.T) {
+		report, err := app.reconcile(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, report.Drifts)
+		assert.Equal(t, 2, report.Players)
+		assert.Equal(t, 2, report.Tournaments)
+		// 600 points funded and 100 taken out
+		assert.Equal(t, int64(-500), report.HouseBalance)
+	})
+
+	t.Run("manual balance fix", func(t *testing.T) {
+		tx, err := store.Begin(ctx)
+		if !assert.NoError(t, err) {
+			return
+		}
+		player, err := tx.PlayerGetForUpdate("P2")
+		assert.NoError(t, err)
+		player.Balance += 5
+		assert.NoError(t, tx.PlayerUpdate(player))
+		assert.NoError(t, tx.Commit())
+
+		report, err := app.reconcile(ctx)
+		assert.NoError(t, err)
+		if assert.Len(t, report.Drifts, 1) {
+			assert.Equal(t, core.Drift{
+				Kind:     core.DriftPlayerBalance,
+				PlayerID: "P2",
+				Expected: 100,
+				Actual:   105,
+			}, report.Drifts[0])
+		}
+	})
+}
+
+func getIdempotent(url string, key string) (string, int, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", 0, false, err
+	}
+	req.Header.Set("Idempotency-Key", key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, false, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, false, err
+	}
+	return string(body), resp.StatusCode, resp.Header.Get("Idempotent-Replayed") == "true", nil
+}
+
+func TestIdempotency(t *testing.T) {
+	_, url, cleanup := newServer(t)
+	defer cleanup()
+
+	t.Run("fund P1 100", func(t *testing.T) {
+		body, status, replayed, err := getIdempotent(fmt.Sprintf("%s/fund?playerId=P1&points=100", url), "fund-1")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+		assert.False(t, replayed)
+	})
+
+	t.Run("retry fund P1 100", func(t *testing.T) {
+		body, status, replayed, err := getIdempotent(fmt.Sprintf("%s/fund?playerId=P1&points=100", url), "fund-1")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+		assert.True(t, replayed)
+	})
+
+	t.Run("reuse key for different request", func(t *testing.T) {
+		body, status, _, err := getIdempotent(fmt.Sprintf("%s/fund?playerId=P1&points=200", url), "fund-1")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, status, body)
+	})
+
+	t.Run("concurrent retries", func(t *testing.T) {
+		var wg sync.WaitGroup
+		n := 10
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			go func() {
+				defer wg.Done()
+				body, status, _, err := getIdempotent(fmt.Sprintf("%s/fund?playerId=P1&points=10", url), "fund-2")
+				assert.NoError(t, err)
+				assert.Equal(t, http.StatusNoContent, status, body)
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("failed request is not stored", func(t *testing.T) {
+		body, status, _, err := getIdempotent(fmt.Sprintf("%s/take?playerId=P1&points=1000", url), "take-1")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, status, body)
+
+		body, status, err = get(fmt.Sprintf("%s/fund?playerId=P1&points=1000", url))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+
+		body, status, replayed, err := getIdempotent(fmt.Sprintf("%s/take?playerId=P1&points=1000", url), "take-1")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+		assert.False(t, replayed)
+	})
+
+	t.Run("balance P1", func(t *testing.T) {
+		body, status, err := get(fmt.Sprintf("%s/balance?playerId=P1", url))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `{"playerId": "P1", "balance": 110}`, body)
+	})
+}
+
+// deadlockStore fails commits of its transactions with MySQL deadlock error
+// until given number of failures is reached.
+type deadlockStore struct {
+	db.Store
+	mu       sync.Mutex
+	failures int
+}
+
+type deadlockTx struct {
+	db.Tx
+	store *deadlockStore
+}
+
+func (s *deadlockStore) Begin(ctx context.Context) (db.Tx, error) {
+	tx, err := s.Store.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &deadlockTx{Tx: tx, store: s}, nil
+}
+
+func (t *deadlockTx) Commit() error {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	if t.store.failures > 0 {
+		t.store.failures--
+		t.Tx.Rollback()
+		return &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+	}
+	return t.Tx.Commit()
+}
+
+func transactionMetric(name string) int64 {
+	if v, ok := db.TransactionMetrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestTransactionRetry(t *testing.T) {
+	store, url, cleanup := newServer(t)
+	defer cleanup()
+
+	t.Run("deadlock is retried", func(t *testing.T) {
+		retries, recovered := transactionMetric("retries"), transactionMetric("recovered")
+		app := newApplication(&deadlockStore{Store: store, failures: 2})
+		resp, err := app.addPoints(ctx, nil, nil, "P1", 100)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.status)
+		assert.Equal(t, retries+2, transactionMetric("retries"))
+		assert.Equal(t, recovered+1, transactionMetric("recovered"))
+	})
+
+	t.Run("attempts are bounded", func(t *testing.T) {
+		exhausted := transactionMetric("exhausted")
+		app := newApplication(&deadlockStore{Store: store, failures: 100})
+		_, err := app.addPoints(ctx, nil, nil, "P1", 100)
+		assert.Error(t, err)
+		assert.Equal(t, exhausted+1, transactionMetric("exhausted"))
+	})
+
+	t.Run("balance P1", func(t *testing.T) {
+		body, status, err := get(url + "/balance?playerId=P1")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `{"playerId": "P1", "balance": 100}`, body)
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		body, status, err := get(url + "/debug/vars")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Contains(t, body, `"db.transactions"`)
+	})
+}
+
+func TestTransactionDone(t *testing.T) {
+	store, _, cleanup := newServer(t)
+	defer cleanup()
+
+	t.Run("committed", func(t *testing.T) {
+		tx, err := store.Begin(ctx)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NoError(t, tx.Commit())
+		_, err = tx.PlayerList()
+		assert.Equal(t, sql.ErrTxDone, err)
+		assert.Equal(t, sql.ErrTxDone, tx.PlayerInsert(&core.Player{PlayerID: "P1"}))
+		assert.Equal(t, sql.ErrTxDone, tx.Commit())
+		assert.NoError(t, tx.Rollback())
+	})
+
+	t.Run("rolled back", func(t *testing.T) {
+		tx, err := store.Begin(ctx)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NoError(t, tx.Rollback())
+		_, err = tx.TournamentList()
+		assert.Equal(t, sql.ErrTxDone, err)
+		assert.Equal(t, sql.ErrTxDone, tx.Commit())
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		cctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		tx, err := store.Begin(cctx)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer tx.Rollback()
+		assert.NoError(t, tx.PlayerInsert(&core.Player{PlayerID: "P1"}))
+		cancel()
+		_, err = tx.PlayerGet("P1")
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, context.Canceled, tx.PlayerInsert(&core.Player{PlayerID: "P2"}))
+		assert.Equal(t, context.Canceled, tx.Commit())
+	})
+
+	tx, err := store.Begin(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer tx.Rollback()
+	players, err := tx.PlayerList()
+	assert.NoError(t, err)
+	assert.Empty(t, players)
+}
+
+func TestRequestTimeouts(t *testing.T) {
+	timeouts, err := parseRequestTimeouts(time.Second, []string{"/players=2s", "/players/P1=3s", "/fund=100ms"})
+	assert.NoError(t, err)
+
+	t.Run("prefix match", func(t *testing.T) {
+		for _, tc := range []struct {
+			path    string
+			timeout time.Duration
+		}{
+			{"/balance", time.Second},
+			{"/players/P2/transactions", 2 * time.Second},
+			{"/players/P1/transactions", 3 * time.Second},
+			{"/fund", 100 * time.Millisecond},
+		} {
+			assert.Equal(t, tc.timeout, timeouts.timeout(tc.path), tc.path)
+		}
+	})
+
+	t.Run("invalid timeouts", func(t *testing.T) {
+		for _, pairs := range [][]string{{"/fund"}, {"=1s"}, {"/fund=soon"}, {"/fund=-1s"}} {
+			_, err := parseRequestTimeouts(time.Second, pairs)
+			assert.Error(t, err, pairs)
+		}
+	})
+
+	store, _, cleanup := newServer(t)
+	defer cleanup()
+	server := httptest.NewServer(timeouts.handler(mainRouter(newApplication(store), true, nil)))
+	defer server.Close()
+
+	body, status, err := get(server.URL + "/fund?playerId=P1&points=100")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status, body)
+
+	t.Run("locked player", func(t *testing.T) {
+		tx, err := store.Begin(ctx)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer tx.Rollback()
+		_, err = tx.PlayerGetForUpdate("P1")
+		assert.NoError(t, err)
+
+		body, status, err := get(server.URL + "/fund?playerId=P1&points=100")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, status, body)
+	})
+
+	t.Run("balance P1", func(t *testing.T) {
+		body, status, err := get(server.URL + "/balance?playerId=P1")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `{"playerId": "P1", "balance": 100}`, body)
+	})
+}
+
+func TestCancelTournament(t *testing.T) {
+	_, url, cleanup := newServer(t)
+	defer cleanup()
+
+	for _, path := range []string{
+		"/fund?playerId=P1&points=100",
+		"/fund?playerId=P2&points=100",
+		"/fund?playerId=P3&points=100",
+		"/announceTournament?tournamentId=1&deposit=90",
+		"/joinTournament?tournamentId=1&playerId=P3",
+		"/announceTournament?tournamentId=2&deposit=10",
+		"/startTournament?tournamentId=2",
+	} {
+		body, status, err := get(url + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	}
+	joinBacked(t, url, "/joinTournament?tournamentId=1&playerId=P1&backerId=P2")
+	body, status, err := post(url+"/resultTournament", `{"tournamentId": 2, "winners": []}`)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status, body)
+
+	t.Run("cancel T1", func(t *testing.T) {
+		body, status, err := get(fmt.Sprintf("%s/cancelTournament?tournamentId=1", url))
+		assert.NoError(t, err)
+		assert.Empty(t, body)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	})
+	for _, p := range []string{"P1", "P2", "P3"} {
+		t.Run("balance "+p, func(t *testing.T) {
+			body, status, err := get(fmt.Sprintf("%s/balance?playerId=%s", url, p))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, status)
+			assert.JSONEq(t, fmt.Sprintf(`{"playerId": "%s", "balance": 100}`, p), body)
+		})
+	}
+	t.Run("cancel T1 again", func(t *testing.T) {
+		body, status, err := get(fmt.Sprintf("%s/cancelTournament?tournamentId=1", url))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, status, body)
+	})
+	t.Run("join cancelled T1", func(t *testing.T) {
+		body, status, err := get(fmt.Sprintf("%s/joinTournament?tournamentId=1&playerId=P2", url))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, status, body)
+	})
+	t.Run("cancel finished T2", func(t *testing.T) {
+		body, status, err := get(fmt.Sprintf("%s/cancelTournament?tournamentId=2", url))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, status, body)
+	})
+	t.Run("cancel unknown T3", func(t *testing.T) {
+		body, status, err := get(fmt.Sprintf("%s/cancelTournament?tournamentId=3", url))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, status, body)
+	})
+}
+
+func TestTournamentLifecycle(t *testing.T) {
+	_, url, cleanup := newServer(t)
+	defer cleanup()
+
+	steps := []struct {
+		msg    string
+		path   string
+		status int
+	}{
+		{"fund P1", "/fund?playerId=P1&points=100", http.StatusNoContent},
+		{"fund P2", "/fund?playerId=P2&points=100", http.StatusNoContent},
+		{"announce T1", "/announceTournament?tournamentId=1&deposit=10&openRegistration=false", http.StatusNoContent},
+		{"join announced T1", "/joinTournament?tournamentId=1&playerId=P1", http.StatusConflict},
+		{"start announced T1", "/startTournament?tournamentId=1", http.StatusConflict},
+		{"open T1 registration", "/openRegistration?tournamentId=1", http.StatusNoContent},
+		{"join P1", "/joinTournament?tournamentId=1&playerId=P1", http.StatusNoContent},
+		{"close T1 registration", "/closeRegistration?tournamentId=1", http.StatusNoContent},
+		{"join P2 after registration", "/joinTournament?tournamentId=1&playerId=P2", http.StatusConflict},
+		{"start T1", "/startTournament?tournamentId=1", http.StatusNoContent},
+		{"reopen running T1", "/openRegistration?tournamentId=1", http.StatusConflict},
+		{"join running T1", "/joinTournament?tournamentId=1&playerId=P2", http.StatusConflict},
+		{"unknown tournament", "/startTournament?tournamentId=2", http.StatusNotFound},
+	}
+	for _, step := range steps {
+		t.Run(step.msg, func(t *testing.T) {
+			body, status, err := get(url + step.path)
+			assert.NoError(t, err)
+			assert.Equal(t, step.status, status, body)
+		})
+	}
+
+	t.Run("result T1", func(t *testing.T) {
+		body, status, err := post(url+"/resultTournament", `{"tournamentId": 1, "winners": [{"playerId": "P1", "prize": 10}]}`)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	})
+
+	t.Run("announce and result T2 before start", func(t *testing.T) {
+		body, status, err := get(url + "/announceTournament?tournamentId=2&deposit=10")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+
+		body, status, err = post(url+"/resultTournament", `{"tournamentId": 2, "winners": []}`)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, status, body)
+	})
+}
+
+func TestLeaveTournament(t *testing.T) {
+	_, url, cleanup := newServer(t)
+	defer cleanup()
+
+	steps := []struct {
+		msg    string
+		path   string
+		status int
+	}{
+		{"fund P1", "/fund?playerId=P1&points=100", http.StatusNoContent},
+		{"fund P2", "/fund?playerId=P2&points=100", http.StatusNoContent},
+		{"announce T1", "/announceTournament?tournamentId=1&deposit=60", http.StatusNoContent},
+		{"propose P1 with P2", "/joinTournament?tournamentId=1&playerId=P1&backerId=P2", http.StatusAccepted},
+		{"accept P2", "/acceptBacking?requestId=1&backerId=P2", http.StatusNoContent},
+		{"leave P1", "/leaveTournament?tournamentId=1&playerId=P1", http.StatusNoContent},
+		{"leave P1 again", "/leaveTournament?tournamentId=1&playerId=P1", http.StatusNotFound},
+		{"rejoin P1", "/joinTournament?tournamentId=1&playerId=P1", http.StatusNoContent},
+		{"start T1", "/startTournament?tournamentId=1", http.StatusNoContent},
+		{"leave running T1", "/leaveTournament?tournamentId=1&playerId=P1", http.StatusConflict},
+	}
+	for _, step := range steps {
+		t.Run(step.msg, func(t *testing.T) {
+			body, status, err := get(url + step.path)
+			assert.NoError(t, err)
+			assert.Equal(t, step.status, status, body)
+		})
+	}
+
+	for player, balance := range map[string]int{"P1": 40, "P2": 100} {
+		t.Run("balance "+player, func(t *testing.T) {
+			body, status, err := get(fmt.Sprintf("%s/balance?playerId=%s", url, player))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, status)
+			assert.JSONEq(t, fmt.Sprintf(`{"playerId": "%s", "balance": %d}`, player, balance), body)
+		})
+	}
+}
+
+func TestTournamentRake(t *testing.T) {
+	store, url, cleanup := newServer(t)
+	defer cleanup()
+	app := newApplication(store)
+
+	steps := []struct {
+		msg    string
+		path   string
+		status int
+	}{
+		{"fund P1", "/fund?playerId=P1&points=100", http.StatusNoContent},
+		{"fund P2", "/fund?playerId=P2&points=100", http.StatusNoContent},
+		{"announce T1 with invalid rake", "/announceTournament?tournamentId=1&deposit=100&rakePercent=101", http.StatusUnprocessableEntity},
+		{"announce T1", "/announceTournament?tournamentId=1&deposit=100&rakePercent=10&houseFee=10", http.StatusNoContent},
+		{"join P1", "/joinTournament?tournamentId=1&playerId=P1", http.StatusNoContent},
+		{"join P2", "/joinTournament?tournamentId=1&playerId=P2", http.StatusNoContent},
+		{"start T1", "/startTournament?tournamentId=1", http.StatusNoContent},
+	}
+	for _, step := range steps {
+		t.Run(step.msg, func(t *testing.T) {
+			body, status, err := get(url + step.path)
+			assert.NoError(t, err)
+			assert.Equal(t, step.status, status, body)
+		})
+	}
+
+	results := []struct {
+		msg    string
+		data   string
+		status int
+	}{
+		{"result T1 exceeding pool", `{"tournamentId": 1, "winners": [{"playerId": "P1", "prize": 171}]}`, http.StatusConflict},
+		{"result T1", `{"tournamentId": 1, "winners": [{"playerId": "P1", "prize": 170}]}`, http.StatusNoContent},
+	}
+	for _, result := range results {
+		t.Run(result.msg, func(t *testing.T) {
+			body, status, err := post(url+"/resultTournament", result.data)
+			assert.NoError(t, err)
+			assert.Equal(t, result.status, status, body)
+		})
+	}
+
+	t.Run("result T2 with overlay", func(t *testing.T) {
+		for _, path := range []string{
+			"/announceTournament?tournamentId=2&deposit=50",
+			"/joinTournament?tournamentId=2&playerId=P1",
+			"/startTournament?tournamentId=2",
+		} {
+			body, status, err := get(url + path)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusNoContent, status, body)
+		}
+
+		body, status, err := post(url+"/resultTournament", `{"tournamentId": 2, "winners": [{"playerId": "P1", "prize": 100}]}`)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, status, body)
+
+		body, status, err = post(url+"/resultTournament", `{"tournamentId": 2, "winners": [{"playerId": "P1", "prize": 100}], "overlay": true}`)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	})
+
+	t.Run("result T3 with unclaimed prize pool", func(t *testing.T) {
+		for _, path := range []string{
+			"/announceTournament?tournamentId=3&deposit=100",
+			"/joinTournament?tournamentId=3&playerId=P1",
+			"/startTournament?tournamentId=3",
+		} {
+			body, status, err := get(url + path)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusNoContent, status, body)
+		}
+
+		body, status, err := post(url+"/resultTournament", `{"tournamentId": 3, "winners": [{"playerId": "P1", "prize": 60}]}`)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	})
+
+	t.Run("pots", func(t *testing.T) {
+		tx, err := store.Begin(ctx)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer tx.Rollback()
+		tournaments, err := tx.TournamentList()
+		assert.NoError(t, err)
+		for _, tournament := range tournaments {
+			assert.Equal(t, int64(0), tournament.Pot, "tournament %d", tournament.ID)
+		}
+	})
+
+	for player, balance := range map[string]int{"P1": 180, "P2": 0} {
+		t.Run("balance "+player, func(t *testing.T) {
+			body, status, err := get(fmt.Sprintf("%s/balance?playerId=%s", url, player))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, status)
+			assert.JSONEq(t, fmt.Sprintf(`{"playerId": "%s", "balance": %d}`, player, balance), body)
+		})
+	}
+
+	t.Run("house balance", func(t *testing.T) {
+		report, err := app.reconcile(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, report.Drifts)
+		// house funded 200 points, collected 30 rake, covered 50 overlay and
+		// collected 40 unclaimed prize pool
+		assert.Equal(t, int64(-180), report.HouseBalance)
+	})
+}
+
+func TestPayoutStructure(t *testing.T) {
+	_, url, cleanup := newServer(t)
+	defer cleanup()
+
+	steps := []struct {
+		msg    string
+		path   string
+		status int
+	}{
+		{"fund P1", "/fund?playerId=P1&points=100", http.StatusNoContent},
+		{"fund P2", "/fund?playerId=P2&points=100", http.StatusNoContent},
+		{"fund P3", "/fund?playerId=P3&points=100", http.StatusNoContent},
+		{"announce T1 with invalid payout", "/announceTournament?tournamentId=1&deposit=100&payout=50,30", http.StatusUnprocessableEntity},
+		{"announce T1 with unknown template", "/announceTournament?tournamentId=1&deposit=100&payoutTemplate=unknown", http.StatusUnprocessableEntity},
+		{"announce T1", "/announceTournament?tournamentId=1&deposit=100&payout=60,30,10&rakePercent=10", http.StatusNoContent},
+		{"join P1", "/joinTournament?tournamentId=1&playerId=P1", http.StatusNoContent},
+		{"join P2", "/joinTournament?tournamentId=1&playerId=P2", http.StatusNoContent},
+		{"join P3", "/joinTournament?tournamentId=1&playerId=P3", http.StatusNoContent},
+		{"start T1", "/startTournament?tournamentId=1", http.StatusNoContent},
+	}
+	for _, step := range steps {
+		t.Run(step.msg, func(t *testing.T) {
+			body, status, err := get(url + step.path)
+			assert.NoError(t, err)
+			assert.Equal(t, step.status, status, body)
+		})
+	}
+
+	results := []struct {
+		msg    string
+		data   string
+		status int
+	}{
+		{"result T1 with missing places", `{"tournamentId": 1, "places": ["P3", "P1"]}`, http.StatusUnprocessableEntity},
+		{"result T1 with unknown player", `{"tournamentId": 1, "places": ["P3", "P1", "P4"]}`, http.StatusNotFound},
+		{"result T1", `{"tournamentId": 1, "places": ["P3", "P1", "P2"]}`, http.StatusNoContent},
+	}
+	for _, result := range results {
+		t.Run(result.msg, func(t *testing.T) {
+			body, status, err := post(url+"/resultTournament", result.data)
+			assert.NoError(t, err)
+			assert.Equal(t, result.status, status, body)
+		})
+	}
+
+	// pool of 300 minus 30 rake is split 162/81/27
+	for player, balance := range map[string]int{"P1": 81, "P2": 27, "P3": 162} {
+		t.Run("balance "+player, func(t *testing.T) {
+			body, status, err := get(fmt.Sprintf("%s/balance?playerId=%s", url, player))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, status)
+			assert.JSONEq(t, fmt.Sprintf(`{"playerId": "%s", "balance": %d}`, player, balance), body)
+		})
+	}
+}
+
+func TestSharedPlaces(t *testing.T) {
+	_, url, cleanup := newServer(t)
+	defer cleanup()
+
+	for _, path := range []string{
+		"/fund?playerId=P1&points=100",
+		"/fund?playerId=P2&points=100",
+		"/fund?playerId=P3&points=100",
+		"/fund?playerId=P4&points=100",
+		"/announceTournament?tournamentId=1&deposit=100&payout=50,30,20",
+		"/joinTournament?tournamentId=1&playerId=P1",
+		"/joinTournament?tournamentId=1&playerId=P2",
+		"/joinTournament?tournamentId=1&playerId=P3",
+		"/joinTournament?tournamentId=1&playerId=P4",
+		"/startTournament?tournamentId=1",
+	} {
+		body, status, err := get(url + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	}
+
+	body, status, err := post(url+"/resultTournament", `{"tournamentId": 1, "places": ["P1", ["P3", "P2"], "P4"]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status, body)
+
+	// P2 and P3 share 2nd and 3rd prize of 120 and 80 points
+	for player, balance := range map[string]int{"P1": 200, "P2": 100, "P3": 100, "P4": 0} {
+		t.Run("balance "+player, func(t *testing.T) {
+			body, status, err := get(fmt.Sprintf("%s/balance?playerId=%s", url, player))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, status)
+			assert.JSONEq(t, fmt.Sprintf(`{"playerId": "%s", "balance": %d}`, player, balance), body)
+		})
+	}
+}
+
+func TestCustomStakes(t *testing.T) {
+	_, url, cleanup := newServer(t)
+	defer cleanup()
+
+	steps := []struct {
+		msg    string
+		path   string
+		status int
+	}{
+		{"fund P1", "/fund?playerId=P1&points=100", http.StatusNoContent},
+		{"fund P2", "/fund?playerId=P2&points=100", http.StatusNoContent},
+		{"fund P3", "/fund?playerId=P3&points=100", http.StatusNoContent},
+		{"fund P4", "/fund?playerId=P4&points=100", http.StatusNoContent},
+		{"announce T1", "/announceTournament?tournamentId=1&deposit=100", http.StatusNoContent},
+		{"join with missing stake", "/joinTournament?tournamentId=1&playerId=P1&backerId=P2&stake=100", http.StatusBadRequest},
+		{"join with invalid stake unit", "/joinTournament?tournamentId=1&playerId=P1&stake=100&stakeUnit=percent", http.StatusBadRequest},
+		{"join with stakes below deposit", "/joinTournament?tournamentId=1&playerId=P1&backerId=P2&stake=20&stake=40", http.StatusUnprocessableEntity},
+		{"join with basis points below 10000", "/joinTournament?tournamentId=1&playerId=P1&backerId=P2&stake=2000&stake=4000&stakeUnit=bps", http.StatusUnprocessableEntity},
+		{"propose P1 with P2, P3 in basis points", "/joinTournament?tournamentId=1&playerId=P1&backerId=P2&backerId=P3&stake=2000&stake=4000&stake=4000&stakeUnit=bps", http.StatusAccepted},
+		{"accept P2", "/acceptBacking?requestId=1&backerId=P2", http.StatusNoContent},
+		{"accept P3", "/acceptBacking?requestId=1&backerId=P3", http.StatusNoContent},
+		{"join P4 in points", "/joinTournament?tournamentId=1&playerId=P4&stake=100", http.StatusNoContent},
+		{"start T1", "/startTournament?tournamentId=1", http.StatusNoContent},
+	}
+	for _, step := range steps {
+		t.Run(step.msg, func(t *testing.T) {
+			body, status, err := get(url + step.path)
+			assert.NoError(t, err)
+			assert.Equal(t, step.status, status, body)
+		})
+	}
+
+	body, status, err := post(url+"/resultTournament", `{"tournamentId": 1, "winners": [{"playerId": "P1", "prize": 200}]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status, body)
+
+	// prize is split 20/40/40 as deposit stakes were
+	for player, balance := range map[string]int{"P1": 120, "P2": 140, "P3": 140, "P4": 0} {
+		t.Run("balance "+player, func(t *testing.T) {
+			body, status, err := get(fmt.Sprintf("%s/balance?playerId=%s", url, player))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, status)
+			assert.JSONEq(t, fmt.Sprintf(`{"playerId": "%s", "balance": %d}`, player, balance), body)
+		})
+	}
+}
+
+func TestBackingRequests(t *testing.T) {
+	_, url, cleanup := newServer(t)
+	defer cleanup()
+
+	steps := []struct {
+		msg    string
+		path   string
+		status int
+	}{
+		{"fund P1", "/fund?playerId=P1&points=100", http.StatusNoContent},
+		{"fund P2", "/fund?playerId=P2&points=100", http.StatusNoContent},
+		{"fund P3", "/fund?playerId=P3&points=100", http.StatusNoContent},
+		{"announce T1", "/announceTournament?tournamentId=1&deposit=90", http.StatusNoContent},
+		{"announce T2", "/announceTournament?tournamentId=2&deposit=90", http.StatusNoContent},
+		{"propose with unknown backer", "/joinTournament?tournamentId=1&playerId=P1&backerId=P4", http.StatusNotFound},
+		{"propose P1 with P2, P3", "/joinTournament?tournamentId=1&playerId=P1&backerId=P2&backerId=P3", http.StatusAccepted},
+		{"accept by player", "/acceptBacking?requestId=1&backerId=P1", http.StatusUnprocessableEntity},
+		{"accept by unknown backer", "/acceptBacking?requestId=1&backerId=P4", http.StatusUnprocessableEntity},
+		{"accept unknown request", "/acceptBacking?requestId=100&backerId=P2", http.StatusNotFound},
+		{"accept P2", "/acceptBacking?requestId=1&backerId=P2", http.StatusNoContent},
+		{"accept P2 again", "/acceptBacking?requestId=1&backerId=P2", http.StatusConflict},
+	}
+	for _, step := range steps {
+		t.Run(step.msg, func(t *testing.T) {
+			body, status, err := get(url + step.path)
+			assert.NoError(t, err)
+			assert.Equal(t, step.status, status, body)
+		})
+	}
+
+	t.Run("pending backing requests P3", func(t *testing.T) {
+		body, status, err := get(url + "/players/P3/backingRequests")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `[{
+			"id": 1,
+			"tournamentId": 1,
+			"playerId": "P1",
+			"status": "pending",
+			"stakes": [
+				{"playerId": "P1", "points": 30, "accepted": true},
+				{"playerId": "P2", "points": 30, "accepted": true},
+				{"playerId": "P3", "points": 30, "accepted": false}
+			]
+		}]`, body)
+	})
+
+	t.Run("balance P1 before all accept", func(t *testing.T) {
+		body, status, err := get(url + "/balance?playerId=P1")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `{"playerId": "P1", "balance": 100}`, body)
+	})
+
+	steps = []struct {
+		msg    string
+		path   string
+		status int
+	}{
+		{"accept P3", "/acceptBacking?requestId=1&backerId=P3", http.StatusNoContent},
+		{"accept accepted request", "/acceptBacking?requestId=1&backerId=P3", http.StatusConflict},
+		{"propose P2 with P1", "/joinTournament?tournamentId=2&playerId=P2&backerId=P1", http.StatusAccepted},
+		{"decline P1", "/declineBacking?requestId=2&backerId=P1", http.StatusNoContent},
+		{"accept declined request", "/acceptBacking?requestId=2&backerId=P1", http.StatusConflict},
+		{"propose P3 with P1", "/joinTournament?tournamentId=2&playerId=P3&backerId=P1", http.StatusAccepted},
+		{"close T2 registration", "/closeRegistration?tournamentId=2", http.StatusNoContent},
+		{"accept expired request", "/acceptBacking?requestId=3&backerId=P1", http.StatusConflict},
+	}
+	for _, step := range steps {
+		t.Run(step.msg, func(t *testing.T) {
+			body, status, err := get(url + step.path)
+			assert.NoError(t, err)
+			assert.Equal(t, step.status, status, body)
+		})
+	}
+
+	t.Run("no pending backing requests P1", func(t *testing.T) {
+		body, status, err := get(url + "/players/P1/backingRequests")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `[]`, body)
+	})
+
+	for player, balance := range map[string]int{"P1": 70, "P2": 70, "P3": 70} {
+		t.Run("balance "+player, func(t *testing.T) {
+			body, status, err := get(fmt.Sprintf("%s/balance?playerId=%s", url, player))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, status)
+			assert.JSONEq(t, fmt.Sprintf(`{"playerId": "%s", "balance": %d}`, player, balance), body)
+		})
+	}
+}
+
+func TestStakeMarketplace(t *testing.T) {
+	store, url, cleanup := newServer(t)
+	defer cleanup()
+	app := newApplication(store)
+
+	steps := []struct {
+		msg    string
+		path   string
+		status int
+	}{
+		{"fund P1", "/fund?playerId=P1&points=100", http.StatusNoContent},
+		{"fund P2", "/fund?playerId=P2&points=100", http.StatusNoContent},
+		{"fund P3", "/fund?playerId=P3&points=100", http.StatusNoContent},
+		{"fund P4", "/fund?playerId=P4&points=100", http.StatusNoContent},
+		{"fund P5", "/fund?playerId=P5&points=100", http.StatusNoContent},
+		{"fund P6", "/fund?playerId=P6&points=100", http.StatusNoContent},
+		{"announce T1", "/announceTournament?tournamentId=1&deposit=100", http.StatusNoContent},
+		{"join P1", "/joinTournament?tournamentId=1&playerId=P1", http.StatusNoContent},
+		{"offer by non player", "/offerStake?tournamentId=1&playerId=P2&available=6000", http.StatusNotFound},
+		{"offer whole stake", "/offerStake?tournamentId=1&playerId=P1&available=10000", http.StatusUnprocessableEntity},
+		{"offer P1", "/offerStake?tournamentId=1&playerId=P1&available=6000&markup=12000", http.StatusCreated},
+		{"offer P1 again", "/offerStake?tournamentId=1&playerId=P1&available=1000", http.StatusConflict},
+		{"buy unknown offer", "/buyStake?offerId=100&buyerId=P2&bps=1000", http.StatusNotFound},
+		{"buy more than offered", "/buyStake?offerId=1&buyerId=P2&bps=6001", http.StatusConflict},
+	}
+	for _, step := range steps {
+		t.Run(step.msg, func(t *testing.T) {
+			body, status, err := get(url + step.path)
+			assert.NoError(t, err)
+			assert.Equal(t, step.status, status, body)
+		})
+	}
+
+	t.Run("list offers", func(t *testing.T) {
+		body, status, err := get(url + "/stakeOffers?tournamentId=1")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `[{"id": 1, "tournamentId": 1, "playerId": "P1", "available": 6000, "markup": 12000}]`, body)
+	})
+
+	t.Run("buy concurrently", func(t *testing.T) {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		statuses := make(map[int]int)
+		for i := 2; i <= 6; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				body, status, err := get(fmt.Sprintf("%s/buyStake?offerId=1&buyerId=P%d&bps=2000", url, i))
+				assert.NoError(t, err, body)
+				mu.Lock()
+				statuses[status]++
+				mu.Unlock()
+			}(i)
+		}
+		wg.Wait()
+		assert.Equal(t, map[int]int{http.StatusNoContent: 3, http.StatusConflict: 2}, statuses)
+	})
+
+	t.Run("list sold out offers", func(t *testing.T) {
+		body, status, err := get(url + "/stakeOffers?tournamentId=1")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `[]`, body)
+	})
+
+	t.Run("result T1", func(t *testing.T) {
+		body, status, err := get(url + "/startTournament?tournamentId=1")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+
+		body, status, err = post(url+"/resultTournament", `{"tournamentId": 1, "winners": [{"playerId": "P1", "prize": 100}]}`)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	})
+
+	t.Run("balances", func(t *testing.T) {
+		balances := make(map[int64]int)
+		for i := 1; i <= 6; i++ {
+			body, status, err := get(fmt.Sprintf("%s/balance?playerId=P%d", url, i))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, status)
+			var p core.Player
+			assert.NoError(t, json.Unmarshal([]byte(body), &p))
+			balances[p.Balance]++
+		}
+		// P1 sold 3 stakes of 20 points for 24 points and kept 40% of the
+		// prize, buyers paid 24 points and won 20 points each
+		assert.Equal(t, map[int64]int{112: 1, 96: 3, 100: 2}, balances)
+
+		report, err := app.reconcile(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, report.Drifts)
+	})
+}
+
+func TestStakeSaleRefunds(t *testing.T) {
+	store, url, cleanup := newServer(t)
+	defer cleanup()
+	app := newApplication(store)
+
+	steps := []struct {
+		msg    string
+		path   string
+		status int
+	}{
+		{"fund P1", "/fund?playerId=P1&points=100", http.StatusNoContent},
+		{"fund P2", "/fund?playerId=P2&points=100", http.StatusNoContent},
+		{"fund P3", "/fund?playerId=P3&points=100", http.StatusNoContent},
+		{"fund P4", "/fund?playerId=P4&points=100", http.StatusNoContent},
+		{"announce T1", "/announceTournament?tournamentId=1&deposit=100", http.StatusNoContent},
+		{"announce T2", "/announceTournament?tournamentId=2&deposit=100", http.StatusNoContent},
+		{"join P1 backed by P2", "/joinTournament?tournamentId=1&playerId=P1&backerId=P2", http.StatusAccepted},
+		{"accept by P2", "/acceptBacking?requestId=1&backerId=P2", http.StatusNoContent},
+		{"offer P1 in T1", "/offerStake?tournamentId=1&playerId=P1&available=4000&markup=15000", http.StatusCreated},
+		{"buy by backer P2", "/buyStake?offerId=1&buyerId=P2&bps=2000", http.StatusNoContent},
+		{"buy by P3", "/buyStake?offerId=1&buyerId=P3&bps=2000", http.StatusNoContent},
+		{"cancel T1", "/cancelTournament?tournamentId=1", http.StatusNoContent},
+		{"join P1 in T2", "/joinTournament?tournamentId=2&playerId=P1", http.StatusNoContent},
+		{"offer P1 in T2", "/offerStake?tournamentId=2&playerId=P1&available=5000&markup=15000", http.StatusCreated},
+		{"buy by P4", "/buyStake?offerId=2&buyerId=P4&bps=5000", http.StatusNoContent},
+		{"withdraw P1", "/leaveTournament?tournamentId=2&playerId=P1", http.StatusNoContent},
+		{"rejoin P1", "/joinTournament?tournamentId=2&playerId=P1", http.StatusNoContent},
+		{"cancel T2", "/cancelTournament?tournamentId=2", http.StatusNoContent},
+	}
+	for _, step := range steps {
+		t.Run(step.msg, func(t *testing.T) {
+			body, status, err := get(url + step.path)
+			assert.NoError(t, err)
+			assert.Equal(t, step.status, status, body)
+		})
+	}
+
+	t.Run("balances", func(t *testing.T) {
+		// buyers get back the price with markup and sold stakes are
+		// refunded to P1, sales reversed by the withdrawal are not
+		// reversed again by the cancellation
+		for i := 1; i <= 4; i++ {
+			body, status, err := get(fmt.Sprintf("%s/balance?playerId=P%d", url, i))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, status)
+			assert.JSONEq(t, fmt.Sprintf(`{"playerId": "P%d", "balance": 100}`, i), body)
+		}
+
+		report, err := app.reconcile(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, report.Drifts)
+	})
+
+	t.Run("stake refunds", func(t *testing.T) {
+		body, status, err := get(url + "/players/P3/transactions?op=stake_refund")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status, body)
+		var page transferPage
+		assert.NoError(t, json.Unmarshal([]byte(body), &page))
+		if assert.Len(t, page.Transactions, 1) {
+			assert.Equal(t, int64(30), page.Transactions[0].Points)
+		}
+	})
+}
+
+func TestConcurrentStakePurchases(t *testing.T) {
+	store, url, cleanup := newServer(t)
+	defer cleanup()
+
+	const buyers = 8
+	paths := []string{
+		"/fund?playerId=P0&points=100",
+		"/announceTournament?tournamentId=1&deposit=100",
+		"/joinTournament?tournamentId=1&playerId=P0",
+	}
+	for i := 1; i <= buyers; i++ {
+		paths = append(paths, fmt.Sprintf("/fund?playerId=P%d&points=100", i))
+	}
+	for _, path := range paths {
+		body, status, err := get(url + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+	}
+	body, status, err := get(url + "/offerStake?tournamentId=1&playerId=P0&available=8000&markup=15000")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, status, body)
+
+	// every buyer gets 10 points of stake for 15 points
+	var wg sync.WaitGroup
+	for i := 1; i <= buyers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body, status, err := get(fmt.Sprintf("%s/buyStake?offerId=1&buyerId=P%d&bps=1000", url, i))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusNoContent, status, body)
+		}(i)
+	}
+	wg.Wait()
+
+	tx, err := store.Begin(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer tx.Rollback()
+	tp, err := tx.TournPlayerGet(1, "P0")
+	assert.NoError(t, err)
+	sales, err := tx.TransferLogSelect(db.TransferFilter{
+		TournamentID: 1,
+		Ops:          []core.TransferOp{core.TransferStakeSale},
+	})
+	assert.NoError(t, err)
+
+	// backers of the player match stakes paid in the ledger
+	stakes := make(map[string]int64)
+	for _, b := range tp.Backers {
+		stakes[b.PlayerID] += b.Points
+	}
+	paid := make(map[string]int64)
+	for _, s := range sales {
+		if s.PlayerID != "P0" {
+			paid[s.PlayerID] -= s.Points
+		}
+	}
+	assert.Equal(t, int64(20), stakes["P0"])
+	assert.Len(t, paid, buyers)
+	for i := 1; i <= buyers; i++ {
+		playerID := fmt.Sprintf("P%d", i)
+		assert.Equal(t, int64(10), stakes[playerID], playerID)
+		assert.Equal(t, int64(15), paid[playerID], playerID)
+	}
+}
+
+// request sends request with optional JSON body and idempotency key.
+func request(method, url, key, data string) (string, int, bool, error) {
+	var body io.Reader
+	if data != "" {
+		body = bytes.NewBufferString(data)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if data != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, false, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, false, err
+	}
+	return string(b), resp.StatusCode, resp.Header.Get("Idempotent-Replayed") == "true", nil
+}
+
+func TestV2API(t *testing.T) {
+	store, url, cleanup := newServer(t)
+	defer cleanup()
+	app := newApplication(store)
+
+	steps := []struct {
+		msg      string
+		method   string
+		path     string
+		data     string
+		status   int
+		expected string
+	}{
+		{"deposit P1", "POST", "/v2/players/P1/deposits", `{"points": 300}`, http.StatusCreated, `{"playerId": "P1", "balance": 300}`},
+		{"deposit P2", "POST", "/v2/players/P2/deposits", `{"points": 300}`, http.StatusCreated, `{"playerId": "P2", "balance": 300}`},
+		{"deposit P3", "POST", "/v2/players/P3/deposits", `{"points": 300}`, http.StatusCreated, `{"playerId": "P3", "balance": 300}`},
+		{"withdraw P3", "POST", "/v2/players/P3/withdrawals", `{"points": 100}`, http.StatusCreated, `{"playerId": "P3", "balance": 200}`},
+		{"withdraw too much", "POST", "/v2/players/P3/withdrawals", `{"points": 1000}`, http.StatusConflict, ""},
+		{"negative deposit", "POST", "/v2/players/P3/deposits", `{"points": -1}`, http.StatusBadRequest, ""},
+		{"malformed deposit", "POST", "/v2/players/P3/deposits", `{"points": "1"}`, http.StatusBadRequest, ""},
+		{"get P3", "GET", "/v2/players/P3", "", http.StatusOK, `{"playerId": "P3", "balance": 200}`},
+		{"get unknown player", "GET", "/v2/players/P9", "", http.StatusNotFound, ""},
+		{"create T1", "POST", "/v2/tournaments", `{"id": 1, "entryDeposit": 100, "rakePercent": 10, "payoutTemplate": "winner-takes-all"}`, http.StatusCreated,
+			`{"id": 1, "entryDeposit": 100, "state": "registration_open", "pot": 0, "rakePercent": 10, "houseFee": 0, "payout": {"template": "winner-takes-all"}}`},
+		{"create T1 again", "POST", "/v2/tournaments", `{"id": 1, "entryDeposit": 100}`, http.StatusConflict, ""},
+		{"create T2 announced", "POST", "/v2/tournaments", `{"id": 2, "entryDeposit": 50, "openRegistration": false}`, http.StatusCreated,
+			`{"id": 2, "entryDeposit": 50, "state": "announced", "pot": 0, "rakePercent": 0, "houseFee": 0}`},
+		{"get T2", "GET", "/v2/tournaments/2", "", http.StatusOK,
+			`{"id": 2, "entryDeposit": 50, "state": "announced", "pot": 0, "rakePercent": 0, "houseFee": 0}`},
+		{"get unknown tournament", "GET", "/v2/tournaments/9", "", http.StatusNotFound, ""},
+		{"finish T2 by state", "PUT", "/v2/tournaments/2/state", `{"state": "finished"}`, http.StatusBadRequest, ""},
+		{"cancel T2", "POST", "/v2/tournaments/2/cancellation", "", http.StatusCreated,
+			`{"id": 2, "entryDeposit": 50, "state": "cancelled", "pot": 0, "rakePercent": 0, "houseFee": 0}`},
+		{"enter P1", "POST", "/v2/tournaments/1/entries", `{"playerId": "P1"}`, http.StatusCreated,
+			`{"tournamentId": 1, "playerId": "P1", "fee": 100, "backers": [{"playerId": "P1", "points": 100}]}`},
+		{"enter P1 again", "POST", "/v2/tournaments/1/entries", `{"playerId": "P1"}`, http.StatusConflict, ""},
+		{"enter P3", "POST", "/v2/tournaments/1/entries", `{"playerId": "P3"}`, http.StatusCreated,
+			`{"tournamentId": 1, "playerId": "P3", "fee": 100, "backers": [{"playerId": "P3", "points": 100}]}`},
+		{"withdraw P3 entry", "DELETE", "/v2/tournaments/1/entries/P3", "", http.StatusNoContent, ""},
+		{"get withdrawn entry", "GET", "/v2/tournaments/1/entries/P3", "", http.StatusNotFound, ""},
+		{"enter P2 backed by P3", "POST", "/v2/tournaments/1/entries", `{"playerId": "P2", "backerIds": ["P3"], "stakes": [60, 40]}`, http.StatusAccepted,
+			`{"id": 1, "tournamentId": 1, "playerId": "P2", "status": "pending", "stakes": [{"playerId": "P2", "points": 60, "accepted": true}, {"playerId": "P3", "points": 40, "accepted": false}]}`},
+		{"accept backing", "POST", "/v2/backing-requests/1/responses", `{"backerId": "P3", "accept": true}`, http.StatusCreated,
+			`{"id": 1, "tournamentId": 1, "playerId": "P2", "status": "accepted", "stakes": [{"playerId": "P2", "points": 60, "accepted": true}, {"playerId": "P3", "points": 40, "accepted": true}]}`},
+		{"get P2 entry", "GET", "/v2/tournaments/1/entries/P2", "", http.StatusOK,
+			`{"tournamentId": 1, "playerId": "P2", "fee": 100, "backers": [{"playerId": "P2", "points": 60}, {"playerId": "P3", "points": 40}]}`},
+		{"offer P1 stake", "POST", "/v2/tournaments/1/stake-offers", `{"playerId": "P1", "available": 5000}`, http.StatusCreated,
+			`{"id": 1, "tournamentId": 1, "playerId": "P1", "available": 5000, "markup": 10000}`},
+		{"list offers", "GET", "/v2/tournaments/1/stake-offers", "", http.StatusOK,
+			`[{"id": 1, "tournamentId": 1, "playerId": "P1", "available": 5000, "markup": 10000}]`},
+		{"buy P1 stake", "POST", "/v2/stake-offers/1/purchases", `{"buyerId": "P3", "bps": 2000}`, http.StatusCreated,
+			`{"tournamentId": 1, "playerId": "P1", "fee": 100, "backers": [{"playerId": "P1", "points": 80}, {"playerId": "P3", "points": 20}]}`},
+		{"get offer", "GET", "/v2/stake-offers/1", "", http.StatusOK,
+			`{"id": 1, "tournamentId": 1, "playerId": "P1", "available": 3000, "markup": 10000}`},
+		{"start T1", "PUT", "/v2/tournaments/1/state", `{"state": "running"}`, http.StatusOK,
+			`{"id": 1, "entryDeposit": 100, "state": "running", "pot": 200, "rakePercent": 10, "houseFee": 0, "payout": {"template": "winner-takes-all"}}`},
+		{"result T1", "POST", "/v2/tournaments/1/results", `{"places": ["P1"]}`, http.StatusCreated,
+			`{"id": 1, "entryDeposit": 100, "state": "finished", "pot": 0, "rakePercent": 10, "houseFee": 0, "payout": {"template": "winner-takes-all"}}`},
+		{"result T1 again", "POST", "/v2/tournaments/1/results", `{"places": ["P1"]}`, http.StatusConflict, ""},
+		// P1 paid 100, sold 20% of the stake for 20 and kept 80% of 180
+		// prize left after 10% rake
+		{"get P1", "GET", "/v2/players/P1", "", http.StatusOK, `{"playerId": "P1", "balance": 364}`},
+	}
+	for _, step := range steps {
+		t.Run(step.msg, func(t *testing.T) {
+			body, status, _, err := request(step.method, url+step.path, "", step.data)
+			assert.NoError(t, err)
+			assert.Equal(t, step.status, status, body)
+			if step.expected != "" {
+				assert.JSONEq(t, step.expected, body)
+			}
+		})
+	}
+
+	t.Run("reconcile", func(t *testing.T) {
+		report, err := app.reconcile(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, report.Drifts)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		body, status, _, err := request("POST", url+"/v2/reset", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status, body)
+
+		body, status, _, err = request("GET", url+"/v2/players/P1", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, status, body)
+	})
+}
+
+func TestV2Idempotency(t *testing.T) {
+	_, url, cleanup := newServer(t)
+	defer cleanup()
+
+	body, status, replayed, err := request("POST", url+"/v2/players/P1/deposits", "deposit-1", `{"points": 100}`)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, status, body)
+	assert.False(t, replayed)
+	assert.JSONEq(t, `{"playerId": "P1", "balance": 100}`, body)
+	original := body
+
+	// replays are not affected by later changes
+	_, status, _, err = request("POST", url+"/v2/players/P1/deposits", "deposit-2", `{"points": 50}`)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, status)
+
+	body, status, replayed, err = request("POST", url+"/v2/players/P1/deposits", "deposit-1", `{"points": 100}`)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, status, body)
+	assert.True(t, replayed)
+	assert.Equal(t, original, body)
+
+	body, status, _, err = request("POST", url+"/v2/players/P1/deposits", "deposit-1", `{"points": 200}`)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, status, body)
+}
+
+func TestLegacyAPIDisabled(t *testing.T) {
+	server := httptest.NewServer(mainRouter(newApplication(db.NewMemoryStore()), false, nil))
+	defer server.Close()
+
+	body, status, err := get(server.URL + "/fund?playerId=P1&points=100")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, status, body)
+
+	body, status, _, err = request("POST", server.URL+"/v2/players/P1/deposits", "", `{"points": 100}`)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, status, body)
+}
+
+func TestErrorResponses(t *testing.T) {
+	_, url, cleanup := newServer(t)
+	defer cleanup()
+
+	steps := []struct {
+		msg    string
+		method string
+		path   string
+		data   string
+		status int
+		code   string
+	}{
+		{"missing parameter", "GET", "/fund?points=100", "", http.StatusBadRequest, "INVALID_REQUEST"},
+		{"malformed body", "POST", "/v2/players/P1/deposits", `{"points":`, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"unknown player", "GET", "/v2/players/P1", "", http.StatusNotFound, "PLAYER_NOT_FOUND"},
+		{"negative balance", "POST", "/v2/players/P1/withdrawals", `{"points": 10}`, http.StatusConflict, "NEGATIVE_BALANCE"},
+		{"unknown tournament", "PUT", "/v2/tournaments/1/state", `{"state": "running"}`, http.StatusNotFound, "TOURNAMENT_NOT_FOUND"},
+		{"invalid rake", "POST", "/v2/tournaments", `{"id": 1, "entryDeposit": 100, "rakePercent": 101}`, http.StatusUnprocessableEntity, "INVALID_RAKE"},
+		{"announce T1", "POST", "/v2/tournaments", `{"id": 1, "entryDeposit": 100}`, http.StatusCreated, ""},
+		{"duplicate tournament", "POST", "/v2/tournaments", `{"id": 1, "entryDeposit": 100}`, http.StatusConflict, "DUPLICATE_TOURNAMENT"},
+		{"illegal transition", "PUT", "/v2/tournaments/1/state", `{"state": "registration_open"}`, http.StatusConflict, "ILLEGAL_STATE_TRANSITION"},
+		{"cancel T1", "POST", "/v2/tournaments/1/cancellation", "", http.StatusCreated, ""},
+		{"join cancelled tournament", "POST", "/v2/tournaments/1/entries", `{"playerId": "P1"}`, http.StatusConflict, "TOURNAMENT_CANCELLED"},
+		{"cancel cancelled tournament", "POST", "/v2/tournaments/1/cancellation", "", http.StatusConflict, "TOURNAMENT_CANCELLED"},
+	}
+	for _, step := range steps {
+		t.Run(step.msg, func(t *testing.T) {
+			body, status, _, err := request(step.method, url+step.path, "", step.data)
+			assert.NoError(t, err)
+			assert.Equal(t, step.status, status, body)
+			if step.code == "" {
+				return
+			}
+			var e apiError
+			assert.NoError(t, json.Unmarshal([]byte(body), &e))
+			assert.Equal(t, step.code, e.Code)
+			assert.Equal(t, step.status, e.Status)
+			assert.NotEmpty(t, e.Message)
+			assert.NotEmpty(t, e.RequestID)
+		})
+	}
+
+	t.Run("request ID", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, url+"/v2/players/P1", nil)
+		assert.NoError(t, err)
+		req.Header.Set("X-Request-Id", "req-1")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "req-1", resp.Header.Get("X-Request-Id"))
+
+		var e apiError
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&e))
+		assert.Equal(t, apiError{
+			Code:      "PLAYER_NOT_FOUND",
+			Status:    http.StatusNotFound,
+			Message:   core.ErrPlayerNotFound.Error(),
+			RequestID: "req-1",
+		}, e)
+	})
+
+	t.Run("reused idempotency key", func(t *testing.T) {
+		_, status, _, err := request("POST", url+"/v2/players/P2/deposits", "deposit-2", `{"points": 100}`)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, status)
+
+		body, status, _, err := request("POST", url+"/v2/players/P2/deposits", "deposit-2", `{"points": 200}`)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, status, body)
+		var e apiError
+		assert.NoError(t, json.Unmarshal([]byte(body), &e))
+		assert.Equal(t, "IDEMPOTENCY_KEY_REUSED", e.Code)
+		assert.Equal(t, http.StatusConflict, e.Status)
+	})
+
+	t.Run("malformed body details", func(t *testing.T) {
+		body, _, _, err := request("POST", url+"/v2/players/P1/deposits", "", `{"points": "1"}`)
+		assert.NoError(t, err)
+		var e apiError
+		assert.NoError(t, json.Unmarshal([]byte(body), &e))
+		assert.NotEmpty(t, e.Details)
+	})
+}
+
+// signJWT returns token with given header and claims signed by secret.
+func signJWT(secret, header, claims string) string {
+	token := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(token))
+	return token + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	tests := []struct {
+		keys   []string
+		secret string
+		valid  bool
+	}{
+		{nil, "", false},
+		{nil, "secret", true},
+		{[]string{"k1=admin", "k2=operator", "k3=player:P1"}, "", true},
+		{[]string{"k1"}, "", false},
+		{[]string{"=admin"}, "", false},
+		{[]string{"k1=root"}, "", false},
+		{[]string{"k1=player"}, "", false},
+		{[]string{"k1=player:"}, "", false},
+	}
+	for _, test := range tests {
+		_, err := newAuthenticator(test.keys, test.secret)
+		if test.valid {
+			assert.NoError(t, err, "%v", test.keys)
+		} else {
+			assert.Error(t, err, "%v", test.keys)
+		}
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	store := db.NewMemoryStore()
+	auth, err := newAuthenticator([]string{"admin-key=admin", "p1-key=player:P1", "p2-key=player:P2"}, "secret")
+	assert.NoError(t, err)
+	auth.now = func() time.Time { return time.Unix(1000, 0) }
+	server := httptest.NewServer(mainRouter(newApplication(store), true, auth))
+	defer server.Close()
+
+	hs256 := `{"alg": "HS256", "typ": "JWT"}`
+	operator := "Bearer " + signJWT("secret", hs256, `{"role": "operator", "exp": 2000}`)
+	p3 := "Bearer " + signJWT("secret", hs256, `{"role": "player", "sub": "P3", "exp": 2000}`)
+
+	steps := []struct {
+		msg    string
+		header string
+		value  string
+		method string
+		path   string
+		data   string
+		status int
+	}{
+		{"no credentials", "", "", "GET", "/balance?playerId=P1", "", http.StatusUnauthorized},
+		{"unknown API key", "X-Api-Key", "unknown", "GET", "/balance?playerId=P1", "", http.StatusUnauthorized},
+		{"unsigned token", "Authorization", "Bearer " + signJWT("", `{"alg": "none"}`, `{"role": "admin", "exp": 2000}`), "GET", "/balance?playerId=P1", "", http.StatusUnauthorized},
+		{"token signed by other secret", "Authorization", "Bearer " + signJWT("other", hs256, `{"role": "admin", "exp": 2000}`), "GET", "/balance?playerId=P1", "", http.StatusUnauthorized},
+		{"token without expiration", "Authorization", "Bearer " + signJWT("secret", hs256, `{"role": "admin"}`), "GET", "/balance?playerId=P1", "", http.StatusUnauthorized},
+		{"expired token", "Authorization", "Bearer " + signJWT("secret", hs256, `{"role": "admin", "exp": 970}`), "GET", "/balance?playerId=P1", "", http.StatusUnauthorized},
+		{"token not valid yet", "Authorization", "Bearer " + signJWT("secret", hs256, `{"role": "admin", "exp": 2000, "nbf": 1031}`), "GET", "/balance?playerId=P1", "", http.StatusUnauthorized},
+		{"player token without player", "Authorization", "Bearer " + signJWT("secret", hs256, `{"role": "player", "exp": 2000}`), "GET", "/balance?playerId=P1", "", http.StatusUnauthorized},
+		{"token expired within clock skew", "Authorization", "Bearer " + signJWT("secret", hs256, `{"role": "admin", "exp": 990, "nbf": 1010}`), "GET", "/balance?playerId=P1", "", http.StatusNotFound},
+		{"fund P1 by admin", "X-Api-Key", "admin-key", "GET", "/fund?playerId=P1&points=300", "", http.StatusNoContent},
+		{"fund P2 by operator", "Authorization", operator, "POST", "/v2/players/P2/deposits", `{"points": 300}`, http.StatusCreated},
+		{"fund P3 by operator", "Authorization", operator, "POST", "/v2/players/P3/deposits", `{"points": 300}`, http.StatusCreated},
+		{"fund P1 by P1", "X-Api-Key", "p1-key", "GET", "/fund?playerId=P1&points=300", "", http.StatusForbidden},
+		{"deposit to P1 by P1", "X-Api-Key", "p1-key", "POST", "/v2/players/P1/deposits", `{"points": 300}`, http.StatusForbidden},
+		{"reset by P1", "X-Api-Key", "p1-key", "GET", "/reset", "", http.StatusForbidden},
+		{"announce by P1", "X-Api-Key", "p1-key", "POST", "/v2/tournaments", `{"id": 1, "entryDeposit": 100}`, http.StatusForbidden},
+		{"announce by operator", "Authorization", operator, "POST", "/v2/tournaments", `{"id": 1, "entryDeposit": 100}`, http.StatusCreated},
+		{"balance of P1 by P1", "X-Api-Key", "p1-key", "GET", "/balance?playerId=P1", "", http.StatusOK},
+		{"balance of P2 by P1", "X-Api-Key", "p1-key", "GET", "/balance?playerId=P2", "", http.StatusForbidden},
+		{"v2 balance of P1 by P1", "X-Api-Key", "p1-key", "GET", "/v2/players/P1", "", http.StatusOK},
+		{"v2 balance of P2 by P1", "X-Api-Key", "p1-key", "GET", "/v2/players/P2", "", http.StatusForbidden},
+		{"transactions of P2 by P1", "X-Api-Key", "p1-key", "GET", "/players/P2/transactions", "", http.StatusForbidden},
+		{"tournament by P1", "X-Api-Key", "p1-key", "GET", "/v2/tournaments/1", "", http.StatusOK},
+		{"join P2 by P1", "X-Api-Key", "p1-key", "GET", "/joinTournament?tournamentId=1&playerId=P2", "", http.StatusForbidden},
+		{"join P2 by P1 in body", "X-Api-Key", "p1-key", "POST", "/v2/tournaments/1/entries", `{"playerId": "P2"}`, http.StatusForbidden},
+		{"join P2 by P1 with ambiguous body", "X-Api-Key", "p1-key", "POST", "/v2/tournaments/1/entries", `{"playerId": "P1", "PlayerID": "P2"}`, http.StatusForbidden},
+		{"join P1 by P1", "X-Api-Key", "p1-key", "GET", "/joinTournament?tournamentId=1&playerId=P1", "", http.StatusNoContent},
+		{"join P2 with backer P3 by P2", "X-Api-Key", "p2-key", "POST", "/v2/tournaments/1/entries", `{"playerId": "P2", "backerIds": ["P3"]}`, http.StatusAccepted},
+		{"accept for P3 by P2", "X-Api-Key", "p2-key", "POST", "/v2/backing-requests/1/responses", `{"backerId": "P3", "accept": true}`, http.StatusForbidden},
+		{"accept for P3 by P2 in legacy API", "X-Api-Key", "p2-key", "GET", "/acceptBacking?requestId=1&backerId=P3", "", http.StatusForbidden},
+		{"accept by P3", "Authorization", p3, "POST", "/v2/backing-requests/1/responses", `{"backerId": "P3", "accept": true}`, http.StatusCreated},
+		{"result by P1", "X-Api-Key", "p1-key", "POST", "/v2/tournaments/1/results", `{"winners": [{"playerId": "P1", "prize": 200}]}`, http.StatusForbidden},
+		{"start by operator", "Authorization", operator, "PUT", "/v2/tournaments/1/state", `{"state": "running"}`, http.StatusOK},
+		{"result by operator", "Authorization", operator, "POST", "/v2/tournaments/1/results", `{"winners": [{"playerId": "P1", "prize": 200}]}`, http.StatusCreated},
+		{"reset by admin", "X-Api-Key", "admin-key", "POST", "/v2/reset", "", http.StatusNoContent},
+	}
+	for _, step := range steps {
+		t.Run(step.msg, func(t *testing.T) {
+			var body io.Reader
+			if step.data != "" {
+				body = bytes.NewBufferString(step.data)
+			}
+			req, err := http.NewRequest(step.method, server.URL+step.path, body)
+			assert.NoError(t, err)
+			if step.header != "" {
+				req.Header.Set(step.header, step.value)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+			data, err := ioutil.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, step.status, resp.StatusCode, string(data))
+
+			if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+				var e apiError
+				assert.NoError(t, json.Unmarshal(data, &e))
+				assert.NotEmpty(t, e.RequestID)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeyClients(t *testing.T) {
+	auth, err := newAuthenticator([]string{"admin-key=admin", "operator-key=operator"}, "")
+	assert.NoError(t, err)
+	server := httptest.NewServer(mainRouter(newApplication(db.NewMemoryStore()), true, auth))
+	defer server.Close()
+
+	deposit := func(apiKey string) (string, int, bool) {
+		req, err := http.NewRequest("POST", server.URL+"/v2/players/P1/deposits", bytes.NewBufferString(`{"points": 100}`))
+		assert.NoError(t, err)
+		req.Header.Set("X-Api-Key", apiKey)
+		req.Header.Set("Idempotency-Key", "deposit-1")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return string(data), resp.StatusCode, resp.Header.Get("Idempotent-Replayed") == "true"
+	}
+
+	body, status, replayed := deposit("admin-key")
+	assert.Equal(t, http.StatusCreated, status, body)
+	assert.False(t, replayed)
+
+	// the same key chosen by other client is independent of the first one
+	body, status, replayed = deposit("operator-key")
+	assert.Equal(t, http.StatusCreated, status, body)
+	assert.False(t, replayed)
+	assert.JSONEq(t, `{"playerId": "P1", "balance": 200}`, body)
+
+	body, status, replayed = deposit("admin-key")
+	assert.Equal(t, http.StatusCreated, status, body)
+	assert.True(t, replayed)
+	assert.JSONEq(t, `{"playerId": "P1", "balance": 100}`, body)
+	body, status, replayed = deposit("operator-key")
+	assert.Equal(t, http.StatusCreated, status, body)
+	assert.True(t, replayed)
+	assert.JSONEq(t, `{"playerId": "P1", "balance": 200}`, body)
+}
@@ -0,0 +1,645 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/20170819lgg/sts/core"
+	"github.com/20170819lgg/sts/db"
+	"github.com/Sirupsen/logrus"
+	"github.com/go-zoo/bone"
+	"github.com/pkg/errors"
+)
+
+// tournamentResource is a tournament representation of the v2 API.
+type tournamentResource struct {
+	ID           int                   `json:"id"`
+	EntryDeposit int64                 `json:"entryDeposit"`
+	State        core.TournamentState  `json:"state"`
+	Pot          int64                 `json:"pot"`
+	RakePercent  int                   `json:"rakePercent"`
+	HouseFee     int64                 `json:"houseFee"`
+	Payout       *core.PayoutStructure `json:"payout,omitempty"`
+}
+
+func newTournamentResource(t *core.Tournament) *tournamentResource {
+	return &tournamentResource{
+		ID:           t.ID,
+		EntryDeposit: t.EntryDeposit,
+		State:        t.State,
+		Pot:          t.Pot,
+		RakePercent:  t.RakePercent,
+		HouseFee:     t.HouseFee,
+		Payout:       t.Payout,
+	}
+}
+
+// backerResource is a stake of tournament entry held by the player or one of
+// its backers.
+type backerResource struct {
+	PlayerID string `json:"playerId"`
+	Points   int64  `json:"points"`
+}
+
+// entryResource is a tournament player representation of the v2 API.
+type entryResource struct {
+	TournamentID int              `json:"tournamentId"`
+	PlayerID     string           `json:"playerId"`
+	Fee          int64            `json:"fee"`
+	Backers      []backerResource `json:"backers"`
+}
+
+func newEntryResource(tp *core.TournPlayer) *entryResource {
+	e := &entryResource{
+		TournamentID: tp.TournamentID,
+		PlayerID:     tp.PlayerID,
+		Fee:          tp.Fee,
+		Backers:      make([]backerResource, len(tp.Backers)),
+	}
+	for i, b := range tp.Backers {
+		e.Backers[i] = backerResource{PlayerID: b.PlayerID, Points: b.Points}
+	}
+	return e
+}
+
+// decodeBody decodes JSON request body into v.
+func decodeBody(r *http.Request, v interface{}) error {
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// presentPlayer presents player changed by the request.
+func presentPlayer(status int, playerID string) presenter {
+	return func(tx db.Tx) (*apiResponse, error) {
+		player, err := tx.PlayerGet(playerID)
+		if err != nil {
+			return nil, errors.WithMessage(err, "getting player")
+		}
+		return respJSON(status, player)
+	}
+}
+
+// presentTournament presents tournament changed by the request.
+func presentTournament(status int, tournamentID int) presenter {
+	return func(tx db.Tx) (*apiResponse, error) {
+		t, err := tx.TournamentGet(tournamentID)
+		if err != nil {
+			return nil, errors.WithMessage(err, "getting tournament")
+		}
+		return respJSON(status, newTournamentResource(t))
+	}
+}
+
+// presentEntry presents tournament player changed by the request.
+func presentEntry(status int, tournamentID int, playerID string) presenter {
+	return func(tx db.Tx) (*apiResponse, error) {
+		tp, err := tx.TournPlayerGet(tournamentID, playerID)
+		if err != nil {
+			return nil, errors.WithMessage(err, "getting tournament player")
+		}
+		return respJSON(status, newEntryResource(tp))
+	}
+}
+
+// v2Routes routes the v2 API, which takes parameters of changes in JSON
+// request bodies and responds with representations of changed resources.
+func v2Routes(mux *bone.Mux, app *application) {
+	mux.GetFunc("/v2/players/:playerId", func(w http.ResponseWriter, r *http.Request) {
+		playerID := bone.GetValue(r, "playerId")
+		player, err := app.balance(r.Context(), playerID)
+		if err != nil {
+			logrus.WithField("playerID", playerID).WithError(err).Error("getting player balance")
+			respondUnexpected(w, r)
+			return
+		}
+		if player == nil {
+			respondError(w, r, core.ErrPlayerNotFound)
+			return
+		}
+		respondJSON(w, player)
+	})
+
+	// deposits increase and withdrawals reduce player account balance
+	for path, sign := range map[string]int64{
+		"/v2/players/:playerId/deposits":    1,
+		"/v2/players/:playerId/withdrawals": -1,
+	} {
+		sign := sign
+		mux.PostFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			key, err := idempotencyKey(r)
+			if err != nil {
+				respondError(w, r, badRequest("invalid Idempotency-Key header"))
+				return
+			}
+			playerID := bone.GetValue(r, "playerId")
+			var data struct {
+				Points int64 `json:"points"`
+			}
+			if err := decodeBody(r, &data); err != nil {
+				respondError(w, r, badRequest("invalid JSON body", err.Error()))
+				return
+			}
+			if data.Points < 0 {
+				respondError(w, r, badRequest("invalid points field"))
+				return
+			}
+
+			resp, err := app.addPoints(r.Context(), key, presentPlayer(http.StatusCreated, playerID), playerID, sign*data.Points)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"playerID": playerID,
+					"points":   sign * data.Points,
+				}).WithError(err).Error("changing player account balance")
+				respondUnexpected(w, r)
+				return
+			}
+			respondStatus(w, r, *resp)
+		})
+	}
+
+	mux.GetFunc("/v2/players/:playerId/transactions", playerTransactionsHandler(app))
+	mux.GetFunc("/v2/players/:playerId/backings", playerBackingsHandler(app))
+	mux.GetFunc("/v2/players/:playerId/backing-requests", playerBackingRequestsHandler(app))
+
+	mux.PostFunc("/v2/tournaments", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
+		var data struct {
+			ID               int     `json:"id"`
+			EntryDeposit     int64   `json:"entryDeposit"`
+			RakePercent      int     `json:"rakePercent"`
+			HouseFee         int64   `json:"houseFee"`
+			Payout           []int64 `json:"payout"`
+			PayoutTemplate   string  `json:"payoutTemplate"`
+			OpenRegistration *bool   `json:"openRegistration"`
+		}
+		if err := decodeBody(r, &data); err != nil {
+			respondError(w, r, badRequest("invalid JSON body", err.Error()))
+			return
+		}
+		if data.EntryDeposit <= 0 {
+			respondError(w, r, badRequest("invalid entryDeposit field"))
+			return
+		}
+		opts := announceOptions{
+			RakePercent:      data.RakePercent,
+			HouseFee:         data.HouseFee,
+			OpenRegistration: data.OpenRegistration == nil || *data.OpenRegistration,
+		}
+		switch {
+		case len(data.Payout) > 0 && data.PayoutTemplate != "":
+			respondError(w, r, badRequest("payout and payoutTemplate fields are exclusive"))
+			return
+		case len(data.Payout) > 0:
+			opts.Payout = &core.PayoutStructure{Percentages: data.Payout}
+		case data.PayoutTemplate != "":
+			opts.Payout = &core.PayoutStructure{Template: data.PayoutTemplate}
+		}
+
+		resp, err := app.announceTournament(r.Context(), key, presentTournament(http.StatusCreated, data.ID), data.ID, data.EntryDeposit, opts)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"tournamentID": data.ID,
+				"deposit":      data.EntryDeposit,
+				"rakePercent":  opts.RakePercent,
+				"houseFee":     opts.HouseFee,
+				"payout":       opts.Payout,
+			}).WithError(err).Error("creating tournament")
+			respondUnexpected(w, r)
+			return
+		}
+		respondStatus(w, r, *resp)
+	})
+
+	mux.GetFunc("/v2/tournaments/:tournamentId", func(w http.ResponseWriter, r *http.Request) {
+		tournamentID, err := strconv.Atoi(bone.GetValue(r, "tournamentId"))
+		if err != nil {
+			respondError(w, r, badRequest("invalid tournament ID"))
+			return
+		}
+		t, err := app.tournament(r.Context(), tournamentID)
+		if err != nil {
+			logrus.WithField("tournamentID", tournamentID).WithError(err).Error("getting tournament")
+			respondUnexpected(w, r)
+			return
+		}
+		if t == nil {
+			respondError(w, r, core.ErrTournamentNotFound)
+			return
+		}
+		respondJSON(w, newTournamentResource(t))
+	})
+
+	mux.PutFunc("/v2/tournaments/:tournamentId/state", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
+		tournamentID, err := strconv.Atoi(bone.GetValue(r, "tournamentId"))
+		if err != nil {
+			respondError(w, r, badRequest("invalid tournament ID"))
+			return
+		}
+		var data struct {
+			State core.TournamentState `json:"state"`
+		}
+		if err := decodeBody(r, &data); err != nil {
+			respondError(w, r, badRequest("invalid JSON body", err.Error()))
+			return
+		}
+		// finished and cancelled states are reached by resulting and
+		// cancelling the tournament
+		switch data.State {
+		case core.TournamentRegistrationOpen, core.TournamentRegistrationClosed, core.TournamentRunning:
+		default:
+			respondError(w, r, badRequest("invalid state field"))
+			return
+		}
+
+		resp, err := app.transitionTournament(r.Context(), key, presentTournament(http.StatusOK, tournamentID), tournamentID, data.State)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"tournamentID": tournamentID,
+				"state":        data.State,
+			}).WithError(err).Error("changing tournament state")
+			respondUnexpected(w, r)
+			return
+		}
+		respondStatus(w, r, *resp)
+	})
+
+	mux.PostFunc("/v2/tournaments/:tournamentId/entries", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
+		tournamentID, err := strconv.Atoi(bone.GetValue(r, "tournamentId"))
+		if err != nil {
+			respondError(w, r, badRequest("invalid tournament ID"))
+			return
+		}
+		var data struct {
+			PlayerID  string    `json:"playerId"`
+			BackerIDs []string  `json:"backerIds"`
+			Stakes    []int64   `json:"stakes"`
+			StakeUnit stakeUnit `json:"stakeUnit"`
+		}
+		if err := decodeBody(r, &data); err != nil {
+			respondError(w, r, badRequest("invalid JSON body", err.Error()))
+			return
+		}
+		if data.PlayerID == "" {
+			respondError(w, r, badRequest("missing playerId field"))
+			return
+		}
+		if len(data.Stakes) > 0 && len(data.Stakes) != len(data.BackerIDs)+1 {
+			respondError(w, r, badRequest("stakes must be given for the player and every backer"))
+			return
+		}
+		switch data.StakeUnit {
+		case "":
+			data.StakeUnit = stakePoints
+		case stakePoints, stakeBasisPoints:
+		default:
+			respondError(w, r, badRequest("invalid stakeUnit field"))
+			return
+		}
+
+		// entries with backers are accepted as backing requests and
+		// responded with the request instead
+		present := presentEntry(http.StatusCreated, tournamentID, data.PlayerID)
+		resp, err := app.joinTournament(r.Context(), key, present, tournamentID, data.PlayerID, data.BackerIDs, data.Stakes, data.StakeUnit)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"tournamentID": tournamentID,
+				"playerID":     data.PlayerID,
+				"backedIDs":    data.BackerIDs,
+				"stakes":       data.Stakes,
+				"stakeUnit":    data.StakeUnit,
+			}).WithError(err).Error("joining player to tournament")
+			respondUnexpected(w, r)
+			return
+		}
+		respondStatus(w, r, *resp)
+	})
+
+	mux.GetFunc("/v2/tournaments/:tournamentId/entries/:playerId", func(w http.ResponseWriter, r *http.Request) {
+		tournamentID, err := strconv.Atoi(bone.GetValue(r, "tournamentId"))
+		if err != nil {
+			respondError(w, r, badRequest("invalid tournament ID"))
+			return
+		}
+		playerID := bone.GetValue(r, "playerId")
+		tp, err := app.tournPlayer(r.Context(), tournamentID, playerID)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"tournamentID": tournamentID,
+				"playerID":     playerID,
+			}).WithError(err).Error("getting tournament player")
+			respondUnexpected(w, r)
+			return
+		}
+		if tp == nil {
+			respondError(w, r, core.ErrTournPlayerNotFound)
+			return
+		}
+		respondJSON(w, newEntryResource(tp))
+	})
+
+	mux.DeleteFunc("/v2/tournaments/:tournamentId/entries/:playerId", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
+		tournamentID, err := strconv.Atoi(bone.GetValue(r, "tournamentId"))
+		if err != nil {
+			respondError(w, r, badRequest("invalid tournament ID"))
+			return
+		}
+		playerID := bone.GetValue(r, "playerId")
+
+		resp, err := app.leaveTournament(r.Context(), key, tournamentID, playerID)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"tournamentID": tournamentID,
+				"playerID":     playerID,
+			}).WithError(err).Error("withdrawing player from tournament")
+			respondUnexpected(w, r)
+			return
+		}
+		respondStatus(w, r, *resp)
+	})
+
+	mux.GetFunc("/v2/tournaments/:tournamentId/stake-offers", func(w http.ResponseWriter, r *http.Request) {
+		tournamentID, err := strconv.Atoi(bone.GetValue(r, "tournamentId"))
+		if err != nil {
+			respondError(w, r, badRequest("invalid tournament ID"))
+			return
+		}
+
+		offers, err := app.stakeOffers(r.Context(), tournamentID)
+		if err != nil {
+			logrus.WithField("tournamentID", tournamentID).WithError(err).Error("listing stake offers")
+			respondUnexpected(w, r)
+			return
+		}
+		respondJSON(w, offers)
+	})
+
+	mux.PostFunc("/v2/tournaments/:tournamentId/stake-offers", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
+		tournamentID, err := strconv.Atoi(bone.GetValue(r, "tournamentId"))
+		if err != nil {
+			respondError(w, r, badRequest("invalid tournament ID"))
+			return
+		}
+		var data struct {
+			PlayerID  string `json:"playerId"`
+			Available int64  `json:"available"`
+			Markup    *int64 `json:"markup"`
+		}
+		if err := decodeBody(r, &data); err != nil {
+			respondError(w, r, badRequest("invalid JSON body", err.Error()))
+			return
+		}
+		if data.PlayerID == "" {
+			respondError(w, r, badRequest("missing playerId field"))
+			return
+		}
+		markup := int64(10000)
+		if data.Markup != nil {
+			markup = *data.Markup
+		}
+
+		resp, err := app.offerStake(r.Context(), key, tournamentID, data.PlayerID, data.Available, markup)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"tournamentID": tournamentID,
+				"playerID":     data.PlayerID,
+				"available":    data.Available,
+				"markup":       markup,
+			}).WithError(err).Error("offering stake")
+			respondUnexpected(w, r)
+			return
+		}
+		respondStatus(w, r, *resp)
+	})
+
+	mux.GetFunc("/v2/stake-offers/:offerId", func(w http.ResponseWriter, r *http.Request) {
+		offerID, err := strconv.ParseInt(bone.GetValue(r, "offerId"), 10, 64)
+		if err != nil {
+			respondError(w, r, badRequest("invalid stake offer ID"))
+			return
+		}
+		offer, err := app.stakeOffer(r.Context(), offerID)
+		if err != nil {
+			logrus.WithField("offerID", offerID).WithError(err).Error("getting stake offer")
+			respondUnexpected(w, r)
+			return
+		}
+		if offer == nil {
+			respondError(w, r, core.ErrStakeOfferNotFound)
+			return
+		}
+		respondJSON(w, offer)
+	})
+
+	mux.PostFunc("/v2/stake-offers/:offerId/purchases", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
+		offerID, err := strconv.ParseInt(bone.GetValue(r, "offerId"), 10, 64)
+		if err != nil {
+			respondError(w, r, badRequest("invalid stake offer ID"))
+			return
+		}
+		var data struct {
+			BuyerID string `json:"buyerId"`
+			BPS     int64  `json:"bps"`
+		}
+		if err := decodeBody(r, &data); err != nil {
+			respondError(w, r, badRequest("invalid JSON body", err.Error()))
+			return
+		}
+		if data.BuyerID == "" {
+			respondError(w, r, badRequest("missing buyerId field"))
+			return
+		}
+		if data.BPS <= 0 {
+			respondError(w, r, badRequest("invalid bps field"))
+			return
+		}
+
+		// purchased stake is represented by the entry it is part of
+		present := func(tx db.Tx) (*apiResponse, error) {
+			offer, err := tx.StakeOfferGet(offerID)
+			if err != nil {
+				return nil, errors.WithMessage(err, "getting stake offer")
+			}
+			return presentEntry(http.StatusCreated, offer.TournamentID, offer.PlayerID)(tx)
+		}
+		resp, err := app.buyStake(r.Context(), key, present, offerID, data.BuyerID, data.BPS)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"offerID": offerID,
+				"buyerID": data.BuyerID,
+				"bps":     data.BPS,
+			}).WithError(err).Error("buying stake")
+			respondUnexpected(w, r)
+			return
+		}
+		respondStatus(w, r, *resp)
+	})
+
+	mux.GetFunc("/v2/backing-requests/:requestId", func(w http.ResponseWriter, r *http.Request) {
+		requestID, err := strconv.ParseInt(bone.GetValue(r, "requestId"), 10, 64)
+		if err != nil {
+			respondError(w, r, badRequest("invalid backing request ID"))
+			return
+		}
+		req, err := app.backingRequest(r.Context(), requestID)
+		if err != nil {
+			logrus.WithField("requestID", requestID).WithError(err).Error("getting backing request")
+			respondUnexpected(w, r)
+			return
+		}
+		if req == nil {
+			respondError(w, r, core.ErrBackingRequestNotFound)
+			return
+		}
+		respondJSON(w, req)
+	})
+
+	mux.PostFunc("/v2/backing-requests/:requestId/responses", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
+		requestID, err := strconv.ParseInt(bone.GetValue(r, "requestId"), 10, 64)
+		if err != nil {
+			respondError(w, r, badRequest("invalid backing request ID"))
+			return
+		}
+		var data struct {
+			BackerID string `json:"backerId"`
+			Accept   bool   `json:"accept"`
+		}
+		if err := decodeBody(r, &data); err != nil {
+			respondError(w, r, badRequest("invalid JSON body", err.Error()))
+			return
+		}
+		if data.BackerID == "" {
+			respondError(w, r, badRequest("missing backerId field"))
+			return
+		}
+
+		present := func(tx db.Tx) (*apiResponse, error) {
+			req, err := tx.BackingRequestGet(requestID)
+			if err != nil {
+				return nil, errors.WithMessage(err, "getting backing request")
+			}
+			return respJSON(http.StatusCreated, req)
+		}
+		resp, err := app.respondBacking(r.Context(), key, present, requestID, data.BackerID, data.Accept)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"requestID": requestID,
+				"backerID":  data.BackerID,
+				"accept":    data.Accept,
+			}).WithError(err).Error("responding to backing request")
+			respondUnexpected(w, r)
+			return
+		}
+		respondStatus(w, r, *resp)
+	})
+
+	mux.PostFunc("/v2/tournaments/:tournamentId/results", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
+		tournamentID, err := strconv.Atoi(bone.GetValue(r, "tournamentId"))
+		if err != nil {
+			respondError(w, r, badRequest("invalid tournament ID"))
+			return
+		}
+		var data struct {
+			Winners []struct {
+				PlayerID string `json:"playerId"`
+				Prize    int64  `json:"prize"`
+			} `json:"winners"`
+			Places  []core.Place `json:"places"`
+			Overlay bool         `json:"overlay"`
+		}
+		if err := decodeBody(r, &data); err != nil {
+			respondError(w, r, badRequest("invalid JSON body", err.Error()))
+			return
+		}
+		if len(data.Winners) > 0 && len(data.Places) > 0 {
+			respondError(w, r, badRequest("winners and places are exclusive"))
+			return
+		}
+
+		winners := make(map[string]int64)
+		for _, wn := range data.Winners {
+			winners[wn.PlayerID] = wn.Prize
+		}
+
+		resp, err := app.resultTroutnament(r.Context(), key, presentTournament(http.StatusCreated, tournamentID), tournamentID, winners, data.Places, data.Overlay)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"tournamentID": tournamentID,
+				"winners":      winners,
+				"places":       data.Places,
+				"overlay":      data.Overlay,
+			}).WithError(err).Error("resulting tournament")
+			respondUnexpected(w, r)
+			return
+		}
+		respondStatus(w, r, *resp)
+	})
+
+	mux.PostFunc("/v2/tournaments/:tournamentId/cancellation", func(w http.ResponseWriter, r *http.Request) {
+		key, err := idempotencyKey(r)
+		if err != nil {
+			respondError(w, r, badRequest("invalid Idempotency-Key header"))
+			return
+		}
+		tournamentID, err := strconv.Atoi(bone.GetValue(r, "tournamentId"))
+		if err != nil {
+			respondError(w, r, badRequest("invalid tournament ID"))
+			return
+		}
+
+		resp, err := app.cancelTournament(r.Context(), key, presentTournament(http.StatusCreated, tournamentID), tournamentID)
+		if err != nil {
+			logrus.WithField("tournamentID", tournamentID).WithError(err).Error("cancelling tournament")
+			respondUnexpected(w, r)
+			return
+		}
+		respondStatus(w, r, *resp)
+	})
+
+	mux.PostFunc("/v2/reset", func(w http.ResponseWriter, r *http.Request) {
+		if err := app.reset(r.Context()); err != nil {
+			logrus.WithError(err).Error("resetting database")
+			respondUnexpected(w, r)
+			return
+		}
+		respondStatus(w, r, *respOK())
+	})
+}